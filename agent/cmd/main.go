@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,14 +14,22 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/doniyusdinar/config-management/agent/internal/admin"
+	"github.com/doniyusdinar/config-management/agent/internal/backoff"
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
 	"github.com/doniyusdinar/config-management/agent/internal/config"
+	"github.com/doniyusdinar/config-management/agent/internal/discovery"
+	"github.com/doniyusdinar/config-management/agent/internal/enroll"
 	"github.com/doniyusdinar/config-management/agent/internal/poller"
+	"github.com/doniyusdinar/config-management/agent/internal/reload"
+	"github.com/doniyusdinar/config-management/agent/internal/supervisor"
 	"github.com/doniyusdinar/config-management/agent/internal/worker"
 	"github.com/doniyusdinar/config-management/pkg/auth"
 	"github.com/doniyusdinar/config-management/pkg/logger"
 	"github.com/doniyusdinar/config-management/pkg/models"
-	"github.com/doniyusdinar/config-management/pkg/redis"
+	"github.com/doniyusdinar/config-management/pkg/mtls"
 	"github.com/doniyusdinar/config-management/pkg/nats"
+	"github.com/doniyusdinar/config-management/pkg/signing"
 )
 
 func main() {
@@ -30,110 +41,365 @@ func main() {
 	logger.SetLevel(cfg.LogLevel)
 	logger.Log.Info("Starting Configuration Management Agent")
 
-	agentID, pollURL, pollInterval, err := registerWithController(cfg)
-	if err != nil {
-		logger.Log.Fatalf("Failed to register with controller: %v", err)
+	// Load client certificates for mTLS to the controller and worker, if configured
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mtlsConfig := mtls.Config{
+		CertFile:       cfg.TLSCertFile,
+		KeyFile:        cfg.TLSKeyFile,
+		CAFile:         cfg.TLSCAFile,
+		ServerName:     cfg.TLSServerName,
+		ReloadInterval: time.Duration(cfg.TLSReloadInterval) * time.Second,
+	}
+
+	// Not enrolled yet: connect with the CA bundle only until a certificate is issued
+	enrolling := cfg.EnrollmentToken != "" && !enroll.HasCertificate(cfg.TLSCertFile, cfg.TLSKeyFile)
+	bootstrapConfig := mtlsConfig
+	if enrolling {
+		bootstrapConfig.CertFile, bootstrapConfig.KeyFile = "", ""
+	}
+
+	var reloader *mtls.Reloader
+	var tlsConfig *tls.Config
+	if bootstrapConfig.Enabled() {
+		reloader, err = mtls.NewReloader(bootstrapConfig)
+		if err != nil {
+			logger.Log.Fatalf("Failed to load TLS certificates: %v", err)
+		}
+		tlsConfig = reloader.ClientTLSConfig()
+	}
+
+	// Only forward configurations signed by the pinned controller key
+	var verifier *signing.Verifier
+	if cfg.SigningPublicKeyFile != "" {
+		verifier, err = signing.LoadVerifier(cfg.SigningPublicKeyFile)
+		if err != nil {
+			logger.Log.Fatalf("Failed to load signing public key: %v", err)
+		}
+		logger.Log.Infof("Configuration signatures verified against %s", cfg.SigningPublicKeyFile)
+	} else {
+		logger.Log.Warn("SIGNING_PUBLIC_KEY_FILE not set, configuration signatures are NOT verified")
+	}
+
+	// Stop on a signal, also while still waiting for the controller
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	registerTimeout := time.Duration(cfg.RegisterTimeout) * time.Second
+
+	var enroller *enroll.Enroller
+	if cfg.EnrollmentToken != "" {
+		enroller = enroll.NewEnroller(cfg.ControllerURL, cfg.EnrollmentToken, cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSCAFile, tlsConfig)
+	}
+
+	var registration *models.RegisterResponse
+	if enrolling {
+		// Workers are reached with the issued certificate, so there is nothing
+		// to serve before the agent is enrolled
+		registration, err = registerWithRetry(cfg, tlsConfig, registerTimeout, quit)
+		if errors.Is(err, errInterrupted) {
+			return
+		}
+		if err != nil {
+			logger.Log.Fatalf("Failed to register with controller: %v", err)
+		}
+		if err := enroller.EnsureCertificate(registration.AgentID); err != nil {
+			logger.Log.Fatalf("Failed to enroll with controller CA: %v", err)
+		}
+
+		// Switch to the issued certificate; renewals keep it fresh from here on
+		reloader, err = mtls.NewReloader(mtlsConfig)
+		if err != nil {
+			logger.Log.Fatalf("Failed to load TLS certificates: %v", err)
+		}
+		tlsConfig = reloader.ClientTLSConfig()
+	}
+
+	workerMgr := worker.NewMultiManager(cfg.Workers, tlsConfig)
+	go workerMgr.Start(ctx)
+	var discoverer *discovery.Discoverer
+	if cfg.DiscoveryEnabled() {
+		sources, err := discoverySources(cfg)
+		if err != nil {
+			logger.Log.Fatalf("Failed to set up worker discovery: %v", err)
+		}
+		discoverer = discovery.NewDiscoverer(workerMgr, cfg.Workers, sources, time.Duration(cfg.DiscoveryInterval)*time.Second)
+		discoverer.Refresh(ctx)
+		go discoverer.Start(ctx)
+	}
+	logger.Log.Infof("Managing %d worker(s)", len(workerMgr.Targets()))
+	supervised := make(chan struct{})
+	var sup *supervisor.Supervisor
+	if cfg.WorkerCommand != "" {
+		// Push the current config to the worker after every (re)start
+		sup = supervisor.NewSupervisor(cfg.WorkerCommand, cfg.WorkerArgs, cfg.WorkerEnv, cfg.WorkerURL+"/health",
+			time.Duration(cfg.WorkerStartTimeout)*time.Second, tlsConfig, func() {
+				if result, ok := workerMgr.Resend(config.SupervisedWorker); ok && !result.Success {
+					logger.Log.Errorf("Failed to push config to restarted worker: %s", result.Error)
+				}
+			})
+		go func() {
+			sup.Start(ctx)
+			close(supervised)
+		}()
+	} else {
+		close(supervised)
+	}
+	if cfg.ReconcileInterval > 0 {
+		go workerMgr.StartReconciler(ctx, time.Duration(cfg.ReconcileInterval)*time.Second)
+	}
+
+	store := cache.New(cfg.CacheFile, cfg.CacheRetain, verifier)
+	if registration == nil {
+		// Keep the workers on the cached config until the controller answers
+		serveCache(store, workerMgr)
+		registration, err = registerWithRetry(cfg, tlsConfig, registerTimeout, quit)
+		if err != nil {
+			if !errors.Is(err, errInterrupted) {
+				logger.Log.Errorf("Failed to register with controller: %v", err)
+			}
+			cancel()
+			<-supervised
+			if errors.Is(err, errInterrupted) {
+				return
+			}
+			os.Exit(1)
+		}
+		if enroller != nil {
+			// Renews the certificate if it was issued for another agent ID
+			if err := enroller.EnsureCertificate(registration.AgentID); err != nil {
+				logger.Log.Fatalf("Failed to enroll with controller CA: %v", err)
+			}
+			if err := reloader.Reload(); err != nil {
+				logger.Log.Fatalf("Failed to load TLS certificates: %v", err)
+			}
+		}
+	}
+	agentID := registration.AgentID
+
+	if enroller != nil {
+		enroller.Attach(reloader)
+		go enroller.Start(ctx)
+	}
+
+	if reloader != nil {
+		go reloader.Start(ctx)
+		logger.Log.Info("mTLS enabled for controller and worker connections")
 	}
 
 	logger.Log.Infof("Registered with controller - Agent ID: %s", agentID)
-	logger.Log.Infof("Poll URL: %s, Interval: %d seconds", pollURL, pollInterval)
-
-	workerMgr := worker.NewManager(cfg.WorkerURL)
-
-	// Create Redis config for strategy
-	redisConfig := redis.Config{
-		Address:  cfg.RedisAddress,
-		Password: cfg.RedisPassword,
-		DB:       cfg.RedisDB,
-		Enabled:  true, // Always enabled for Redis strategy
-	}
-
-	// Create NATS config for strategy
-	natsConfig := nats.Config{
-		URLs:            strings.Split(cfg.NatsURL, ","),
-		Username:        cfg.NatsUsername,
-		Password:        cfg.NatsPassword,
-		Token:           cfg.NatsToken,
-		TLSEnabled:      cfg.NatsTLSEnabled,
-		MaxReconnect:    10,
-		ReconnectWait:   2 * time.Second,
-		ConnectionName:  fmt.Sprintf("config-agent-%s", getHostname()),
-		Subject:         cfg.NatsSubject,
-		QueueGroup:      cfg.NatsQueueGroup,
-		Enabled:         true, // Always enabled for NATS strategy
-	}
-
-	// Determine distribution strategy
-	strategy := poller.DistributionStrategy(cfg.DistributionStrategy)
-	
-	// Create distribution manager with the specified strategy
-	distributionMgr, err := poller.NewDistributionManager(
-		strategy,
-		cfg.ControllerURL,
-		cfg.ControllerUsername,
-		cfg.ControllerPassword,
-		workerMgr,
-		cfg.CacheFile,
-		redisConfig,
-		natsConfig,
-	)
+	logger.Log.Infof("Poll URL: %s, Interval: %d seconds", registration.PollURL, registration.PollIntervalSecs)
+
+	rt := &agentRuntime{
+		tlsConfig:    tlsConfig,
+		verifier:     verifier,
+		workerMgr:    workerMgr,
+		discoverer:   discoverer,
+		store:        store,
+		cfg:          cfg,
+		registration: registration,
+	}
+	distributionMgr, err := rt.newDistributionManager(cfg, registration)
 	if err != nil {
 		logger.Log.Fatalf("Failed to create distribution manager: %v", err)
 	}
+	rt.start(distributionMgr)
 
+	// Apply edits to the settings file without a restart
+	settings := reload.NewReloader(config.File(), cfg, config.LoadConfig, rt.apply)
 	go func() {
-		if err := distributionMgr.Start(); err != nil {
-			logger.Log.Errorf("Distribution manager error: %v", err)
+		if err := settings.Start(ctx); err != nil {
+			logger.Log.Warnf("Settings reload disabled: %v", err)
 		}
 	}()
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	if cfg.AdminAddr != "" {
+		adminServer := admin.NewServer(cfg.AdminAddr, rt, workerMgr, sup, rt.reregister)
+		go func() {
+			if err := adminServer.Start(ctx); err != nil {
+				logger.Log.Errorf("Admin API error: %v", err)
+			}
+		}()
+	}
+
 	<-quit
 
 	logger.Log.Info("Shutting down agent...")
-	distributionMgr.Stop()
+	rt.stop()
+	cancel()
+	<-supervised
 	logger.Log.Info("Agent exited")
 }
 
-func registerWithController(cfg *config.Config) (string, string, int, error) {
+// errInterrupted is returned when the agent is stopped while it waits for the controller
+var errInterrupted = errors.New("interrupted")
+
+// registerWithRetry registers the agent, retrying with backoff until the
+// controller answers, timeout passes (0 retries forever) or a signal arrives.
+// An attempt in flight is cancelled by either, so a hanging controller cannot
+// hold the agent past its deadline.
+func registerWithRetry(cfg *config.Config, tlsConfig *tls.Config, timeout time.Duration, quit <-chan os.Signal) (*models.RegisterResponse, error) {
+	retry := backoff.New(1*time.Second, time.Minute, 2.0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		defer cancelTimeout()
+	}
+
+	interrupted := make(chan struct{})
+	go func() {
+		select {
+		case <-quit:
+			close(interrupted)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		registration, err := register(ctx, cfg, newNatsConfig(cfg), tlsConfig)
+		if err == nil {
+			return registration, nil
+		}
+
+		select {
+		case <-interrupted:
+			logger.Log.Info("Shutting down agent...")
+			return nil, errInterrupted
+		default:
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("gave up after %v: %w", timeout, err)
+		}
+
+		wait := retry.Next()
+		if deadline, ok := ctx.Deadline(); ok && wait > time.Until(deadline) {
+			wait = time.Until(deadline)
+		}
+		logger.Log.Warnf("Failed to register with controller, retrying in %v: %v", wait.Round(time.Millisecond), err)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// serveCache forwards the newest cached config to the workers, so they run on
+// it while the agent waits for the controller
+func serveCache(store *cache.Cache, workerMgr *worker.Manager) {
+	cached, err := store.Load()
+	if err != nil {
+		logger.Log.Info("No cached config to serve until the controller answers")
+		return
+	}
+
+	logger.Log.Infof("Serving cached config version %d until the controller answers", cached.Version)
+	if _, err := workerMgr.Forward(cached.Version, cached.Data, ""); err != nil {
+		logger.Log.Errorf("Failed to forward cached config to workers, delivery queued: %v", err)
+	}
+}
+
+// register registers the agent over the configured controller transport.
+// NATS requests are bounded by their own timeout.
+func register(ctx context.Context, cfg *config.Config, natsConfig nats.Config, tlsConfig *tls.Config) (*models.RegisterResponse, error) {
+	if cfg.ControllerTransport == "NATS" {
+		return registerOverNats(cfg, natsConfig)
+	}
+	return registerWithController(ctx, cfg, tlsConfig)
+}
+
+func registerWithController(ctx context.Context, cfg *config.Config, tlsConfig *tls.Config) (*models.RegisterResponse, error) {
 	url := fmt.Sprintf("%s/api/v1/register", cfg.ControllerURL)
 
 	req := models.RegisterRequest{
 		Hostname: getHostname(),
-		Metadata: fmt.Sprintf("worker_url=%s", cfg.WorkerURL),
+		Metadata: workerMetadata(cfg),
 	}
 
 	reqBody, err := json.Marshal(req)
 	if err != nil {
-		return "", "", 0, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(reqBody))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return "", "", 0, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Authorization", auth.CreateBasicAuthHeader(cfg.ControllerUsername, cfg.ControllerPassword))
 
-	client := &http.Client{}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
 	resp, err := client.Do(httpReq)
 	if err != nil {
-		return "", "", 0, fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", "", 0, fmt.Errorf("controller returned status %d", resp.StatusCode)
+		return nil, fmt.Errorf("controller returned status %d", resp.StatusCode)
 	}
 
 	var registerResp models.RegisterResponse
 	if err := json.NewDecoder(resp.Body).Decode(&registerResp); err != nil {
-		return "", "", 0, fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	return registerResp.AgentID, registerResp.PollURL, registerResp.PollIntervalSecs, nil
+	return &registerResp, nil
+}
+
+// registerOverNats registers through the controller's NATS responder, for
+// agents without an HTTP path to the controller
+func registerOverNats(cfg *config.Config, natsConfig nats.Config) (*models.RegisterResponse, error) {
+	client := nats.NewClient(natsConfig)
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	req := models.RegisterRequest{
+		Hostname: getHostname(),
+		Metadata: workerMetadata(cfg),
+	}
+
+	return poller.RegisterOverNats(client, auth.CreateBasicAuthHeader(cfg.ControllerUsername, cfg.ControllerPassword), req)
+}
+
+// discoverySources builds the configured worker discovery sources
+func discoverySources(cfg *config.Config) ([]discovery.Source, error) {
+	var sources []discovery.Source
+	if cfg.DiscoveryFile != "" {
+		sources = append(sources, discovery.NewFileSource(cfg.DiscoveryFile))
+	}
+	if cfg.DiscoverySRV != "" {
+		sources = append(sources, discovery.NewSRVSource(cfg.DiscoverySRV, cfg.DiscoveryScheme, cfg.DiscoveryDNS))
+	}
+	if cfg.DiscoveryDocker {
+		docker, err := discovery.NewDockerSource(cfg.DockerHost, cfg.DiscoveryScheme)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, docker)
+	}
+	return sources, nil
+}
+
+// workerMetadata describes the agent's workers for registration
+func workerMetadata(cfg *config.Config) string {
+	var workers []string
+	for _, target := range cfg.Workers {
+		workers = append(workers, fmt.Sprintf("%s=%s", target.Name, target.URL))
+	}
+	return fmt.Sprintf("workers=%s", strings.Join(workers, ","))
 }
 
 func getHostname() string {
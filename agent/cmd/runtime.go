@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
+	"github.com/doniyusdinar/config-management/agent/internal/config"
+	"github.com/doniyusdinar/config-management/agent/internal/discovery"
+	"github.com/doniyusdinar/config-management/agent/internal/poller"
+	"github.com/doniyusdinar/config-management/agent/internal/reload"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/kafka"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/nats"
+	"github.com/doniyusdinar/config-management/pkg/redis"
+	"github.com/doniyusdinar/config-management/pkg/signing"
+)
+
+// agentRuntime owns the components that are rebuilt when the agent's settings
+// change. The worker manager and cache outlive rebuilds, so the workers keep
+// their configuration and the agent its version.
+type agentRuntime struct {
+	tlsConfig  *tls.Config
+	verifier   *signing.Verifier
+	workerMgr  *worker.Manager
+	discoverer *discovery.Discoverer // nil without worker discovery
+	store      *cache.Cache
+
+	reregistering atomic.Bool
+
+	mu              sync.RWMutex
+	cfg             *config.Config
+	registration    *models.RegisterResponse
+	distributionMgr *poller.DistributionManager
+}
+
+// newDistributionManager picks the best strategy the controller offers among
+// those the settings allow
+func (r *agentRuntime) newDistributionManager(cfg *config.Config, registration *models.RegisterResponse) (*poller.DistributionManager, error) {
+	var supported []poller.DistributionStrategy
+	for _, strategy := range cfg.SupportedStrategies {
+		supported = append(supported, poller.DistributionStrategy(strategy))
+	}
+
+	distributionMgr, err := poller.NewDistributionManager(
+		supported,
+		registration.Transports,
+		time.Duration(cfg.TransportRefresh)*time.Second,
+		cfg.ControllerURL,
+		cfg.ControllerUsername,
+		cfg.ControllerPassword,
+		r.workerMgr,
+		r.store,
+		newRedisConfig(cfg),
+		newNatsConfig(cfg),
+		newKafkaConfig(cfg),
+		r.tlsConfig,
+		r.verifier,
+		cfg.ControllerTransport == "NATS",
+		registration.AgentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	distributionMgr.OnUnknownAgent(r.unknownAgent)
+	return distributionMgr, nil
+}
+
+// start runs a distribution manager in the background
+func (r *agentRuntime) start(distributionMgr *poller.DistributionManager) {
+	r.mu.Lock()
+	r.distributionMgr = distributionMgr
+	r.mu.Unlock()
+
+	go func() {
+		if err := distributionMgr.Start(); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Log.Errorf("Distribution manager error: %v", err)
+		}
+	}()
+}
+
+func (r *agentRuntime) current() *poller.DistributionManager {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.distributionMgr
+}
+
+// Status reports on the running distribution manager
+func (r *agentRuntime) Status() poller.Status {
+	return r.current().Status()
+}
+
+// Resync forces a resync through the running distribution manager
+func (r *agentRuntime) Resync(ctx context.Context) (int64, error) {
+	return r.current().Resync(ctx)
+}
+
+// reregister registers with the controller again and continues under the new agent ID
+func (r *agentRuntime) reregister() (string, error) {
+	r.mu.RLock()
+	cfg := r.cfg
+	r.mu.RUnlock()
+
+	registration, err := register(context.Background(), cfg, newNatsConfig(cfg), r.tlsConfig)
+	if err != nil {
+		return "", err
+	}
+	logger.Log.Infof("Re-registered with controller - Agent ID: %s", registration.AgentID)
+
+	r.mu.Lock()
+	r.registration = registration
+	distributionMgr := r.distributionMgr
+	r.mu.Unlock()
+	return registration.AgentID, distributionMgr.SetAgentID(registration.AgentID)
+}
+
+// unknownAgent registers again after the controller reported that it does not
+// know the agent, e.g. because its database was reset. Reports that arrive
+// while a registration is under way are ignored.
+func (r *agentRuntime) unknownAgent() {
+	if !r.reregistering.CompareAndSwap(false, true) {
+		return
+	}
+	defer r.reregistering.Store(false)
+
+	logger.Log.Warn("Controller does not know this agent, registering again")
+	if _, err := r.reregister(); err != nil {
+		logger.Log.Errorf("Failed to register with controller: %v", err)
+	}
+}
+
+// apply rebuilds what a settings change requires. Registration and the new
+// distribution manager are set up first, so a failure leaves everything as it was.
+func (r *agentRuntime) apply(next *config.Config, plan reload.Plan) error {
+	r.mu.RLock()
+	registration, previous := r.registration, r.distributionMgr
+	r.mu.RUnlock()
+
+	if plan.Controller {
+		var err error
+		registration, err = register(context.Background(), next, newNatsConfig(next), r.tlsConfig)
+		if err != nil {
+			return fmt.Errorf("failed to register with controller: %w", err)
+		}
+		logger.Log.Infof("Registered with controller %s - Agent ID: %s", next.ControllerURL, registration.AgentID)
+	}
+
+	var distributionMgr *poller.DistributionManager
+	if plan.Distribution {
+		var err error
+		distributionMgr, err = r.newDistributionManager(next, registration)
+		if err != nil {
+			return fmt.Errorf("failed to create distribution manager: %w", err)
+		}
+		// Continue from the applied version instead of fetching it again
+		distributionMgr.SetVersion(previous.Status().LastVersion)
+	}
+
+	if plan.LogLevel {
+		logger.SetLevel(next.LogLevel)
+	}
+	if plan.Workers {
+		r.setWorkers(next.Workers)
+	}
+	if distributionMgr != nil {
+		if err := previous.Stop(); err != nil {
+			logger.Log.Warnf("Failed to stop distribution manager: %v", err)
+		}
+		r.start(distributionMgr)
+	}
+
+	r.mu.Lock()
+	r.cfg, r.registration = next, registration
+	r.mu.Unlock()
+	return nil
+}
+
+// setWorkers replaces the configured workers, keeping discovered ones
+func (r *agentRuntime) setWorkers(targets []worker.Target) {
+	if r.discoverer != nil {
+		r.discoverer.SetStatic(targets)
+		r.discoverer.Refresh(context.Background())
+		return
+	}
+
+	for _, result := range r.workerMgr.SetTargets(targets) {
+		if result.Success {
+			logger.Log.Infof("Pushed current config to new worker %s", result.Worker)
+		} else {
+			logger.Log.Errorf("Failed to push current config to new worker %s: %s", result.Worker, result.Error)
+		}
+	}
+}
+
+// stop stops the running distribution manager
+func (r *agentRuntime) stop() {
+	if err := r.current().Stop(); err != nil {
+		logger.Log.Warnf("Failed to stop distribution manager: %v", err)
+	}
+}
+
+func newNatsConfig(cfg *config.Config) nats.Config {
+	return nats.Config{
+		URLs:           strings.Split(cfg.NatsURL, ","),
+		Username:       cfg.NatsUsername,
+		Password:       cfg.NatsPassword,
+		Token:          cfg.NatsToken,
+		TLSEnabled:     cfg.NatsTLSEnabled,
+		MaxReconnect:   10,
+		ReconnectWait:  2 * time.Second,
+		ConnectionName: fmt.Sprintf("config-agent-%s", getHostname()),
+		Subject:        cfg.NatsSubject,
+		QueueGroup:     cfg.NatsQueueGroup,
+		Enabled:        true, // Always enabled for NATS strategy
+		Environment:    cfg.NatsEnvironment,
+		Groups:         cfg.NatsGroups,
+	}
+}
+
+func newRedisConfig(cfg *config.Config) redis.Config {
+	return redis.Config{
+		Address:  cfg.RedisAddress,
+		Password: cfg.RedisPassword,
+		DB:       cfg.RedisDB,
+		Enabled:  true, // Always enabled for Redis strategy
+	}
+}
+
+// newKafkaConfig shares its targets with NATS
+func newKafkaConfig(cfg *config.Config) kafka.Config {
+	return kafka.Config{
+		Brokers:     strings.Split(cfg.KafkaBrokers, ","),
+		Topic:       cfg.KafkaTopic,
+		ClientID:    fmt.Sprintf("config-agent-%s", getHostname()),
+		Enabled:     true,
+		Environment: cfg.NatsEnvironment,
+		Groups:      cfg.NatsGroups,
+	}
+}
@@ -0,0 +1,158 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/poller"
+	"github.com/doniyusdinar/config-management/agent/internal/supervisor"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+)
+
+const (
+	// resyncTimeout bounds a forced resync, including forwarding to the workers
+	resyncTimeout   = time.Minute
+	shutdownTimeout = 5 * time.Second
+)
+
+// Registrar registers the agent with the controller again and returns its new agent ID
+type Registrar func() (string, error)
+
+// Distribution is the part of the agent that fetches configurations. The
+// agent swaps its distribution manager when settings are reloaded.
+type Distribution interface {
+	Status() poller.Status
+	Resync(ctx context.Context) (int64, error)
+}
+
+// Status is everything the agent reports about itself
+type Status struct {
+	poller.Status
+	Workers    []models.WorkerStatus    `json:"workers"`
+	Pending    []models.PendingDelivery `json:"pending_deliveries"`
+	Supervisor *supervisor.Status       `json:"supervisor,omitempty"`
+}
+
+// Server is the agent's local HTTP API for inspecting and nudging it. It has
+// no authentication, so it should only listen on a loopback address.
+type Server struct {
+	addr            string
+	distributionMgr Distribution
+	workerMgr       *worker.Manager
+	supervisor      *supervisor.Supervisor // nil unless the agent runs the worker
+	register        Registrar
+}
+
+// NewServer creates the admin API on addr. sup may be nil.
+func NewServer(addr string, distributionMgr Distribution, workerMgr *worker.Manager, sup *supervisor.Supervisor, register Registrar) *Server {
+	return &Server{
+		addr:            addr,
+		distributionMgr: distributionMgr,
+		workerMgr:       workerMgr,
+		supervisor:      sup,
+		register:        register,
+	}
+}
+
+// Handler returns the API routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/resync", s.handleResync)
+	mux.HandleFunc("/register", s.handleRegister)
+	return mux
+}
+
+// Start serves the API until the context is cancelled
+func (s *Server) Start(ctx context.Context) error {
+	server := &http.Server{Addr: s.addr, Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Log.Infof("Admin API listening on %s", s.addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// handleStatus reports the agent ID, transport, workers, cache and backoff state
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	status := Status{
+		Status:  s.distributionMgr.Status(),
+		Workers: s.workerMgr.Status(),
+		Pending: s.workerMgr.Pending(),
+	}
+	if s.supervisor != nil {
+		supervised := s.supervisor.Status()
+		status.Supervisor = &supervised
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleResync fetches the active configuration and forwards it to every worker
+func (s *Server) handleResync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), resyncTimeout)
+	defer cancel()
+
+	version, err := s.distributionMgr.Resync(ctx)
+	if err != nil {
+		logger.Log.Errorf("Forced resync failed: %v", err)
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"version": version,
+		"workers": s.workerMgr.Status(),
+	})
+}
+
+// handleRegister registers the agent with the controller again
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if s.register == nil {
+		writeError(w, http.StatusNotImplemented, "re-registration is not available")
+		return
+	}
+
+	agentID, err := s.register()
+	if err != nil {
+		logger.Log.Errorf("Forced re-registration failed: %v", err)
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"agent_id": agentID})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Log.Warnf("Failed to write admin response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
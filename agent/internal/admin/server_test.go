@@ -0,0 +1,103 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
+	"github.com/doniyusdinar/config-management/agent/internal/poller"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/kafka"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/nats"
+	"github.com/doniyusdinar/config-management/pkg/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAgent wires a polling agent to a fake controller serving version 3 and a fake worker
+func newAgent(t *testing.T, register Registrar) (*Server, *int32) {
+	var forwards int32
+	workerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&forwards, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(workerServer.Close)
+
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.ConfigResponse{Version: 3, Data: models.WorkerConfig{URL: "https://ip.me"}})
+	}))
+	t.Cleanup(controller.Close)
+
+	workerMgr := worker.NewManager(workerServer.URL, nil)
+	distributionMgr, err := poller.NewDistributionManager(
+		[]poller.DistributionStrategy{poller.StrategyPoller}, nil, 0,
+		controller.URL, "agent", "secret", workerMgr, cache.New(filepath.Join(t.TempDir(), "cache"), 3, nil),
+		redis.Config{}, nats.Config{}, kafka.Config{}, nil, nil, false, "agent-1",
+	)
+	require.NoError(t, err)
+
+	return NewServer("127.0.0.1:0", distributionMgr, workerMgr, nil, register), &forwards
+}
+
+func do(t *testing.T, server *Server, method, path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return rec, body
+}
+
+func TestStatus(t *testing.T) {
+	server, _ := newAgent(t, nil)
+
+	rec, body := do(t, server, "GET", "/status")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "agent-1", body["agent_id"])
+	assert.Equal(t, "POLLER", body["strategy"])
+	assert.Equal(t, float64(0), body["last_version"])
+	assert.Contains(t, body, "poll")
+	assert.Empty(t, body["cache"].(map[string]interface{})["entries"])
+	assert.Len(t, body["workers"], 1)
+	assert.Empty(t, body["pending_deliveries"])
+	assert.NotContains(t, body, "supervisor")
+
+	rec, _ = do(t, server, "POST", "/status")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestResyncForwardsToWorkersAgain(t *testing.T) {
+	server, forwards := newAgent(t, nil)
+
+	rec, body := do(t, server, "POST", "/resync")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, float64(3), body["version"])
+
+	// The worker already accepted version 3, a resync still sends it
+	rec, _ = do(t, server, "POST", "/resync")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int32(2), atomic.LoadInt32(forwards))
+
+	_, body = do(t, server, "GET", "/status")
+	assert.Equal(t, float64(3), body["last_version"])
+	entries := body["cache"].(map[string]interface{})["entries"].([]interface{})
+	require.Len(t, entries, 1)
+	assert.Equal(t, float64(3), entries[0].(map[string]interface{})["version"])
+}
+
+func TestRegister(t *testing.T) {
+	server, _ := newAgent(t, func() (string, error) { return "agent-2", nil })
+	rec, body := do(t, server, "POST", "/register")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "agent-2", body["agent_id"])
+
+	server, _ = newAgent(t, func() (string, error) { return "", errors.New("controller unreachable") })
+	rec, body = do(t, server, "POST", "/register")
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Equal(t, "controller unreachable", body["error"])
+}
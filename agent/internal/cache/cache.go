@@ -0,0 +1,309 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/signing"
+)
+
+// SchemaVersion is the version of the cache entry format written by this agent
+const SchemaVersion = 1
+
+// DefaultRetain is how many versions are kept when no retention is configured
+const DefaultRetain = 3
+
+// ErrEmpty is returned when the cache holds no usable configuration
+var ErrEmpty = errors.New("no usable cached config")
+
+// entry is the on-disk form of one cached version. The checksum covers the
+// exact config bytes, so a truncated or edited file is detected before the
+// signature is checked.
+type entry struct {
+	SchemaVersion int             `json:"schema_version"`
+	Checksum      string          `json:"checksum"` // hex sha256 of Config
+	SavedAt       time.Time       `json:"saved_at"`
+	Config        json.RawMessage `json:"config"`
+}
+
+// EntryStatus describes one cached version
+type EntryStatus struct {
+	Version int64      `json:"version"`
+	SavedAt *time.Time `json:"saved_at,omitempty"`
+	Error   string     `json:"error,omitempty"` // why the entry cannot be used
+}
+
+// Status describes the cached versions, newest first
+type Status struct {
+	Path    string        `json:"path"`
+	Retain  int           `json:"retain"`
+	Entries []EntryStatus `json:"entries"`
+}
+
+// Cache keeps the last few configurations the agent applied, one file per
+// version next to path (path.<version>), so the agent can start without the
+// controller. It is shared by every distribution strategy.
+type Cache struct {
+	path     string
+	retain   int
+	verifier *signing.Verifier
+
+	mu sync.Mutex
+}
+
+// New creates a cache storing entries next to path and keeping the newest
+// retain versions. Entries are verified against verifier when loaded.
+func New(path string, retain int, verifier *signing.Verifier) *Cache {
+	if retain < 1 {
+		retain = DefaultRetain
+	}
+	return &Cache{path: path, retain: retain, verifier: verifier}
+}
+
+// Save stores a configuration atomically and drops versions beyond the retention
+func (c *Cache) Save(config models.ConfigResponse) error {
+	if c == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	encoded, err := json.Marshal(entry{
+		SchemaVersion: SchemaVersion,
+		Checksum:      hex.EncodeToString(sum[:]),
+		SavedAt:       time.Now().UTC(),
+		Config:        data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := writeAtomic(c.entryPath(config.Version), tempPrefix(c.path)+"*", encoded); err != nil {
+		return err
+	}
+	// Entries replace the single cache file of older agents
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		logger.Log.Warnf("Failed to remove legacy cache file: %v", err)
+	}
+	c.prune()
+	return nil
+}
+
+// Load returns the newest cached configuration that is intact and verifies,
+// falling back to older versions when a newer entry is corrupt or rejected
+func (c *Cache) Load() (*models.ConfigResponse, error) {
+	if c == nil {
+		return nil, ErrEmpty
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, version := range c.versions() {
+		config, _, err := c.read(version)
+		if err != nil {
+			logger.Log.Warnf("Skipping cached config version %d: %v", version, err)
+			continue
+		}
+		return config, nil
+	}
+
+	// Older agents kept a single unversioned file
+	if config, err := c.readLegacy(); err == nil {
+		return config, nil
+	} else if !os.IsNotExist(err) {
+		logger.Log.Warnf("Skipping legacy cache file: %v", err)
+	}
+	return nil, ErrEmpty
+}
+
+// Clear removes every cached version, e.g. when the current configuration
+// holds secrets that must not be persisted and an older one must not be replayed
+func (c *Cache) Clear() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var errs []error
+	for _, version := range c.versions() {
+		if err := os.Remove(c.entryPath(version)); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Status reports every cached version and whether it can be used
+func (c *Cache) Status() Status {
+	if c == nil {
+		return Status{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := Status{Path: c.path, Retain: c.retain, Entries: []EntryStatus{}}
+	for _, version := range c.versions() {
+		entryStatus := EntryStatus{Version: version}
+		_, savedAt, err := c.read(version)
+		if !savedAt.IsZero() {
+			entryStatus.SavedAt = &savedAt
+		}
+		if err != nil {
+			entryStatus.Error = err.Error()
+		}
+		status.Entries = append(status.Entries, entryStatus)
+	}
+	return status
+}
+
+// read loads and checks one cached version
+func (c *Cache) read(version int64) (*models.ConfigResponse, time.Time, error) {
+	data, err := os.ReadFile(c.entryPath(version))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, time.Time{}, fmt.Errorf("corrupt entry: %w", err)
+	}
+	if e.SchemaVersion != SchemaVersion {
+		return nil, e.SavedAt, fmt.Errorf("unsupported schema version %d", e.SchemaVersion)
+	}
+	sum := sha256.Sum256(e.Config)
+	if hex.EncodeToString(sum[:]) != e.Checksum {
+		return nil, e.SavedAt, fmt.Errorf("checksum mismatch")
+	}
+
+	var config models.ConfigResponse
+	if err := json.Unmarshal(e.Config, &config); err != nil {
+		return nil, e.SavedAt, fmt.Errorf("corrupt config: %w", err)
+	}
+	if config.Version != version {
+		return nil, e.SavedAt, fmt.Errorf("entry holds version %d", config.Version)
+	}
+	if err := c.verifier.Verify(signing.ResponsePayload(&config), config.Signature); err != nil {
+		return nil, e.SavedAt, fmt.Errorf("rejected cached config version %d: %w", config.Version, err)
+	}
+	return &config, e.SavedAt, nil
+}
+
+// readLegacy loads the single cache file written by older agents
+func (c *Cache) readLegacy() (*models.ConfigResponse, error) {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config models.ConfigResponse
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if err := c.verifier.Verify(signing.ResponsePayload(&config), config.Signature); err != nil {
+		return nil, fmt.Errorf("rejected cached config version %d: %w", config.Version, err)
+	}
+	return &config, nil
+}
+
+// versions lists the cached versions, newest first
+func (c *Cache) versions() []int64 {
+	matches, err := filepath.Glob(c.path + ".*")
+	if err != nil {
+		return nil
+	}
+
+	var versions []int64
+	prefix := c.path + "."
+	for _, match := range matches {
+		version, err := strconv.ParseInt(strings.TrimPrefix(match, prefix), 10, 64)
+		if err == nil {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	return versions
+}
+
+// prune drops versions beyond the retention and temporary files left by a crash
+func (c *Cache) prune() {
+	versions := c.versions()
+	for i := c.retain; i < len(versions); i++ {
+		if err := os.Remove(c.entryPath(versions[i])); err != nil && !os.IsNotExist(err) {
+			logger.Log.Warnf("Failed to remove cached config version %d: %v", versions[i], err)
+		}
+	}
+
+	temps, _ := filepath.Glob(filepath.Join(filepath.Dir(c.path), tempPrefix(c.path)+"*"))
+	for _, temp := range temps {
+		os.Remove(temp)
+	}
+}
+
+func (c *Cache) entryPath(version int64) string {
+	return fmt.Sprintf("%s.%d", c.path, version)
+}
+
+func tempPrefix(path string) string {
+	return "." + filepath.Base(path) + ".tmp-"
+}
+
+// writeAtomic writes data to a temporary file named after pattern in the target
+// directory and renames it into place, so readers see either the old or the new file
+func writeAtomic(path, pattern string, data []byte) error {
+	dir := filepath.Dir(path)
+	temp, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary cache file: %w", err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return fmt.Errorf("failed to sync cache: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	if err := os.Rename(temp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace cache: %w", err)
+	}
+
+	// Persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
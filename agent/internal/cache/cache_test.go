@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSigner(t *testing.T) (*signing.Signer, *signing.Verifier) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return signing.NewSigner(private), signing.NewVerifier(public)
+}
+
+func signed(t *testing.T, signer *signing.Signer, version int64, url string) models.ConfigResponse {
+	resp := models.ConfigResponse{Version: version, Data: models.WorkerConfig{URL: url}}
+	sig, err := signer.Sign(signing.ResponsePayload(&resp))
+	require.NoError(t, err)
+	resp.Signature = sig
+	return resp
+}
+
+func TestSaveKeepsNewestVersions(t *testing.T) {
+	signer, verifier := newSigner(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent_config.cache")
+	c := New(path, 2, verifier)
+
+	_, err := c.Load()
+	assert.ErrorIs(t, err, ErrEmpty)
+
+	for version := int64(1); version <= 3; version++ {
+		require.NoError(t, c.Save(signed(t, signer, version, "https://ip.me")))
+	}
+
+	loaded, err := c.Load()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), loaded.Version)
+
+	status := c.Status()
+	require.Len(t, status.Entries, 2)
+	assert.Equal(t, int64(3), status.Entries[0].Version)
+	assert.Equal(t, int64(2), status.Entries[1].Version)
+
+	// Only entries remain, no temporary files
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	assert.ElementsMatch(t, []string{"agent_config.cache.2", "agent_config.cache.3"}, names)
+}
+
+func TestLoadFallsBackToPreviousEntry(t *testing.T) {
+	signer, verifier := newSigner(t)
+	path := filepath.Join(t.TempDir(), "agent_config.cache")
+	c := New(path, 3, verifier)
+
+	require.NoError(t, c.Save(signed(t, signer, 1, "https://ip.me")))
+	require.NoError(t, c.Save(signed(t, signer, 2, "https://api.example.com")))
+	require.NoError(t, c.Save(signed(t, signer, 3, "https://api.example.com")))
+
+	// A write cut short by a crash
+	data, err := os.ReadFile(path + ".3")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path+".3", data[:len(data)/2], 0644))
+
+	// An edited config keeps valid JSON but fails the checksum
+	var e entry
+	data, err = os.ReadFile(path + ".2")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &e))
+	e.Config = json.RawMessage(`{"version":2,"data":{"url":"https://evil.example.com"}}`)
+	data, err = json.Marshal(e)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path+".2", data, 0644))
+
+	loaded, err := c.Load()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), loaded.Version)
+	assert.Equal(t, "https://ip.me", loaded.Data.URL)
+
+	status := c.Status()
+	require.Len(t, status.Entries, 3)
+	assert.Contains(t, status.Entries[0].Error, "corrupt entry")
+	assert.Equal(t, "checksum mismatch", status.Entries[1].Error)
+	assert.Empty(t, status.Entries[2].Error)
+}
+
+func TestLoadRejectsUnverifiedEntries(t *testing.T) {
+	signer, verifier := newSigner(t)
+	path := filepath.Join(t.TempDir(), "agent_config.cache")
+	c := New(path, 3, verifier)
+
+	// Checksums are recomputed on save, so only the signature catches this
+	forged := signed(t, signer, 2, "https://ip.me")
+	forged.Data.URL = "https://evil.example.com"
+	require.NoError(t, c.Save(forged))
+
+	_, err := c.Load()
+	assert.ErrorIs(t, err, ErrEmpty)
+	assert.Contains(t, c.Status().Entries[0].Error, "rejected cached config version 2")
+}
+
+func TestLoadReadsLegacyFile(t *testing.T) {
+	signer, verifier := newSigner(t)
+	path := filepath.Join(t.TempDir(), "agent_config.cache")
+	c := New(path, 3, verifier)
+
+	data, err := json.Marshal(signed(t, signer, 4, "https://ip.me"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	loaded, err := c.Load()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), loaded.Version)
+
+	// The first versioned entry replaces it
+	require.NoError(t, c.Save(signed(t, signer, 5, "https://ip.me")))
+	assert.NoFileExists(t, path)
+
+	require.NoError(t, c.Clear())
+	_, err = c.Load()
+	assert.ErrorIs(t, err, ErrEmpty)
+}
+
+func TestUnsupportedSchemaVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent_config.cache")
+	c := New(path, 3, nil)
+	require.NoError(t, c.Save(models.ConfigResponse{Version: 1, Data: models.WorkerConfig{URL: "https://ip.me"}}))
+
+	var e entry
+	data, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &e))
+	e.SchemaVersion = SchemaVersion + 1
+	data, err = json.Marshal(e)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path+".1", data, 0644))
+
+	_, err = c.Load()
+	assert.ErrorIs(t, err, ErrEmpty)
+	assert.Equal(t, "unsupported schema version 2", c.Status().Entries[0].Error)
+}
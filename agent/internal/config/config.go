@@ -4,7 +4,10 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/nats"
 	"github.com/spf13/viper"
 )
 
@@ -12,11 +15,32 @@ type Config struct {
 	ControllerURL         string
 	ControllerUsername    string
 	ControllerPassword    string
+	ControllerTransport   string // HTTP, or NATS for agents without an HTTP path to the controller
+	RegisterTimeout       int    // seconds to retry registration at startup, 0 retries forever
 	WorkerURL             string
+	Workers               []worker.Target // parsed WORKERS, or WorkerURL alone without discovery
+	// Worker discovery; discovered workers are added to Workers
+	DiscoveryFile         string
+	DiscoverySRV          string
+	DiscoveryDNS          string // DNS server for SRV lookups, host:port
+	DiscoveryDocker       bool
+	DockerHost            string
+	DiscoveryScheme       string // scheme of discovered worker URLs
+	DiscoveryInterval     int    // seconds
+	ReconcileInterval     int    // seconds between worker drift checks, 0 disables them
+	// Supervision of a local worker process, reached at WorkerURL
+	WorkerCommand         string
+	WorkerArgs            []string
+	WorkerEnv             []string // KEY=VALUE added to the agent's environment
+	WorkerStartTimeout    int      // seconds for the worker to become healthy
 	LogLevel              string
 	CacheFile             string
+	CacheRetain           int    // cached config versions kept
+	AdminAddr             string // local status API listen address, empty when disabled
 	// Distribution strategy configuration
-	DistributionStrategy  string // POLLER, REDIS, NATS, KAFKA (future)
+	DistributionStrategy  string   // comma-separated POLLER, REDIS, NATS, KAFKA or AUTO
+	SupportedStrategies   []string // parsed DistributionStrategy, the first is the fallback
+	TransportRefresh      int      // seconds between checks of the controller's advertised transports
 	RedisAddress          string
 	RedisPassword         string
 	RedisDB               int
@@ -28,6 +52,21 @@ type Config struct {
 	NatsTLSEnabled        bool
 	NatsSubject           string
 	NatsQueueGroup        string
+	NatsEnvironment       string   // targeted subjects config.<environment>.<group>.<agentID>
+	NatsGroups            []string // includes the groups of every worker
+	// Kafka configuration
+	KafkaBrokers          string
+	KafkaTopic            string
+	// mTLS configuration for controller and worker connections
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSCAFile             string
+	TLSServerName         string
+	TLSReloadInterval     int // seconds
+	// Bootstrap token for obtaining a client certificate from the controller CA
+	EnrollmentToken       string
+	// Pinned controller public key used to verify configuration signatures
+	SigningPublicKeyFile  string
 }
 
 func LoadConfig() (*Config, error) {
@@ -40,10 +79,28 @@ func LoadConfig() (*Config, error) {
 	viper.SetDefault("CONTROLLER_URL", "http://localhost:8080")
 	viper.SetDefault("CONTROLLER_USERNAME", "agent")
 	viper.SetDefault("CONTROLLER_PASSWORD", "secret123")
+	viper.SetDefault("CONTROLLER_TRANSPORT", "HTTP")
+	viper.SetDefault("REGISTER_TIMEOUT", "300")
 	viper.SetDefault("WORKER_URL", "http://localhost:8082")
+	viper.SetDefault("WORKERS", "")
+	viper.SetDefault("WORKER_DISCOVERY_FILE", "")
+	viper.SetDefault("WORKER_DISCOVERY_SRV", "")
+	viper.SetDefault("WORKER_DISCOVERY_DNS_SERVER", "")
+	viper.SetDefault("WORKER_DISCOVERY_DOCKER", "false")
+	viper.SetDefault("DOCKER_HOST", "unix:///var/run/docker.sock")
+	viper.SetDefault("WORKER_DISCOVERY_SCHEME", "http")
+	viper.SetDefault("WORKER_DISCOVERY_INTERVAL", "30")
+	viper.SetDefault("RECONCILE_INTERVAL", "30")
+	viper.SetDefault("WORKER_COMMAND", "")
+	viper.SetDefault("WORKER_ARGS", "")
+	viper.SetDefault("WORKER_ENV", "")
+	viper.SetDefault("WORKER_START_TIMEOUT", "30")
 	viper.SetDefault("LOG_LEVEL", "info")
 	viper.SetDefault("CACHE_FILE", "./agent_config.cache")
-	viper.SetDefault("DISTRIBUTION_STRATEGY", "POLLER")
+	viper.SetDefault("CACHE_RETAIN", "3")
+	viper.SetDefault("ADMIN_ADDR", "127.0.0.1:8084")
+	viper.SetDefault("DISTRIBUTION_STRATEGY", "AUTO")
+	viper.SetDefault("TRANSPORT_REFRESH_INTERVAL", "60")
 	viper.SetDefault("REDIS_ADDRESS", "localhost:6379")
 	viper.SetDefault("REDIS_PASSWORD", "")
 	viper.SetDefault("REDIS_DB", "0")
@@ -54,6 +111,17 @@ func LoadConfig() (*Config, error) {
 	viper.SetDefault("NATS_TLS_ENABLED", "false")
 	viper.SetDefault("NATS_SUBJECT", "config.worker.update")
 	viper.SetDefault("NATS_QUEUE_GROUP", "config-workers")
+	viper.SetDefault("NATS_ENVIRONMENT", "")
+	viper.SetDefault("NATS_GROUPS", "")
+	viper.SetDefault("KAFKA_BROKERS", "localhost:9092")
+	viper.SetDefault("KAFKA_TOPIC", "config-updates")
+	viper.SetDefault("TLS_CERT_FILE", "")
+	viper.SetDefault("TLS_KEY_FILE", "")
+	viper.SetDefault("TLS_CA_FILE", "")
+	viper.SetDefault("TLS_SERVER_NAME", "")
+	viper.SetDefault("TLS_RELOAD_INTERVAL", "30")
+	viper.SetDefault("ENROLLMENT_TOKEN", "")
+	viper.SetDefault("SIGNING_PUBLIC_KEY_FILE", "")
 
 	// Try to read config file (optional)
 	if err := viper.ReadInConfig(); err != nil {
@@ -67,10 +135,27 @@ func LoadConfig() (*Config, error) {
 		ControllerURL:         getEnv("CONTROLLER_URL", viper.GetString("CONTROLLER_URL")),
 		ControllerUsername:    getEnv("CONTROLLER_USERNAME", viper.GetString("CONTROLLER_USERNAME")),
 		ControllerPassword:    getEnv("CONTROLLER_PASSWORD", viper.GetString("CONTROLLER_PASSWORD")),
+		ControllerTransport:   getEnv("CONTROLLER_TRANSPORT", viper.GetString("CONTROLLER_TRANSPORT")),
+		RegisterTimeout:       getEnvInt("REGISTER_TIMEOUT", viper.GetInt("REGISTER_TIMEOUT")),
 		WorkerURL:             getEnv("WORKER_URL", viper.GetString("WORKER_URL")),
+		DiscoveryFile:         getEnv("WORKER_DISCOVERY_FILE", viper.GetString("WORKER_DISCOVERY_FILE")),
+		DiscoverySRV:          getEnv("WORKER_DISCOVERY_SRV", viper.GetString("WORKER_DISCOVERY_SRV")),
+		DiscoveryDNS:          getEnv("WORKER_DISCOVERY_DNS_SERVER", viper.GetString("WORKER_DISCOVERY_DNS_SERVER")),
+		DiscoveryDocker:       getEnvBool("WORKER_DISCOVERY_DOCKER", viper.GetBool("WORKER_DISCOVERY_DOCKER")),
+		DockerHost:            getEnv("DOCKER_HOST", viper.GetString("DOCKER_HOST")),
+		DiscoveryScheme:       getEnv("WORKER_DISCOVERY_SCHEME", viper.GetString("WORKER_DISCOVERY_SCHEME")),
+		DiscoveryInterval:     getEnvInt("WORKER_DISCOVERY_INTERVAL", viper.GetInt("WORKER_DISCOVERY_INTERVAL")),
+		ReconcileInterval:     getEnvInt("RECONCILE_INTERVAL", viper.GetInt("RECONCILE_INTERVAL")),
+		WorkerCommand:         getEnv("WORKER_COMMAND", viper.GetString("WORKER_COMMAND")),
+		WorkerArgs:            strings.Fields(getEnv("WORKER_ARGS", viper.GetString("WORKER_ARGS"))),
+		WorkerEnv:             splitList(getEnv("WORKER_ENV", viper.GetString("WORKER_ENV"))),
+		WorkerStartTimeout:    getEnvInt("WORKER_START_TIMEOUT", viper.GetInt("WORKER_START_TIMEOUT")),
 		LogLevel:              getEnv("LOG_LEVEL", viper.GetString("LOG_LEVEL")),
 		CacheFile:             getEnv("CACHE_FILE", viper.GetString("CACHE_FILE")),
+		CacheRetain:           getEnvInt("CACHE_RETAIN", viper.GetInt("CACHE_RETAIN")),
+		AdminAddr:             getEnv("ADMIN_ADDR", viper.GetString("ADMIN_ADDR")),
 		DistributionStrategy:  getEnv("DISTRIBUTION_STRATEGY", viper.GetString("DISTRIBUTION_STRATEGY")),
+		TransportRefresh:      getEnvInt("TRANSPORT_REFRESH_INTERVAL", viper.GetInt("TRANSPORT_REFRESH_INTERVAL")),
 		RedisAddress:          getEnv("REDIS_ADDRESS", viper.GetString("REDIS_ADDRESS")),
 		RedisPassword:         getEnv("REDIS_PASSWORD", viper.GetString("REDIS_PASSWORD")),
 		RedisDB:               getEnvInt("REDIS_DB", viper.GetInt("REDIS_DB")),
@@ -81,11 +166,164 @@ func LoadConfig() (*Config, error) {
 		NatsTLSEnabled:        getEnvBool("NATS_TLS_ENABLED", viper.GetBool("NATS_TLS_ENABLED")),
 		NatsSubject:           getEnv("NATS_SUBJECT", viper.GetString("NATS_SUBJECT")),
 		NatsQueueGroup:        getEnv("NATS_QUEUE_GROUP", viper.GetString("NATS_QUEUE_GROUP")),
+		NatsEnvironment:       getEnv("NATS_ENVIRONMENT", viper.GetString("NATS_ENVIRONMENT")),
+		NatsGroups:            splitList(getEnv("NATS_GROUPS", viper.GetString("NATS_GROUPS"))),
+		KafkaBrokers:          getEnv("KAFKA_BROKERS", viper.GetString("KAFKA_BROKERS")),
+		KafkaTopic:            getEnv("KAFKA_TOPIC", viper.GetString("KAFKA_TOPIC")),
+		TLSCertFile:           getEnv("TLS_CERT_FILE", viper.GetString("TLS_CERT_FILE")),
+		TLSKeyFile:            getEnv("TLS_KEY_FILE", viper.GetString("TLS_KEY_FILE")),
+		TLSCAFile:             getEnv("TLS_CA_FILE", viper.GetString("TLS_CA_FILE")),
+		TLSServerName:         getEnv("TLS_SERVER_NAME", viper.GetString("TLS_SERVER_NAME")),
+		TLSReloadInterval:     getEnvInt("TLS_RELOAD_INTERVAL", viper.GetInt("TLS_RELOAD_INTERVAL")),
+		EnrollmentToken:       getEnv("ENROLLMENT_TOKEN", viper.GetString("ENROLLMENT_TOKEN")),
+		SigningPublicKeyFile:  getEnv("SIGNING_PUBLIC_KEY_FILE", viper.GetString("SIGNING_PUBLIC_KEY_FILE")),
+	}
+
+	if config.EnrollmentToken != "" && (config.TLSCertFile == "" || config.TLSKeyFile == "") {
+		return nil, fmt.Errorf("ENROLLMENT_TOKEN requires TLS_CERT_FILE and TLS_KEY_FILE to store the issued certificate")
+	}
+
+	workers, err := worker.ParseTargets(getEnv("WORKERS", viper.GetString("WORKERS")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WORKERS: %w", err)
+	}
+	if config.WorkerCommand != "" {
+		for _, target := range workers {
+			if target.Name == SupervisedWorker {
+				return nil, fmt.Errorf("worker name %q is reserved for the supervised worker", SupervisedWorker)
+			}
+		}
+		workers = append(workers, worker.Target{Name: SupervisedWorker, URL: config.WorkerURL})
+	} else if len(workers) == 0 && !config.DiscoveryEnabled() {
+		workers = []worker.Target{{Name: "default", URL: config.WorkerURL}}
+	}
+	for _, env := range config.WorkerEnv {
+		if !strings.Contains(env, "=") {
+			return nil, fmt.Errorf("invalid WORKER_ENV entry %q, expected KEY=VALUE", env)
+		}
+	}
+	if strings.EqualFold(config.AdminAddr, "off") {
+		config.AdminAddr = ""
+	}
+	if config.RegisterTimeout < 0 {
+		return nil, fmt.Errorf("REGISTER_TIMEOUT must not be negative")
+	}
+	if config.CacheRetain < 1 {
+		return nil, fmt.Errorf("CACHE_RETAIN must be at least 1")
+	}
+	if config.DiscoveryInterval < 1 {
+		return nil, fmt.Errorf("WORKER_DISCOVERY_INTERVAL must be at least 1 second")
+	}
+	config.Workers = workers
+
+	// The agent subscribes to every group one of its workers selects
+	for _, target := range workers {
+		for _, group := range target.Groups {
+			if !contains(config.NatsGroups, group) {
+				config.NatsGroups = append(config.NatsGroups, group)
+			}
+		}
+	}
+
+	for _, token := range append([]string{config.NatsEnvironment}, config.NatsGroups...) {
+		if token == "" {
+			continue
+		}
+		if err := nats.ValidateToken(token); err != nil {
+			return nil, fmt.Errorf("invalid NATS_ENVIRONMENT, NATS_GROUPS or WORKERS group: %w", err)
+		}
+	}
+
+	strategies, err := parseStrategies(config.DistributionStrategy)
+	if err != nil {
+		return nil, err
+	}
+	config.SupportedStrategies = strategies
+
+	switch config.ControllerTransport {
+	case "HTTP":
+	case "NATS":
+		if len(strategies) != 1 || strategies[0] != "NATS" {
+			return nil, fmt.Errorf("CONTROLLER_TRANSPORT=NATS requires DISTRIBUTION_STRATEGY=NATS")
+		}
+		if config.EnrollmentToken != "" {
+			return nil, fmt.Errorf("ENROLLMENT_TOKEN requires CONTROLLER_TRANSPORT=HTTP")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported CONTROLLER_TRANSPORT: %s", config.ControllerTransport)
 	}
 
 	return config, nil
 }
 
+// File returns the config file settings are read from, or where it is looked
+// for when it does not exist yet
+func File() string {
+	if file := viper.ConfigFileUsed(); file != "" {
+		return file
+	}
+	return "config.yaml"
+}
+
+// SupervisedWorker names the worker process launched by the agent
+const SupervisedWorker = "local"
+
+// DiscoveryEnabled reports whether any worker discovery source is configured.
+// WORKER_URL is then not used; static workers are listed in WORKERS.
+func (c *Config) DiscoveryEnabled() bool {
+	return c.DiscoveryFile != "" || c.DiscoverySRV != "" || c.DiscoveryDocker
+}
+
+// allStrategies is what AUTO expands to; polling is the fallback
+var allStrategies = []string{"POLLER", "REDIS", "NATS", "KAFKA"}
+
+// parseStrategies expands DISTRIBUTION_STRATEGY into the strategies the agent
+// supports. The controller's advertisement picks among them.
+func parseStrategies(value string) ([]string, error) {
+	var strategies []string
+	seen := make(map[string]bool)
+	for _, strategy := range splitList(strings.ToUpper(value)) {
+		expanded := []string{strategy}
+		switch strategy {
+		case "AUTO":
+			expanded = allStrategies
+		case "POLLER", "REDIS", "NATS", "KAFKA":
+		default:
+			return nil, fmt.Errorf("unsupported DISTRIBUTION_STRATEGY: %s", strategy)
+		}
+		for _, s := range expanded {
+			if !seen[s] {
+				seen[s] = true
+				strategies = append(strategies, s)
+			}
+		}
+	}
+	if len(strategies) == 0 {
+		return allStrategies, nil
+	}
+	return strategies, nil
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
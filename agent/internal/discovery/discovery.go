@@ -0,0 +1,132 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+)
+
+// lookupTimeout bounds a single discovery lookup
+const lookupTimeout = 10 * time.Second
+
+// Source discovers workers from one place, such as a file, DNS or Docker
+type Source interface {
+	Name() string
+	Discover(ctx context.Context) ([]worker.Target, error)
+}
+
+// Watcher is a source that can signal changes between periodic lookups
+type Watcher interface {
+	Watch(ctx context.Context, changed chan<- struct{}) error
+}
+
+// Discoverer keeps the worker manager's targets in line with the static workers
+// and everything its sources report
+type Discoverer struct {
+	workerMgr *worker.Manager
+	static    []worker.Target
+	sources   []Source
+	interval  time.Duration
+
+	mu    sync.Mutex
+	found map[string][]worker.Target // last successful lookup per source
+}
+
+// NewDiscoverer creates a discoverer that looks up sources every interval
+func NewDiscoverer(workerMgr *worker.Manager, static []worker.Target, sources []Source, interval time.Duration) *Discoverer {
+	return &Discoverer{
+		workerMgr: workerMgr,
+		static:    static,
+		sources:   sources,
+		interval:  interval,
+		found:     make(map[string][]worker.Target),
+	}
+}
+
+// Start refreshes the workers periodically and whenever a watched source
+// changes, until the context is cancelled
+func (d *Discoverer) Start(ctx context.Context) {
+	changed := make(chan struct{}, 1)
+	for _, source := range d.sources {
+		if watcher, ok := source.(Watcher); ok {
+			go func(source Source, watcher Watcher) {
+				if err := watcher.Watch(ctx, changed); err != nil {
+					logger.Log.Warnf("Failed to watch %s for workers, relying on periodic lookups: %v", source.Name(), err)
+				}
+			}(source, watcher)
+		}
+	}
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changed:
+		}
+		d.Refresh(ctx)
+	}
+}
+
+// Refresh looks up every source and updates the worker manager. A source that
+// fails keeps its previous workers, so a lookup error does not drop them.
+func (d *Discoverer) Refresh(ctx context.Context) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, source := range d.sources {
+		lookupCtx, cancel := context.WithTimeout(ctx, lookupTimeout)
+		targets, err := source.Discover(lookupCtx)
+		cancel()
+		if err != nil {
+			logger.Log.Warnf("Worker discovery from %s failed: %v", source.Name(), err)
+			continue
+		}
+		d.found[source.Name()] = targets
+	}
+
+	results := d.workerMgr.SetTargets(d.merge())
+	for _, result := range results {
+		if result.Success {
+			logger.Log.Infof("Pushed current config to new worker %s", result.Worker)
+		} else {
+			logger.Log.Errorf("Failed to push current config to new worker %s: %s", result.Worker, result.Error)
+		}
+	}
+}
+
+// SetStatic replaces the configured workers; Refresh applies them
+func (d *Discoverer) SetStatic(static []worker.Target) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.static = static
+}
+
+// merge combines the static and discovered workers; the first worker with a
+// name wins
+func (d *Discoverer) merge() []worker.Target {
+	var targets []worker.Target
+	seen := make(map[string]bool)
+	add := func(source string, found []worker.Target) {
+		for _, target := range found {
+			if seen[target.Name] {
+				logger.Log.Debugf("Ignoring duplicate worker %s from %s", target.Name, source)
+				continue
+			}
+			seen[target.Name] = true
+			targets = append(targets, target)
+		}
+	}
+
+	add("static configuration", d.static)
+	for _, source := range d.sources {
+		add(source.Name(), d.found[source.Name()])
+	}
+	return targets
+}
@@ -0,0 +1,189 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSource(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlFile := filepath.Join(dir, "workers.yaml")
+	require.NoError(t, os.WriteFile(yamlFile, []byte(`workers:
+  - name: api
+    url: http://worker-1:8082
+    groups: [eu, canary]
+  - name: batch
+    url: http://worker-2:8082
+`), 0644))
+	targets, err := NewFileSource(yamlFile).Discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []worker.Target{
+		{Name: "api", URL: "http://worker-1:8082", Groups: []string{"eu", "canary"}},
+		{Name: "batch", URL: "http://worker-2:8082"},
+	}, targets)
+
+	jsonFile := filepath.Join(dir, "workers.json")
+	require.NoError(t, os.WriteFile(jsonFile, []byte(`{"workers": [{"name": "api", "url": "http://worker-1:8082"}]}`), 0644))
+	targets, err = NewFileSource(jsonFile).Discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []worker.Target{{Name: "api", URL: "http://worker-1:8082"}}, targets)
+
+	require.NoError(t, os.WriteFile(jsonFile, []byte(`{"workers": [{"name": "api"}]}`), 0644))
+	_, err = NewFileSource(jsonFile).Discover(context.Background())
+	assert.Error(t, err)
+}
+
+func TestFileSourceWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "workers.yaml")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 1)
+	go NewFileSource(path).Watch(ctx, changed)
+
+	// Writes until the watcher, which starts asynchronously, reports one
+	deadline := time.After(5 * time.Second)
+	for {
+		require.NoError(t, os.WriteFile(path, []byte("workers: []\n"), 0644))
+		select {
+		case <-changed:
+			return
+		case <-deadline:
+			t.Fatal("file change not reported")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// stubResolver answers SRV lookups from a fixed table
+type stubResolver map[string][]*net.SRV
+
+func (r stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	records, ok := r[name]
+	if !ok {
+		return "", nil, errors.New("no such host")
+	}
+	return name, records, nil
+}
+
+func TestSRVSource(t *testing.T) {
+	source := NewSRVSource("_worker._tcp.example.com", "https", "")
+	source.resolver = stubResolver{"_worker._tcp.example.com": {
+		{Target: "worker-1.example.com.", Port: 8082, Priority: 10},
+		{Target: "worker-2.example.com.", Port: 9082, Priority: 20},
+	}}
+
+	targets, err := source.Discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []worker.Target{
+		{Name: "worker-1.example.com:8082", URL: "https://worker-1.example.com:8082"},
+		{Name: "worker-2.example.com:9082", URL: "https://worker-2.example.com:9082"},
+	}, targets)
+}
+
+func TestDockerSource(t *testing.T) {
+	docker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/containers/json", r.URL.Path)
+		assert.Contains(t, r.URL.Query().Get("filters"), LabelPort)
+		w.Write([]byte(`[
+			{"Names": ["/worker-b"], "Labels": {"config-agent.worker.port": "8082", "config-agent.worker.groups": "eu, canary"},
+			 "NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.3"}}}},
+			{"Names": ["/worker-a"], "Labels": {"config-agent.worker.port": "8082", "config-agent.worker.name": "api", "config-agent.worker.scheme": "https"},
+			 "NetworkSettings": {"Networks": {}}}
+		]`))
+	}))
+	defer docker.Close()
+
+	source, err := NewDockerSource("tcp://"+docker.Listener.Addr().String(), "http")
+	require.NoError(t, err)
+
+	targets, err := source.Discover(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []worker.Target{
+		{Name: "api", URL: "https://worker-a:8082"},
+		{Name: "worker-b", URL: "http://172.17.0.3:8082", Groups: []string{"eu", "canary"}},
+	}, targets)
+
+	_, err = NewDockerSource("ssh://docker", "http")
+	assert.Error(t, err)
+}
+
+// fakeWorker records the configs forwarded to it
+type fakeWorker struct {
+	mu       sync.Mutex
+	received []string
+}
+
+func (f *fakeWorker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var config models.WorkerConfig
+	json.NewDecoder(r.Body).Decode(&config)
+	f.mu.Lock()
+	f.received = append(f.received, config.URL)
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakeWorker) urls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.received...)
+}
+
+// staticSource returns whatever the test sets, or fails
+type staticSource struct {
+	targets []worker.Target
+	err     error
+}
+
+func (s *staticSource) Name() string { return "static" }
+
+func (s *staticSource) Discover(ctx context.Context) ([]worker.Target, error) {
+	return s.targets, s.err
+}
+
+func TestDiscovererPushesCurrentConfigToNewWorkers(t *testing.T) {
+	existing, discovered := &fakeWorker{}, &fakeWorker{}
+	existingServer, discoveredServer := httptest.NewServer(existing), httptest.NewServer(discovered)
+	defer existingServer.Close()
+	defer discoveredServer.Close()
+
+	static := []worker.Target{{Name: "static", URL: existingServer.URL}}
+	workerMgr := worker.NewMultiManager(static, nil)
+	source := &staticSource{}
+	d := NewDiscoverer(workerMgr, static, []Source{source}, time.Minute)
+
+	_, err := workerMgr.Forward(4, models.WorkerConfig{URL: "https://ip.me"}, "")
+	require.NoError(t, err)
+
+	source.targets = []worker.Target{{Name: "discovered", URL: discoveredServer.URL}}
+	d.Refresh(context.Background())
+	assert.Equal(t, []string{"https://ip.me"}, discovered.urls())
+	assert.Equal(t, []string{"https://ip.me"}, existing.urls())
+	assert.Len(t, workerMgr.Targets(), 2)
+
+	// A failed lookup keeps the workers found before
+	source.err = errors.New("lookup failed")
+	d.Refresh(context.Background())
+	assert.Len(t, workerMgr.Targets(), 2)
+
+	// Workers that disappear are dropped
+	source.targets, source.err = nil, nil
+	d.Refresh(context.Background())
+	assert.Equal(t, static, workerMgr.Targets())
+	assert.Equal(t, []string{"https://ip.me"}, discovered.urls())
+}
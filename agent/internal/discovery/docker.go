@@ -0,0 +1,147 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+)
+
+// Container labels that mark a container as a worker
+const (
+	LabelPort   = "config-agent.worker.port" // required
+	LabelName   = "config-agent.worker.name" // defaults to the container name
+	LabelGroups = "config-agent.worker.groups"
+	LabelScheme = "config-agent.worker.scheme"
+)
+
+// DockerSource discovers running containers labelled as workers through the
+// Docker Engine API
+type DockerSource struct {
+	endpoint string
+	scheme   string
+	client   *http.Client
+}
+
+type container struct {
+	Names           []string          `json:"Names"`
+	Labels          map[string]string `json:"Labels"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// NewDockerSource creates a source for the Docker daemon at host, e.g.
+// unix:///var/run/docker.sock or tcp://docker:2375. Workers are reached over
+// scheme unless their labels say otherwise.
+func NewDockerSource(host, scheme string) (*DockerSource, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Docker host %q: %w", host, err)
+	}
+
+	client := &http.Client{Timeout: lookupTimeout}
+	source := &DockerSource{scheme: scheme, client: client}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		source.endpoint = "http://docker"
+	case "tcp", "http":
+		source.endpoint = "http://" + u.Host
+	case "https":
+		source.endpoint = "https://" + u.Host
+	default:
+		return nil, fmt.Errorf("unsupported Docker host %q", host)
+	}
+	return source, nil
+}
+
+func (s *DockerSource) Name() string {
+	return "Docker"
+}
+
+// Discover lists running containers carrying the worker port label
+func (s *DockerSource) Discover(ctx context.Context) ([]worker.Target, error) {
+	filters, _ := json.Marshal(map[string][]string{"label": {LabelPort}})
+	req, err := http.NewRequestWithContext(ctx, "GET", s.endpoint+"/containers/json?filters="+url.QueryEscape(string(filters)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Docker returned status %d", resp.StatusCode)
+	}
+
+	var containers []container
+	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
+		return nil, fmt.Errorf("failed to decode containers: %w", err)
+	}
+
+	var targets []worker.Target
+	for _, c := range containers {
+		if target, ok := s.target(c); ok {
+			targets = append(targets, target)
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	return targets, nil
+}
+
+// target builds the worker of a container, addressed by its IP address on the
+// first network by name, or by its container name without one
+func (s *DockerSource) target(c container) (worker.Target, bool) {
+	port := c.Labels[LabelPort]
+	if port == "" || len(c.Names) == 0 {
+		return worker.Target{}, false
+	}
+
+	host := strings.TrimPrefix(c.Names[0], "/")
+	var networks []string
+	for network := range c.NetworkSettings.Networks {
+		networks = append(networks, network)
+	}
+	sort.Strings(networks)
+	for _, network := range networks {
+		if ip := c.NetworkSettings.Networks[network].IPAddress; ip != "" {
+			host = ip
+			break
+		}
+	}
+
+	name := c.Labels[LabelName]
+	if name == "" {
+		name = strings.TrimPrefix(c.Names[0], "/")
+	}
+	scheme := c.Labels[LabelScheme]
+	if scheme == "" {
+		scheme = s.scheme
+	}
+
+	target := worker.Target{Name: name, URL: fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, port))}
+	for _, group := range strings.Split(c.Labels[LabelGroups], ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			target.Groups = append(target.Groups, group)
+		}
+	}
+	return target, true
+}
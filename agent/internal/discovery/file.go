@@ -0,0 +1,98 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// FileSource reads workers from a JSON or YAML file of the form
+//
+//	workers:
+//	  - name: api
+//	    url: http://worker-1:8082
+//	    groups: [eu]
+type FileSource struct {
+	path string
+}
+
+type workerFile struct {
+	Workers []struct {
+		Name   string   `yaml:"name"`
+		URL    string   `yaml:"url"`
+		Groups []string `yaml:"groups"`
+	} `yaml:"workers"`
+}
+
+// NewFileSource creates a source for the worker file at path
+func NewFileSource(path string) *FileSource {
+	return &FileSource{path: filepath.Clean(path)}
+}
+
+func (f *FileSource) Name() string {
+	return "file " + f.path
+}
+
+// Discover reads the file; YAML parsing also accepts JSON
+func (f *FileSource) Discover(ctx context.Context) ([]worker.Target, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file workerFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse worker file: %w", err)
+	}
+
+	var targets []worker.Target
+	for i, entry := range file.Workers {
+		if entry.Name == "" || entry.URL == "" {
+			return nil, fmt.Errorf("worker %d needs a name and url", i+1)
+		}
+		targets = append(targets, worker.Target{Name: entry.Name, URL: entry.URL, Groups: entry.Groups})
+	}
+	return targets, nil
+}
+
+// Watch signals changed whenever the file is written, created, renamed or
+// removed. The directory is watched so that atomic replacements are noticed.
+func (f *FileSource) Watch(ctx context.Context, changed chan<- struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(f.path)); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) != f.path {
+				continue
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+)
+
+// resolver is the part of net.Resolver used for SRV lookups
+type resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// SRVSource discovers workers from the DNS SRV records of a name such as
+// _worker._tcp.example.com
+type SRVSource struct {
+	name     string
+	scheme   string
+	resolver resolver
+}
+
+// NewSRVSource creates a source for the SRV records of name. Workers are
+// reached over scheme. A non-empty dnsServer (host:port) is queried instead
+// of the system resolver.
+func NewSRVSource(name, scheme, dnsServer string) *SRVSource {
+	r := net.DefaultResolver
+	if dnsServer != "" {
+		r = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, dnsServer)
+			},
+		}
+	}
+	return &SRVSource{name: name, scheme: scheme, resolver: r}
+}
+
+func (s *SRVSource) Name() string {
+	return "DNS SRV " + s.name
+}
+
+// Discover returns a worker per SRV record, named host:port, in priority order
+func (s *SRVSource) Discover(ctx context.Context) ([]worker.Target, error) {
+	_, records, err := s.resolver.LookupSRV(ctx, "", "", s.name)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []worker.Target
+	for _, record := range records {
+		address := net.JoinHostPort(strings.TrimSuffix(record.Target, "."), fmt.Sprint(record.Port))
+		targets = append(targets, worker.Target{
+			Name: address,
+			URL:  fmt.Sprintf("%s://%s", s.scheme, address),
+		})
+	}
+	return targets, nil
+}
@@ -0,0 +1,233 @@
+package enroll
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/mtls"
+)
+
+// renewFraction is the share of a certificate's lifetime left when it is renewed
+const renewFraction = 3
+
+// Enroller obtains a client certificate from the controller's internal CA
+// using a bootstrap token, and renews it before it expires
+type Enroller struct {
+	controllerURL string
+	token         string
+	certFile      string
+	keyFile       string
+	caFile        string
+	client        *http.Client
+	reloader      *mtls.Reloader
+	checkInterval time.Duration
+	agentID       string
+}
+
+// NewEnroller creates an enroller that writes the issued certificate and key to
+// certFile and keyFile. tlsConfig is used for enrollment before a certificate exists.
+func NewEnroller(controllerURL, token, certFile, keyFile, caFile string, tlsConfig *tls.Config) *Enroller {
+	return &Enroller{
+		controllerURL: controllerURL,
+		token:         token,
+		certFile:      certFile,
+		keyFile:       keyFile,
+		caFile:        caFile,
+		client:        newHTTPClient(tlsConfig),
+		checkInterval: time.Minute,
+	}
+}
+
+func newHTTPClient(tlsConfig *tls.Config) *http.Client {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+	return client
+}
+
+// HasCertificate reports whether an unexpired certificate and key exist on disk
+func HasCertificate(certFile, keyFile string) bool {
+	if _, err := os.Stat(keyFile); err != nil {
+		return false
+	}
+	cert, err := loadCertificate(certFile)
+	return err == nil && time.Now().Before(cert.NotAfter)
+}
+
+// EnsureCertificate enrolls the agent unless a valid certificate for it already exists
+func (e *Enroller) EnsureCertificate(agentID string) error {
+	e.agentID = agentID
+
+	cert, err := loadCertificate(e.certFile)
+	if err == nil && cert.Subject.CommonName == agentID && time.Now().Before(cert.NotAfter) {
+		logger.Log.Infof("Using existing client certificate, expires %s", cert.NotAfter.Format(time.RFC3339))
+		return nil
+	}
+
+	return e.request("/api/v1/enroll", e.token)
+}
+
+// Attach makes renewals authenticate with the reloader's current certificate
+// and reloads it after each renewal
+func (e *Enroller) Attach(reloader *mtls.Reloader) {
+	e.reloader = reloader
+	e.client = newHTTPClient(reloader.ClientTLSConfig())
+}
+
+// Start renews the certificate before it expires until the context is cancelled
+func (e *Enroller) Start(ctx context.Context) {
+	ticker := time.NewTicker(e.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.renewIfDue(); err != nil {
+				logger.Log.Errorf("Certificate renewal failed: %v", err)
+			}
+		}
+	}
+}
+
+func (e *Enroller) renewIfDue() error {
+	cert, err := loadCertificate(e.certFile)
+	if err != nil || time.Now().After(cert.NotAfter) {
+		// Nothing usable to renew with: fall back to the bootstrap token
+		logger.Log.Warn("Client certificate missing or expired, re-enrolling")
+		return e.request("/api/v1/enroll", e.token)
+	}
+
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	if time.Until(cert.NotAfter) > lifetime/renewFraction {
+		return nil
+	}
+
+	logger.Log.Infof("Renewing client certificate, expires %s", cert.NotAfter.Format(time.RFC3339))
+	return e.request("/api/v1/enroll/renew", "")
+}
+
+// request sends a fresh CSR to the controller and stores the issued certificate
+func (e *Enroller) request(path, token string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: e.agentID},
+	}, key)
+	if err != nil {
+		return fmt.Errorf("failed to create CSR: %w", err)
+	}
+
+	reqBody, err := json.Marshal(models.EnrollRequest{
+		Token: token,
+		CSR:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := e.client.Post(e.controllerURL+path, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("controller returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var enrollResp models.EnrollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&enrollResp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key: %w", err)
+	}
+
+	if err := writeFileAtomic(e.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(e.certFile, []byte(enrollResp.Certificate), 0644); err != nil {
+		return err
+	}
+	if e.caFile != "" {
+		if _, err := os.Stat(e.caFile); os.IsNotExist(err) {
+			if err := writeFileAtomic(e.caFile, []byte(enrollResp.CACertificate), 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	logger.Log.Infof("Obtained client certificate %s, expires %s", enrollResp.Serial, enrollResp.ExpiresAt.Format(time.RFC3339))
+
+	if e.reloader != nil {
+		return e.reloader.Reload()
+	}
+	return nil
+}
+
+func loadCertificate(certFile string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("certificate is not PEM encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
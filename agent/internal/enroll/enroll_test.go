@@ -0,0 +1,119 @@
+package enroll
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCA issues certificates with the configured lifetime and records which endpoint was used
+type fakeCA struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	lifetime time.Duration
+	age      time.Duration
+	paths    []string
+	tokens   []string
+}
+
+func newFakeCA(t *testing.T, lifetime time.Duration) *fakeCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &fakeCA{cert: cert, key: key, lifetime: lifetime}
+}
+
+func (f *fakeCA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req models.EnrollRequest
+	json.NewDecoder(r.Body).Decode(&req)
+	f.paths = append(f.paths, r.URL.Path)
+	f.tokens = append(f.tokens, req.Token)
+
+	block, _ := pem.Decode([]byte(req.CSR))
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	der, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(int64(len(f.paths) + 1)),
+		Subject:      csr.Subject,
+		NotBefore:    now.Add(-f.age),
+		NotAfter:     now.Add(f.lifetime - f.age),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, f.cert, csr.PublicKey, f.key)
+
+	json.NewEncoder(w).Encode(models.EnrollResponse{
+		Certificate:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		CACertificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.cert.Raw})),
+		ExpiresAt:     now.Add(f.lifetime - f.age),
+	})
+}
+
+func TestEnrollAndRenew(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "agent.crt")
+	keyFile := filepath.Join(dir, "agent.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	ca := newFakeCA(t, time.Hour)
+	server := httptest.NewServer(ca)
+	defer server.Close()
+
+	assert.False(t, HasCertificate(certFile, keyFile))
+
+	enroller := NewEnroller(server.URL, "cme_bootstrap", certFile, keyFile, caFile, nil)
+	require.NoError(t, enroller.EnsureCertificate("agent-1"))
+	assert.True(t, HasCertificate(certFile, keyFile))
+	assert.FileExists(t, caFile)
+
+	cert, err := loadCertificate(certFile)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", cert.Subject.CommonName)
+
+	// A valid certificate is reused and not renewed early
+	require.NoError(t, enroller.EnsureCertificate("agent-1"))
+	require.NoError(t, enroller.renewIfDue())
+	assert.Equal(t, []string{"/api/v1/enroll"}, ca.paths)
+
+	// Once less than a third of the lifetime remains, the certificate is renewed without the token
+	ca.age = 50 * time.Minute
+	require.NoError(t, enroller.request("/api/v1/enroll", "cme_bootstrap"))
+	ca.age = 0
+	require.NoError(t, enroller.renewIfDue())
+
+	assert.Equal(t, "/api/v1/enroll/renew", ca.paths[len(ca.paths)-1])
+	assert.Equal(t, "", ca.tokens[len(ca.tokens)-1])
+
+	renewed, err := loadCertificate(certFile)
+	require.NoError(t, err)
+	assert.True(t, renewed.NotAfter.After(time.Now().Add(30*time.Minute)))
+}
@@ -0,0 +1,31 @@
+package poller
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAck(t *testing.T) {
+	envelope := &models.ConfigEnvelope{Version: 7, Timestamp: time.Now().Add(-250 * time.Millisecond)}
+
+	results := []models.WorkerResult{{Worker: "api", Success: true, Attempts: 1}}
+	ack := newAck("agent-1", envelope.Version, envelope.Timestamp, results, nil)
+	assert.Equal(t, "agent-1", ack.AgentID)
+	assert.Equal(t, int64(7), ack.Version)
+	assert.True(t, ack.Success)
+	assert.Empty(t, ack.Error)
+	assert.GreaterOrEqual(t, ack.LatencyMs, int64(250))
+	assert.Equal(t, results, ack.Workers)
+
+	ack = newAck("agent-1", envelope.Version, envelope.Timestamp, nil, errors.New("worker returned status 500"))
+	assert.False(t, ack.Success)
+	assert.Equal(t, "worker returned status 500", ack.Error)
+
+	// Polled configs carry no publish timestamp
+	ack = newAck("agent-1", 7, time.Time{}, nil, nil)
+	assert.Zero(t, ack.LatencyMs)
+}
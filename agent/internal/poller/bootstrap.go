@@ -0,0 +1,203 @@
+package poller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	natspkg "github.com/doniyusdinar/config-management/pkg/nats"
+	"github.com/doniyusdinar/config-management/pkg/redis"
+	"github.com/doniyusdinar/config-management/pkg/signing"
+)
+
+// bootstrapTimeout bounds each startup source so an unreachable one does not delay the others
+const bootstrapTimeout = 5 * time.Second
+
+// configSource returns the newest configuration a source knows about, or nil if it has none
+type configSource struct {
+	name  string
+	fetch func(ctx context.Context) (*models.ConfigResponse, error)
+}
+
+// startupSources lists the sources a push strategy bootstraps from: the local
+// cache, the push transport's own backup, then the controller over HTTP
+func startupSources(store *cache.Cache, fetcher *Poller, push configSource) []configSource {
+	sources := []configSource{cacheSource(store), push}
+	if fetcher != nil {
+		sources = append(sources, controllerSource(fetcher))
+	}
+	return sources
+}
+
+// cacheSource reads the newest usable version from the agent's local cache
+func cacheSource(store *cache.Cache) configSource {
+	return configSource{name: "cache", fetch: func(ctx context.Context) (*models.ConfigResponse, error) {
+		configResp, err := store.Load()
+		if errors.Is(err, cache.ErrEmpty) {
+			return nil, nil
+		}
+		return configResp, err
+	}}
+}
+
+// controllerSource fetches the active configuration over HTTP
+func controllerSource(fetcher *Poller) configSource {
+	return configSource{name: "controller", fetch: func(ctx context.Context) (*models.ConfigResponse, error) {
+		return fetcher.fetch(ctx, 0)
+	}}
+}
+
+// redisSource reads the latest_config backup stored by the controller
+func redisSource(client *redis.Client) configSource {
+	return configSource{name: "redis", fetch: func(ctx context.Context) (*models.ConfigResponse, error) {
+		envelope, err := client.GetConfigFromRedis()
+		if err != nil || envelope == nil {
+			return nil, err
+		}
+		return envelopeResponse(envelope), nil
+	}}
+}
+
+// natsSource asks the controller for the active configuration over request/reply
+func natsSource(client *natspkg.Client, authorization string) configSource {
+	return configSource{name: "nats", fetch: func(ctx context.Context) (*models.ConfigResponse, error) {
+		envelope, err := RequestConfig(client, authorization)
+		if err != nil {
+			return nil, err
+		}
+		return envelopeResponse(envelope), nil
+	}}
+}
+
+// RequestConfig asks the controller for the active configuration envelope over NATS
+func RequestConfig(client *natspkg.Client, authorization string) (*models.ConfigEnvelope, error) {
+	data, err := natsRequest(client, natspkg.ConfigRequestSubject, models.NatsRequest{Authorization: authorization})
+	if err != nil {
+		return nil, err
+	}
+	return models.DecodeConfigEnvelope(data)
+}
+
+// RegisterOverNats registers the agent with the controller over NATS request/reply
+func RegisterOverNats(client *natspkg.Client, authorization string, req models.RegisterRequest) (*models.RegisterResponse, error) {
+	data, err := natsRequest(client, natspkg.RegisterRequestSubject, models.NatsRequest{Authorization: authorization, Register: &req})
+	if err != nil {
+		return nil, err
+	}
+
+	var resp models.RegisterResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &resp, nil
+}
+
+// natsRequest sends a request to the controller and returns the reply, or the
+// error the controller replied with
+func natsRequest(client *natspkg.Client, subject string, req models.NatsRequest) ([]byte, error) {
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	msg, err := client.PublishRequest(subject, reqBody, bootstrapTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("no reply on %s: %w", subject, err)
+	}
+
+	var natsErr models.NatsError
+	if json.Unmarshal(msg.Data, &natsErr) == nil && natsErr.Error != "" {
+		return nil, fmt.Errorf("controller replied: %s", natsErr.Error)
+	}
+	return msg.Data, nil
+}
+
+func envelopeResponse(envelope *models.ConfigEnvelope) *models.ConfigResponse {
+	timestamp := envelope.Timestamp
+	return &models.ConfigResponse{
+		Version:   envelope.Version,
+		Data:      envelope.Config,
+		Signature: envelope.Signature,
+		Origin:    envelope.Origin,
+		Timestamp: &timestamp,
+		Resync:    envelope.Resync,
+	}
+}
+
+// bootstrap queries every source in order and applies the newest verified
+// configuration. On equal versions later sources win, so list them from least
+// to most authoritative. The applied configuration is cached. It returns the
+// version forwarded to the worker, or 0.
+func bootstrap(ctx context.Context, workerMgr *worker.Manager, verifier *signing.Verifier, fetcher *Poller, store *cache.Cache, sources ...configSource) int64 {
+	var newest *models.ConfigResponse
+	var newestSource string
+
+	for _, source := range sources {
+		sourceCtx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+		candidate, err := source.fetch(sourceCtx)
+		cancel()
+		if err != nil {
+			logger.Log.Warnf("Startup config from %s unavailable: %v", source.name, err)
+			continue
+		}
+		if candidate == nil {
+			continue
+		}
+
+		if err := verifier.Verify(signing.ResponsePayload(candidate), candidate.Signature); err != nil {
+			logger.Log.Errorf("Rejected startup config from %s: version %d: %v", source.name, candidate.Version, err)
+			continue
+		}
+
+		if newest == nil || candidate.Version >= newest.Version {
+			newest = candidate
+			newestSource = source.name
+		}
+	}
+
+	if newest == nil {
+		logger.Log.Warn("No startup config found, waiting for the next push")
+		return 0
+	}
+
+	if newest.Data.IsRedacted() {
+		// Secret values are only served over HTTP, which did not answer
+		logger.Log.Warnf("Startup config version %d from %s has redacted secrets, waiting for the controller", newest.Version, newestSource)
+		return 0
+	}
+
+	logger.Log.Infof("Applying startup config version %d from %s", newest.Version, newestSource)
+	if fetcher != nil {
+		if err := fetcher.apply(*newest); err != nil {
+			logger.Log.Errorf("Failed to apply startup config: %v", err)
+			return 0
+		}
+		return newest.Version
+	}
+
+	if _, err := workerMgr.Forward(newest.Version, newest.Data, ""); err != nil {
+		logger.Log.Errorf("Failed to forward startup config to workers: %v", err)
+		return 0
+	}
+	if err := store.Save(*newest); err != nil {
+		logger.Log.Warnf("Failed to save cache: %v", err)
+	}
+	return newest.Version
+}
+
+// cachePushed caches a pushed configuration the agent applies. Configs with
+// secrets arrive redacted; the fetcher applies and caches those itself.
+func cachePushed(store *cache.Cache, envelope *models.ConfigEnvelope) {
+	if envelope.Config.IsRedacted() {
+		return
+	}
+	if err := store.Save(*envelopeResponse(envelope)); err != nil {
+		logger.Log.Warnf("Failed to save cache: %v", err)
+	}
+}
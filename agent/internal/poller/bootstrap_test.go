@@ -0,0 +1,85 @@
+package poller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func staticSource(name string, resp *models.ConfigResponse) configSource {
+	return configSource{name: name, fetch: func(ctx context.Context) (*models.ConfigResponse, error) {
+		return resp, nil
+	}}
+}
+
+func TestBootstrapAppliesNewestVersion(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+	workerMgr := worker.NewManager(workerServer.URL, nil)
+
+	withSecrets := models.WorkerConfig{URL: "https://api.example.com", SecretHeaders: map[string]models.Secret{"Authorization": "Bearer s3cret"}}
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := models.ConfigResponse{Version: 4, Data: withSecrets}
+		resp.Signature, _ = signer.Sign(signing.ResponsePayload(&resp))
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer controller.Close()
+
+	store := cache.New(filepath.Join(t.TempDir(), "cache"), 3, verifier)
+	fetcher := NewPoller(controller.URL, "agent", "secret", workerMgr, store, nil, verifier)
+	require.NoError(t, store.Save(signedResponse(t, signer, 2, "https://ip.me")))
+
+	// The push backup has the same version with secrets redacted; the controller copy wins
+	pushed := envelopeResponse(signedEnvelope(t, signer, 4, withSecrets.Redacted()))
+	version := bootstrap(context.Background(), workerMgr, verifier, fetcher, store,
+		startupSources(store, fetcher, staticSource("redis", pushed))...)
+
+	assert.Equal(t, int64(4), version)
+	assert.Equal(t, int64(4), fetcher.currentVersion)
+	assert.Equal(t, []string{"https://api.example.com"}, fw.urls())
+}
+
+func TestBootstrapFallsBackToCache(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+	workerMgr := worker.NewManager(workerServer.URL, nil)
+
+	store := cache.New(filepath.Join(t.TempDir(), "cache"), 3, verifier)
+	fetcher := NewPoller("http://127.0.0.1:1", "agent", "secret", workerMgr, store, nil, verifier)
+	require.NoError(t, store.Save(signedResponse(t, signer, 2, "https://ip.me")))
+
+	// A forged newer version must not win over the verified cache
+	forged := signedResponse(t, signer, 9, "https://ip.me")
+	forged.Data.URL = "https://evil.example.com"
+	version := bootstrap(context.Background(), workerMgr, verifier, fetcher, store,
+		startupSources(store, fetcher, staticSource("nats", &forged))...)
+
+	assert.Equal(t, int64(2), version)
+	assert.Equal(t, []string{"https://ip.me"}, fw.urls())
+}
+
+func TestBootstrapWithoutConfig(t *testing.T) {
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+
+	version := bootstrap(context.Background(), worker.NewManager(workerServer.URL, nil), nil, nil, nil,
+		startupSources(nil, nil, staticSource("redis", nil))...)
+
+	assert.Equal(t, int64(0), version)
+	assert.Empty(t, fw.urls())
+}
@@ -2,14 +2,22 @@ package poller
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
 	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/auth"
+	"github.com/doniyusdinar/config-management/pkg/kafka"
 	"github.com/doniyusdinar/config-management/pkg/logger"
 	"github.com/doniyusdinar/config-management/pkg/models"
 	"github.com/doniyusdinar/config-management/pkg/redis"
+	"github.com/doniyusdinar/config-management/pkg/signing"
 	natspkg "github.com/doniyusdinar/config-management/pkg/nats"
 	"github.com/nats-io/nats.go"
 )
@@ -21,8 +29,7 @@ const (
 	StrategyPoller DistributionStrategy = "POLLER"
 	StrategyRedis  DistributionStrategy = "REDIS"
 	StrategyNats   DistributionStrategy = "NATS"
-	// Future strategies:
-	// StrategyKafka  DistributionStrategy = "KAFKA"
+	StrategyKafka  DistributionStrategy = "KAFKA"
 )
 
 // ConfigDistributor interface for different distribution strategies
@@ -37,9 +44,9 @@ type PollerDistributor struct {
 	poller *Poller
 }
 
-func NewPollerDistributor(controllerURL, username, password string, workerMgr *worker.Manager, cacheFile string) *PollerDistributor {
+func NewPollerDistributor(controllerURL, username, password string, workerMgr *worker.Manager, store *cache.Cache, tlsConfig *tls.Config, verifier *signing.Verifier) *PollerDistributor {
 	return &PollerDistributor{
-		poller: NewPoller(controllerURL, username, password, workerMgr, cacheFile),
+		poller: NewPoller(controllerURL, username, password, workerMgr, store, tlsConfig, verifier),
 	}
 }
 
@@ -57,18 +64,71 @@ func (pd *PollerDistributor) GetType() DistributionStrategy {
 	return StrategyPoller
 }
 
+func (pd *PollerDistributor) GetLastVersion() int64 {
+	return pd.poller.version()
+}
+
+// forwardPushed forwards a pushed config to the workers that accept group ("" for
+// every worker). Push messages carry secret values redacted, so those configs are
+// fetched from the controller instead, which acknowledges them itself.
+func forwardPushed(ctx context.Context, workerMgr *worker.Manager, fetcher *Poller, envelope *models.ConfigEnvelope, group string) ([]models.WorkerResult, error) {
+	if !envelope.Config.IsRedacted() {
+		return workerMgr.Forward(envelope.Version, envelope.Config, group)
+	}
+	if fetcher == nil {
+		return nil, fmt.Errorf("config contains secrets but no controller fetcher is configured")
+	}
+
+	logger.Log.Info("Config contains secrets, fetching it from the controller")
+	return nil, fetcher.poll(ctx)
+}
+
+// forgetApplied makes the next forward reach every worker again, including
+// those that already accepted its version, and have the fetcher download it again
+func forgetApplied(workerMgr *worker.Manager, fetcher *Poller) {
+	workerMgr.ForgetVersions()
+	if fetcher != nil {
+		fetcher.setVersion(0)
+	}
+}
+
+// newAck builds the acknowledgement of a config version. Latency is measured
+// from the controller's publish timestamp, so it includes transport delay; it is
+// zero for polled configs, which carry no timestamp.
+func newAck(agentID string, version int64, published time.Time, results []models.WorkerResult, forwardErr error) models.ConfigAck {
+	now := time.Now()
+	ack := models.ConfigAck{
+		AgentID:   agentID,
+		Version:   version,
+		Success:   forwardErr == nil,
+		Timestamp: now,
+		Workers:   results,
+	}
+	if !published.IsZero() {
+		ack.LatencyMs = now.Sub(published).Milliseconds()
+	}
+	if forwardErr != nil {
+		ack.Error = forwardErr.Error()
+	}
+	return ack
+}
+
 // RedisDistributor implements Redis pub/sub strategy
 type RedisDistributor struct {
 	redisClient *redis.Client
 	workerMgr   *worker.Manager
+	verifier    *signing.Verifier
+	fetcher     *Poller // fetches configs with secrets over HTTP
+	cache       *cache.Cache
+	agentID     string // identifies this agent in acks
 	ctx         context.Context
 	cancel      context.CancelFunc
 	mu          sync.RWMutex
 	lastConfig  *models.WorkerConfig
-	lastVersion string
+	lastVersion int64
 }
 
-func NewRedisDistributor(redisConfig redis.Config, workerMgr *worker.Manager) (*RedisDistributor, error) {
+func NewRedisDistributor(redisConfig redis.Config, workerMgr *worker.Manager, verifier *signing.Verifier, fetcher *Poller, store *cache.Cache, agentID string) (*RedisDistributor, error) {
 	redisClient, err := redis.NewClient(redisConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Redis client: %w", err)
@@ -79,6 +139,10 @@ func NewRedisDistributor(redisConfig redis.Config, workerMgr *worker.Manager) (*
 	return &RedisDistributor{
 		redisClient: redisClient,
 		workerMgr:   workerMgr,
+		verifier:    verifier,
+		fetcher:     fetcher,
+		cache:       store,
+		agentID:     agentID,
 		ctx:         ctx,
 		cancel:      cancel,
 	}, nil
@@ -93,6 +157,15 @@ func (rd *RedisDistributor) Start(ctx context.Context) error {
 		return fmt.Errorf("failed to subscribe to Redis config: %w", err)
 	}
 
+	// Apply the newest known config before handling pushes, so a fresh agent
+	// does not wait for the next change
+	rd.mu.Lock()
+	sources := startupSources(rd.cache, rd.fetcher, redisSource(rd.redisClient))
+	if version := bootstrap(ctx, rd.workerMgr, rd.verifier, rd.fetcher, rd.cache, sources...); version > 0 {
+		rd.lastVersion = version
+	}
+	rd.mu.Unlock()
+
 	// Handle Redis messages
 	go rd.handleRedisMessages(configChan)
 
@@ -101,7 +174,7 @@ func (rd *RedisDistributor) Start(ctx context.Context) error {
 	return ctx.Err()
 }
 
-func (rd *RedisDistributor) handleRedisMessages(configChan <-chan redis.ConfigMessage) {
+func (rd *RedisDistributor) handleRedisMessages(configChan <-chan models.ConfigEnvelope) {
 	logger.Log.Info("Redis config subscriber started")
 
 	for {
@@ -109,32 +182,58 @@ func (rd *RedisDistributor) handleRedisMessages(configChan <-chan redis.ConfigMe
 		case <-rd.ctx.Done():
 			logger.Log.Info("Redis subscriber shutting down")
 			return
-		case configMsg, ok := <-configChan:
+		case envelope, ok := <-configChan:
 			if !ok {
 				logger.Log.Warn("Redis config channel closed")
 				return
 			}
 
+			if err := rd.verifier.Verify(signing.EnvelopePayload(&envelope), envelope.Signature); err != nil {
+				logger.Log.Errorf("Rejected config from Redis: version %d: %v", envelope.Version, err)
+				rd.ack(&envelope, nil, fmt.Errorf("rejected: %w", err))
+				continue
+			}
+
 			rd.mu.Lock()
-			// Check if this is a new version
-			if configMsg.Version != rd.lastVersion {
-				rd.lastConfig = &configMsg.Config
-				rd.lastVersion = configMsg.Version
-
-				logger.Log.Infof("Received new config from Redis: version %s", configMsg.Version)
-
-				// Forward to worker
-				if err := rd.workerMgr.ForwardConfig(configMsg.Config); err != nil {
-					logger.Log.Errorf("Failed to forward Redis config to worker: %v", err)
-				} else {
-					logger.Log.Info("Successfully forwarded Redis config to worker")
-				}
+			// Only newer versions are applied, so a replayed or delayed older
+			// message cannot roll the workers back
+			if envelope.Version <= rd.lastVersion {
+				rd.mu.Unlock()
+				continue
 			}
+			rd.lastConfig = &envelope.Config
+			rd.lastVersion = envelope.Version
+			// Forwarding can take a while; status reads must not wait for it
 			rd.mu.Unlock()
+
+			logger.Log.Infof("Received new config from Redis: version %d (origin %s)", envelope.Version, envelope.Origin)
+
+			// Forward to workers
+			results, err := forwardPushed(rd.ctx, rd.workerMgr, rd.fetcher, &envelope, "")
+			cachePushed(rd.cache, &envelope)
+			if err != nil {
+				logger.Log.Errorf("Failed to forward Redis config to workers, delivery queued: %v", err)
+			} else {
+				logger.Log.Info("Successfully forwarded Redis config to workers")
+			}
+			if len(results) > 0 || err != nil {
+				rd.ack(&envelope, results, err)
+			}
 		}
 	}
 }
 
+// ack reports the outcome of a pushed config to the controller
+func (rd *RedisDistributor) ack(envelope *models.ConfigEnvelope, results []models.WorkerResult, forwardErr error) {
+	if rd.agentID == "" {
+		return
+	}
+	ack := newAck(rd.agentID, envelope.Version, envelope.Timestamp, results, forwardErr)
+	if err := rd.redisClient.PublishAck(ack); err != nil {
+		logger.Log.Warnf("Failed to publish ack to Redis: %v", err)
+	}
+}
+
 func (rd *RedisDistributor) Stop() error {
 	logger.Log.Info("Stopping Redis distributor")
 	rd.cancel()
@@ -154,26 +253,43 @@ func (rd *RedisDistributor) GetLastConfig() *models.WorkerConfig {
 	return rd.lastConfig
 }
 
-func (rd *RedisDistributor) GetLastVersion() string {
+func (rd *RedisDistributor) GetLastVersion() int64 {
 	rd.mu.RLock()
 	defer rd.mu.RUnlock()
 	return rd.lastVersion
 }
 
+func (rd *RedisDistributor) IsConnected() bool {
+	return rd.redisClient.IsConnected()
+}
+
+// advance records a version applied outside the subscription, e.g. by a resync
+func (rd *RedisDistributor) advance(version int64) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if version > rd.lastVersion {
+		rd.lastVersion = version
+	}
+}
+
 // NatsDistributor implements NATS pub/sub strategy
 type NatsDistributor struct {
 	natsClient  *natspkg.Client
 	workerMgr   *worker.Manager
+	verifier    *signing.Verifier
+	fetcher     *Poller // fetches configs with secrets over HTTP
+	cache       *cache.Cache
+	authHeader  string  // agent credentials for NATS requests to the controller
 	ctx         context.Context
 	cancel      context.CancelFunc
 	mu          sync.RWMutex
 	lastConfig  *models.WorkerConfig
-	lastVersion string
-	subscription *nats.Subscription
+	lastVersion int64
+	subscriptions []*nats.Subscription
 	config      natspkg.Config
 }
 
-func NewNatsDistributor(natsConfig natspkg.Config, workerMgr *worker.Manager) (*NatsDistributor, error) {
+func NewNatsDistributor(natsConfig natspkg.Config, workerMgr *worker.Manager, verifier *signing.Verifier, fetcher *Poller, store *cache.Cache, username, password string) (*NatsDistributor, error) {
 	natsClient := natspkg.NewClient(natsConfig)
 	
 	err := natsClient.Connect()
@@ -186,6 +302,10 @@ func NewNatsDistributor(natsConfig natspkg.Config, workerMgr *worker.Manager) (*
 	return &NatsDistributor{
 		natsClient: natsClient,
 		workerMgr:  workerMgr,
+		verifier:   verifier,
+		fetcher:    fetcher,
+		cache:      store,
+		authHeader: auth.CreateBasicAuthHeader(username, password),
 		ctx:        ctx,
 		cancel:     cancel,
 		config:     natsConfig,
@@ -195,20 +315,31 @@ func NewNatsDistributor(natsConfig natspkg.Config, workerMgr *worker.Manager) (*
 func (nd *NatsDistributor) Start(ctx context.Context) error {
 	logger.Log.Info("Starting NATS pub/sub distribution strategy")
 
-	// Subscribe to NATS config changes
+	// Subscribe to broadcasts and to subjects targeting this agent's environment, groups and ID
 	subject := nd.config.Subject
 	if subject == "" {
-		subject = "config.worker.update"
+		subject = natspkg.DefaultSubject
 	}
+	subjects := append([]string{subject}, natspkg.TargetSubjects(nd.config.Environment, nd.config.Groups, nd.config.AgentID)...)
 
-	// Use regular subscription (not queue group) so ALL agents receive ALL config updates
-	var err error
-	nd.subscription, err = nd.natsClient.Subscribe(subject, nd.handleNatsMessage)
-	if err != nil {
-		return fmt.Errorf("failed to subscribe to NATS subject %s: %w", subject, err)
+	// Use regular subscriptions (not queue group) so ALL agents receive ALL config updates
+	for _, subject := range subjects {
+		subscription, err := nd.natsClient.Subscribe(subject, nd.handleNatsMessage)
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to NATS subject %s: %w", subject, err)
+		}
+		nd.subscriptions = append(nd.subscriptions, subscription)
 	}
 
-	logger.Log.Infof("NATS subscriber started on subject: %s (broadcast mode)", subject)
+	logger.Log.Infof("NATS subscriber started on subjects: %v (broadcast mode)", subjects)
+
+	// Apply the newest known config; pushes received meanwhile wait for the lock
+	nd.mu.Lock()
+	sources := startupSources(nd.cache, nd.fetcher, natsSource(nd.natsClient, nd.authHeader))
+	if version := bootstrap(ctx, nd.workerMgr, nd.verifier, nd.fetcher, nd.cache, sources...); version > 0 {
+		nd.lastVersion = version
+	}
+	nd.mu.Unlock()
 
 	// Wait for context cancellation
 	<-ctx.Done()
@@ -218,32 +349,73 @@ func (nd *NatsDistributor) Start(ctx context.Context) error {
 func (nd *NatsDistributor) handleNatsMessage(msg *nats.Msg) {
 	logger.Log.Debugf("Received NATS message: %s", string(msg.Data))
 
-	var configMsg struct {
-		Version string              `json:"version"`
-		Config  models.WorkerConfig `json:"config"`
+	envelope, err := models.DecodeConfigEnvelope(msg.Data)
+	if err != nil {
+		logger.Log.Errorf("Rejected config message from NATS: %v", err)
+		return
 	}
 
-	if err := json.Unmarshal(msg.Data, &configMsg); err != nil {
-		logger.Log.Errorf("Failed to unmarshal NATS config message: %v", err)
+	if err := nd.verifier.Verify(signing.EnvelopePayload(envelope), envelope.Signature); err != nil {
+		logger.Log.Errorf("Rejected config from NATS: version %d: %v", envelope.Version, err)
+		nd.ack(envelope, nil, fmt.Errorf("rejected: %w", err))
 		return
 	}
 
 	nd.mu.Lock()
-	defer nd.mu.Unlock()
+	// A message targeted at a group only reaches the workers in that group, so
+	// it does not count as applied for the agent; workers that already run the
+	// version are skipped either way
+	group := natspkg.TargetGroup(msg.Subject)
+	if envelope.Resync {
+		// A resync re-applies the current version but never an older one
+		if envelope.Version < nd.lastVersion {
+			nd.mu.Unlock()
+			return
+		}
+		forgetApplied(nd.workerMgr, nd.fetcher)
+	}
+	if group == "" {
+		// A replayed or delayed older broadcast must not roll the workers back
+		if envelope.Version <= nd.lastVersion && !envelope.Resync {
+			nd.mu.Unlock()
+			return
+		}
+		nd.lastConfig = &envelope.Config
+		nd.lastVersion = envelope.Version
+	}
+	// Forwarding can take a while; status reads must not wait for it
+	nd.mu.Unlock()
 
-	// Check if this is a new version
-	if configMsg.Version != nd.lastVersion {
-		nd.lastConfig = &configMsg.Config
-		nd.lastVersion = configMsg.Version
+	logger.Log.Infof("Received config from NATS: version %d (origin %s, subject %s)", envelope.Version, envelope.Origin, msg.Subject)
 
-		logger.Log.Infof("Received new config from NATS: version %s", configMsg.Version)
+	// Forward to workers
+	results, err := forwardPushed(nd.ctx, nd.workerMgr, nd.fetcher, envelope, group)
+	if group == "" {
+		cachePushed(nd.cache, envelope)
+	}
+	if err != nil {
+		logger.Log.Errorf("Failed to forward NATS config to workers, delivery queued: %v", err)
+	} else if len(results) > 0 {
+		logger.Log.Infof("Successfully forwarded NATS config to %d worker(s)", len(results))
+	}
+	if len(results) > 0 || err != nil {
+		nd.ack(envelope, results, err)
+	}
+}
 
-		// Forward to worker
-		if err := nd.workerMgr.ForwardConfig(configMsg.Config); err != nil {
-			logger.Log.Errorf("Failed to forward NATS config to worker: %v", err)
-		} else {
-			logger.Log.Info("Successfully forwarded NATS config to worker")
-		}
+// ack reports the outcome of a pushed config to the controller
+func (nd *NatsDistributor) ack(envelope *models.ConfigEnvelope, results []models.WorkerResult, forwardErr error) {
+	if nd.config.AgentID == "" || nd.natsClient == nil {
+		return
+	}
+
+	data, err := json.Marshal(newAck(nd.config.AgentID, envelope.Version, envelope.Timestamp, results, forwardErr))
+	if err != nil {
+		logger.Log.Warnf("Failed to marshal ack: %v", err)
+		return
+	}
+	if err := nd.natsClient.Publish(natspkg.AckSubject, data); err != nil {
+		logger.Log.Warnf("Failed to publish ack to NATS: %v", err)
 	}
 }
 
@@ -251,8 +423,8 @@ func (nd *NatsDistributor) Stop() error {
 	logger.Log.Info("Stopping NATS distributor")
 	nd.cancel()
 	
-	if nd.subscription != nil {
-		if err := nd.subscription.Unsubscribe(); err != nil {
+	for _, subscription := range nd.subscriptions {
+		if err := subscription.Unsubscribe(); err != nil {
 			logger.Log.Warnf("Failed to unsubscribe from NATS: %v", err)
 		}
 	}
@@ -273,76 +445,442 @@ func (nd *NatsDistributor) GetLastConfig() *models.WorkerConfig {
 	return nd.lastConfig
 }
 
-func (nd *NatsDistributor) GetLastVersion() string {
+func (nd *NatsDistributor) GetLastVersion() int64 {
 	nd.mu.RLock()
 	defer nd.mu.RUnlock()
 	return nd.lastVersion
 }
 
-// DistributionManager manages the selected distribution strategy
+func (nd *NatsDistributor) IsConnected() bool {
+	return nd.natsClient != nil && nd.natsClient.IsConnected()
+}
+
+// advance records a version applied outside the subscription, e.g. by a resync
+func (nd *NatsDistributor) advance(version int64) {
+	nd.mu.Lock()
+	defer nd.mu.Unlock()
+	if version > nd.lastVersion {
+		nd.lastVersion = version
+	}
+}
+
+// candidate is a strategy the agent can run, with the controller's offer for it
+// (nil when the agent falls back to its own settings)
+type candidate struct {
+	strategy DistributionStrategy
+	offer    *models.TransportOffer
+}
+
+// sameTransport reports whether two candidates connect the same way; a
+// priority change alone does not require a switch
+func (c candidate) sameTransport(other candidate) bool {
+	if c.strategy != other.strategy || (c.offer == nil) != (other.offer == nil) {
+		return false
+	}
+	if c.offer == nil {
+		return true
+	}
+	a, b := *c.offer, *other.offer
+	a.Priority, b.Priority = 0, 0
+	return reflect.DeepEqual(a, b)
+}
+
+// rankStrategies orders the offers the agent supports, best first. Without any,
+// the agent falls back to its first supported strategy and its own settings.
+func rankStrategies(supported []DistributionStrategy, offers []models.TransportOffer) []candidate {
+	var candidates []candidate
+	for i := range offers {
+		offer := offers[i]
+		for _, strategy := range supported {
+			if string(strategy) == offer.Strategy {
+				candidates = append(candidates, candidate{strategy: strategy, offer: &offer})
+				break
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].offer.Priority > candidates[j].offer.Priority
+	})
+
+	if len(candidates) == 0 && len(supported) > 0 {
+		candidates = append(candidates, candidate{strategy: supported[0]})
+	}
+	return candidates
+}
+
+// DistributionManager runs the best distribution strategy the controller offers
+// and the agent supports, switching live when the advertisement changes
 type DistributionManager struct {
-	strategy    DistributionStrategy
+	supported       []DistributionStrategy
+	refreshInterval time.Duration
+
+	workerMgr   *worker.Manager
+	verifier    *signing.Verifier
+	fetcher     *Poller // nil for agents without an HTTP path to the controller
+	cache       *cache.Cache
+	redisConfig redis.Config
+	natsConfig  natspkg.Config
+	kafkaConfig kafka.Config
+	username    string
+	password    string
+	agentID     string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu          sync.RWMutex
+	current     candidate
 	distributor ConfigDistributor
-	ctx         context.Context
-	cancel      context.CancelFunc
+	stopCurrent context.CancelFunc
 }
 
-// NewDistributionManager creates a new distribution manager with the specified strategy
+// NewDistributionManager creates a distribution manager for the best offered
+// strategy among supported. Offers come from registration and are re-read from
+// the controller every refreshInterval (0 disables).
 func NewDistributionManager(
-	strategy DistributionStrategy,
+	supported []DistributionStrategy,
+	offers []models.TransportOffer,
+	refreshInterval time.Duration,
 	controllerURL, username, password string,
 	workerMgr *worker.Manager,
-	cacheFile string,
+	store *cache.Cache,
 	redisConfig redis.Config,
 	natsConfig natspkg.Config,
+	kafkaConfig kafka.Config,
+	tlsConfig *tls.Config,
+	verifier *signing.Verifier,
+	natsOnly bool,
+	agentID string,
 ) (*DistributionManager, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	var distributor ConfigDistributor
-	var err error
+	// Push strategies fetch configs with secrets over the authenticated HTTP endpoint
+	// unless the agent has no HTTP path to the controller
+	var fetcher *Poller
+	if !natsOnly {
+		fetcher = NewPoller(controllerURL, username, password, workerMgr, store, tlsConfig, verifier)
+	}
+
+	natsConfig.AgentID = agentID
+	kafkaConfig.AgentID = agentID
+	if fetcher != nil {
+		fetcher.setAgentID(agentID)
+	}
+
+	dm := &DistributionManager{
+		supported:       supported,
+		refreshInterval: refreshInterval,
+		workerMgr:       workerMgr,
+		verifier:        verifier,
+		fetcher:         fetcher,
+		cache:           store,
+		redisConfig:     redisConfig,
+		natsConfig:      natsConfig,
+		kafkaConfig:     kafkaConfig,
+		username:        username,
+		password:        password,
+		agentID:         agentID,
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+
+	var lastErr error
+	for _, c := range rankStrategies(supported, offers) {
+		distributor, err := dm.newDistributor(c)
+		if err != nil {
+			logger.Log.Warnf("Cannot use %s distribution: %v", c.strategy, err)
+			lastErr = err
+			continue
+		}
+		dm.current, dm.distributor = c, distributor
+		return dm, nil
+	}
+
+	cancel()
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no supported distribution strategy")
+	}
+	return nil, lastErr
+}
+
+// newDistributor creates the distributor for a candidate, connecting to the
+// endpoints the controller advertised
+func (dm *DistributionManager) newDistributor(c candidate) (ConfigDistributor, error) {
+	dm.mu.RLock()
+	redisConfig, natsConfig, kafkaConfig, agentID := dm.redisConfig, dm.natsConfig, dm.kafkaConfig, dm.agentID
+	dm.mu.RUnlock()
 
-	switch strategy {
+	switch c.strategy {
 	case StrategyPoller:
-		distributor = NewPollerDistributor(controllerURL, username, password, workerMgr, cacheFile)
+		if dm.fetcher == nil {
+			return nil, fmt.Errorf("polling requires an HTTP path to the controller")
+		}
+		return &PollerDistributor{poller: dm.fetcher}, nil
 	case StrategyRedis:
-		distributor, err = NewRedisDistributor(redisConfig, workerMgr)
+		if c.offer != nil {
+			if len(c.offer.Endpoints) > 0 {
+				redisConfig.Address = c.offer.Endpoints[0]
+			}
+			if c.offer.Channel != "" {
+				redisConfig.Channel = c.offer.Channel
+			}
+		}
+		distributor, err := NewRedisDistributor(redisConfig, dm.workerMgr, dm.verifier, dm.fetcher, dm.cache, agentID)
 		if err != nil {
-			cancel()
 			return nil, fmt.Errorf("failed to create Redis distributor: %w", err)
 		}
+		return distributor, nil
 	case StrategyNats:
-		distributor, err = NewNatsDistributor(natsConfig, workerMgr)
+		if c.offer != nil {
+			if len(c.offer.Endpoints) > 0 {
+				natsConfig.URLs = c.offer.Endpoints
+			}
+			if c.offer.Subject != "" {
+				natsConfig.Subject = c.offer.Subject
+			}
+		}
+		distributor, err := NewNatsDistributor(natsConfig, dm.workerMgr, dm.verifier, dm.fetcher, dm.cache, dm.username, dm.password)
 		if err != nil {
-			cancel()
 			return nil, fmt.Errorf("failed to create NATS distributor: %w", err)
 		}
+		return distributor, nil
+	case StrategyKafka:
+		if c.offer != nil {
+			if len(c.offer.Endpoints) > 0 {
+				kafkaConfig.Brokers = c.offer.Endpoints
+			}
+			if c.offer.Topic != "" {
+				kafkaConfig.Topic = c.offer.Topic
+			}
+		}
+		distributor, err := NewKafkaDistributor(kafkaConfig, dm.workerMgr, dm.verifier, dm.fetcher, dm.cache)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kafka distributor: %w", err)
+		}
+		return distributor, nil
 	default:
-		cancel()
-		return nil, fmt.Errorf("unsupported distribution strategy: %s", strategy)
+		return nil, fmt.Errorf("unsupported distribution strategy: %s", c.strategy)
 	}
-
-	return &DistributionManager{
-		strategy:    strategy,
-		distributor: distributor,
-		ctx:         ctx,
-		cancel:      cancel,
-	}, nil
 }
 
-// Start begins the distribution process using the selected strategy
+// Start runs the current distributor, restarting with the new one after each
+// switch, until the manager is stopped
 func (dm *DistributionManager) Start() error {
-	logger.Log.Infof("Starting distribution manager with strategy: %s", dm.strategy)
-	return dm.distributor.Start(dm.ctx)
+	if dm.fetcher != nil && dm.refreshInterval > 0 {
+		go dm.watchTransports()
+	}
+
+	for {
+		dm.mu.Lock()
+		distributor := dm.distributor
+		runCtx, stop := context.WithCancel(dm.ctx)
+		dm.stopCurrent = stop
+		dm.mu.Unlock()
+
+		logger.Log.Infof("Starting distribution manager with strategy: %s", distributor.GetType())
+		err := distributor.Start(runCtx)
+		stop()
+
+		dm.mu.RLock()
+		switched := dm.distributor != distributor
+		dm.mu.RUnlock()
+		if !switched || dm.ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// watchTransports re-reads the controller's advertisement periodically
+func (dm *DistributionManager) watchTransports() {
+	ticker := time.NewTicker(dm.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dm.ctx.Done():
+			return
+		case <-ticker.C:
+			offers, err := dm.fetcher.transports(dm.ctx)
+			if err != nil {
+				logger.Log.Warnf("Failed to refresh advertised transports: %v", err)
+				continue
+			}
+			dm.updateTransports(offers)
+		}
+	}
+}
+
+// updateTransports switches to the best offered strategy that can be created,
+// unless the current one is still the best choice
+func (dm *DistributionManager) updateTransports(offers []models.TransportOffer) {
+	dm.mu.RLock()
+	current := dm.current
+	dm.mu.RUnlock()
+
+	for _, c := range rankStrategies(dm.supported, offers) {
+		if c.sameTransport(current) {
+			return
+		}
+
+		distributor, err := dm.newDistributor(c)
+		if err != nil {
+			logger.Log.Warnf("Cannot switch to %s distribution: %v", c.strategy, err)
+			continue
+		}
+
+		logger.Log.Infof("Controller changed its advertised transports, switching from %s to %s", current.strategy, c.strategy)
+		dm.switchTo(c, distributor)
+		return
+	}
+}
+
+// switchTo replaces the running distributor; Start picks up the new one
+func (dm *DistributionManager) switchTo(c candidate, distributor ConfigDistributor) {
+	dm.mu.Lock()
+	previous := dm.distributor
+	dm.current, dm.distributor = c, distributor
+	if dm.stopCurrent != nil {
+		dm.stopCurrent()
+	}
+	dm.mu.Unlock()
+
+	if err := previous.Stop(); err != nil {
+		logger.Log.Warnf("Failed to stop %s distributor: %v", previous.GetType(), err)
+	}
 }
 
 // Stop stops the distribution manager
 func (dm *DistributionManager) Stop() error {
 	logger.Log.Info("Stopping distribution manager")
 	dm.cancel()
-	return dm.distributor.Stop()
+
+	dm.mu.RLock()
+	distributor := dm.distributor
+	dm.mu.RUnlock()
+	return distributor.Stop()
 }
 
 // GetStrategy returns the current distribution strategy
 func (dm *DistributionManager) GetStrategy() DistributionStrategy {
-	return dm.strategy
-}
\ No newline at end of file
+	dm.mu.RLock()
+	defer dm.mu.RUnlock()
+	return dm.current.strategy
+}
+
+// Status describes what the distribution manager is doing
+type Status struct {
+	AgentID     string               `json:"agent_id"`
+	Strategy    DistributionStrategy `json:"strategy"`
+	LastVersion int64                `json:"last_version"`
+	// Connected is nil for strategies without a persistent connection
+	Connected *bool         `json:"connected,omitempty"`
+	Poll      *PollState    `json:"poll,omitempty"`
+	Cache     *cache.Status `json:"cache,omitempty"`
+}
+
+// Status returns the agent ID, the running strategy and the state of its transport
+func (dm *DistributionManager) Status() Status {
+	dm.mu.RLock()
+	status := Status{AgentID: dm.agentID, Strategy: dm.current.strategy}
+	distributor := dm.distributor
+	dm.mu.RUnlock()
+
+	if d, ok := distributor.(interface{ GetLastVersion() int64 }); ok {
+		status.LastVersion = d.GetLastVersion()
+	}
+	if d, ok := distributor.(interface{ IsConnected() bool }); ok {
+		connected := d.IsConnected()
+		status.Connected = &connected
+	}
+	if pd, ok := distributor.(*PollerDistributor); ok {
+		state := pd.poller.State()
+		status.Poll = &state
+	}
+	if dm.cache != nil {
+		cacheStatus := dm.cache.Status()
+		status.Cache = &cacheStatus
+	}
+	return status
+}
+
+// Resync fetches the active configuration from the controller and forwards it
+// to every worker again, including workers that already accepted it. It
+// returns the version applied.
+func (dm *DistributionManager) Resync(ctx context.Context) (int64, error) {
+	dm.mu.RLock()
+	distributor := dm.distributor
+	dm.mu.RUnlock()
+
+	var source configSource
+	if dm.fetcher != nil {
+		source = controllerSource(dm.fetcher)
+	} else if nd, ok := distributor.(*NatsDistributor); ok {
+		source = natsSource(nd.natsClient, nd.authHeader)
+	} else {
+		return 0, fmt.Errorf("no path to the controller to resync from")
+	}
+
+	logger.Log.Info("Resyncing configuration from the controller")
+	dm.workerMgr.ForgetVersions()
+	version := bootstrap(ctx, dm.workerMgr, dm.verifier, dm.fetcher, dm.cache, source)
+	if version == 0 {
+		return 0, fmt.Errorf("no configuration could be fetched and applied")
+	}
+	if d, ok := distributor.(interface{ advance(version int64) }); ok {
+		d.advance(version)
+	}
+	return version, nil
+}
+
+// SetVersion continues from a version the agent already applied, e.g. when
+// this manager replaces one built from older settings. Call it before Start.
+func (dm *DistributionManager) SetVersion(version int64) {
+	if version <= 0 {
+		return
+	}
+	if dm.fetcher != nil {
+		dm.fetcher.setVersion(version)
+	}
+
+	dm.mu.RLock()
+	distributor := dm.distributor
+	dm.mu.RUnlock()
+	if d, ok := distributor.(interface{ advance(version int64) }); ok {
+		d.advance(version)
+	}
+}
+
+// OnUnknownAgent sets the handler called when the controller reports that it
+// does not know the agent, so the agent can register again. Agents that only
+// reach the controller over NATS are not told.
+func (dm *DistributionManager) OnUnknownAgent(handler func()) {
+	if dm.fetcher != nil {
+		dm.fetcher.onUnknownAgent(handler)
+	}
+}
+
+// SetAgentID switches to the agent ID of a new registration. Push transports
+// subscribe and acknowledge under the ID, so their distributor is restarted.
+func (dm *DistributionManager) SetAgentID(agentID string) error {
+	dm.mu.Lock()
+	dm.agentID = agentID
+	dm.natsConfig.AgentID = agentID
+	dm.kafkaConfig.AgentID = agentID
+	current := dm.current
+	dm.mu.Unlock()
+
+	if dm.fetcher != nil {
+		dm.fetcher.setAgentID(agentID)
+	}
+	if current.strategy == StrategyPoller {
+		return nil
+	}
+
+	distributor, err := dm.newDistributor(current)
+	if err != nil {
+		return fmt.Errorf("failed to restart %s distribution: %w", current.strategy, err)
+	}
+	logger.Log.Infof("Restarting %s distribution for agent ID %s", current.strategy, agentID)
+	dm.switchTo(current, distributor)
+	return nil
+}
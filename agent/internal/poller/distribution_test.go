@@ -0,0 +1,231 @@
+package poller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/kafka"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	natspkg "github.com/doniyusdinar/config-management/pkg/nats"
+	"github.com/doniyusdinar/config-management/pkg/redis"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var autoStrategies = []DistributionStrategy{StrategyPoller, StrategyRedis, StrategyNats, StrategyKafka}
+
+func TestRankStrategies(t *testing.T) {
+	offers := []models.TransportOffer{
+		{Strategy: "POLLER"},
+		{Strategy: "REDIS", Priority: 2},
+		{Strategy: "NATS", Priority: 3, Subject: "config.worker.update"},
+		{Strategy: "WEBSOCKET", Priority: 9},
+	}
+
+	strategies := func(candidates []candidate) []DistributionStrategy {
+		var names []DistributionStrategy
+		for _, c := range candidates {
+			names = append(names, c.strategy)
+		}
+		return names
+	}
+
+	ranked := rankStrategies(autoStrategies, offers)
+	assert.Equal(t, []DistributionStrategy{StrategyNats, StrategyRedis, StrategyPoller}, strategies(ranked))
+	assert.Equal(t, "config.worker.update", ranked[0].offer.Subject)
+
+	// An agent restricted to Redis ignores the better NATS offer
+	assert.Equal(t, []DistributionStrategy{StrategyRedis}, strategies(rankStrategies([]DistributionStrategy{StrategyRedis}, offers)))
+
+	// Without a matching offer, e.g. from an older controller, fall back to the first supported strategy
+	fallback := rankStrategies([]DistributionStrategy{StrategyKafka, StrategyPoller}, nil)
+	require.Len(t, fallback, 1)
+	assert.Equal(t, StrategyKafka, fallback[0].strategy)
+	assert.Nil(t, fallback[0].offer)
+
+	// Only a change in how to connect counts as a different transport
+	reprioritized := *ranked[0].offer
+	reprioritized.Priority = 10
+	assert.True(t, ranked[0].sameTransport(candidate{strategy: StrategyNats, offer: &reprioritized}))
+	assert.False(t, ranked[0].sameTransport(ranked[1]))
+}
+
+func TestDistributionManagerSwitchesTransport(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	broker := newMockKafka(t, signer, "config-agent-agent-1", []kafkaTestRecord{
+		{key: "all.all.all", url: "https://kafka.example.com"},
+	})
+
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+
+	var mu sync.Mutex
+	offers := []models.TransportOffer{{Strategy: "POLLER"}}
+	advertise := func(updated ...models.TransportOffer) {
+		mu.Lock()
+		defer mu.Unlock()
+		offers = updated
+	}
+
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/transports" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		json.NewEncoder(w).Encode(offers)
+	}))
+	defer controller.Close()
+
+	dm, err := NewDistributionManager(
+		autoStrategies,
+		offers,
+		20*time.Millisecond,
+		controller.URL, "agent", "secret123",
+		worker.NewManager(workerServer.URL, nil),
+		cache.New(filepath.Join(t.TempDir(), "agent_config.cache"), 3, nil),
+		redis.Config{},
+		natspkg.Config{},
+		kafka.Config{Topic: "unused", Enabled: true},
+		nil,
+		verifier,
+		false,
+		"agent-1",
+	)
+	require.NoError(t, err)
+	assert.Equal(t, StrategyPoller, dm.GetStrategy())
+
+	done := make(chan error, 1)
+	go func() { done <- dm.Start() }()
+
+	advertise(
+		models.TransportOffer{Strategy: "KAFKA", Priority: 2, Endpoints: []string{broker.Addr()}, Topic: testTopic},
+		models.TransportOffer{Strategy: "POLLER", Priority: 1},
+	)
+	require.Eventually(t, func() bool { return dm.GetStrategy() == StrategyKafka }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return len(fw.urls()) > 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "https://kafka.example.com", fw.urls()[0])
+
+	// Withdrawing Kafka falls back to polling
+	advertise(models.TransportOffer{Strategy: "POLLER", Priority: 1})
+	require.Eventually(t, func() bool { return dm.GetStrategy() == StrategyPoller }, 5*time.Second, 10*time.Millisecond)
+
+	dm.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("distribution manager did not stop")
+	}
+}
+
+func TestNatsTargetedMessageReachesGroupWorkers(t *testing.T) {
+	eu, us := &fakeWorker{}, &fakeWorker{}
+	euServer, usServer := httptest.NewServer(eu), httptest.NewServer(us)
+	defer euServer.Close()
+	defer usServer.Close()
+
+	workerMgr := worker.NewMultiManager([]worker.Target{
+		{Name: "eu", URL: euServer.URL, Groups: []string{"eu"}},
+		{Name: "us", URL: usServer.URL, Groups: []string{"us"}},
+	}, nil)
+	store := cache.New(filepath.Join(t.TempDir(), "cache"), 3, nil)
+	nd := &NatsDistributor{workerMgr: workerMgr, cache: store}
+
+	publish := func(subject string, version int64, url string) {
+		envelope, err := models.NewConfigEnvelope(version, models.WorkerConfig{URL: url}, "test-controller")
+		require.NoError(t, err)
+		data, _ := json.Marshal(envelope)
+		nd.handleNatsMessage(&nats.Msg{Subject: subject, Data: data})
+	}
+
+	// A canary for eu does not count as the agent's version
+	publish(natspkg.TargetSubject("", "eu", ""), 2, "https://canary.example.com")
+	assert.Equal(t, []string{"https://canary.example.com"}, eu.urls())
+	assert.Empty(t, us.urls())
+	assert.Equal(t, int64(0), nd.GetLastVersion())
+	assert.Empty(t, store.Status().Entries)
+
+	// The broadcast of the same version only reaches the worker still missing it
+	publish(natspkg.TargetSubject("", "", ""), 2, "https://canary.example.com")
+	assert.Equal(t, []string{"https://canary.example.com"}, eu.urls())
+	assert.Equal(t, []string{"https://canary.example.com"}, us.urls())
+	assert.Equal(t, int64(2), nd.GetLastVersion())
+
+	// Broadcasts are cached without an HTTP fetcher
+	cached, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), cached.Version)
+}
+
+func TestNatsResyncForwardsCurrentVersionAgain(t *testing.T) {
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+
+	nd := &NatsDistributor{workerMgr: worker.NewManager(workerServer.URL, nil)}
+
+	publish := func(subject string, version int64, url string, resync bool) {
+		envelope, err := models.NewConfigEnvelope(version, models.WorkerConfig{URL: url}, "test-controller")
+		require.NoError(t, err)
+		envelope.Resync = resync
+		data, _ := json.Marshal(envelope)
+		nd.handleNatsMessage(&nats.Msg{Subject: subject, Data: data})
+	}
+
+	publish(natspkg.TargetSubject("", "", ""), 2, "https://ip.me", false)
+	assert.Equal(t, []string{"https://ip.me"}, fw.urls())
+
+	// A resync aimed at this agent reaches the worker that already runs the version
+	publish(natspkg.TargetSubject("prod", "", "agent-1"), 2, "https://ip.me", true)
+	assert.Equal(t, []string{"https://ip.me", "https://ip.me"}, fw.urls())
+	assert.Equal(t, int64(2), nd.GetLastVersion())
+
+	// A resync never rolls the agent back to an older version
+	publish(natspkg.TargetSubject("prod", "", "agent-1"), 1, "https://old.example.com", true)
+	assert.Equal(t, []string{"https://ip.me", "https://ip.me"}, fw.urls())
+	assert.Equal(t, int64(2), nd.GetLastVersion())
+}
+
+func TestNatsStatusReadableWhileForwarding(t *testing.T) {
+	forwarding, release := make(chan struct{}), make(chan struct{})
+	workerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(forwarding)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer workerServer.Close()
+
+	nd := &NatsDistributor{workerMgr: worker.NewManager(workerServer.URL, nil)}
+	envelope, err := models.NewConfigEnvelope(3, models.WorkerConfig{URL: "https://ip.me"}, "test-controller")
+	require.NoError(t, err)
+	data, _ := json.Marshal(envelope)
+
+	done := make(chan struct{})
+	go func() {
+		nd.handleNatsMessage(&nats.Msg{Data: data})
+		close(done)
+	}()
+
+	<-forwarding
+	read := make(chan int64)
+	go func() { read <- nd.GetLastVersion() }()
+	select {
+	case version := <-read:
+		assert.Equal(t, int64(3), version)
+	case <-time.After(time.Second):
+		t.Fatal("status blocked while forwarding")
+	}
+
+	close(release)
+	<-done
+}
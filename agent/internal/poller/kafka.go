@@ -0,0 +1,169 @@
+package poller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/kafka"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/signing"
+)
+
+// KafkaDistributor implements the Kafka strategy: it applies the latest
+// compacted value for this agent on start, then follows new records
+type KafkaDistributor struct {
+	consumer    *kafka.Consumer
+	config      kafka.Config
+	workerMgr   *worker.Manager
+	verifier    *signing.Verifier
+	fetcher     *Poller // fetches configs with secrets over HTTP
+	cache       *cache.Cache
+	mu          sync.RWMutex
+	lastConfig  *models.WorkerConfig
+	lastVersion int64
+}
+
+func NewKafkaDistributor(kafkaConfig kafka.Config, workerMgr *worker.Manager, verifier *signing.Verifier, fetcher *Poller, store *cache.Cache) (*KafkaDistributor, error) {
+	// Each agent commits its own offsets, so every agent sees every record
+	group := "config-agent"
+	if kafkaConfig.AgentID != "" {
+		group += "-" + kafkaConfig.AgentID
+	}
+
+	consumer, err := kafka.NewConsumer(kafkaConfig, group)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KafkaDistributor{
+		consumer:  consumer,
+		config:    kafkaConfig,
+		workerMgr: workerMgr,
+		verifier:  verifier,
+		fetcher:   fetcher,
+		cache:     store,
+	}, nil
+}
+
+func (kd *KafkaDistributor) Start(ctx context.Context) error {
+	logger.Log.Info("Starting Kafka distribution strategy")
+
+	kd.mu.Lock()
+	sources := startupSources(kd.cache, kd.fetcher, kafkaSource(kd.consumer, kd.matches))
+	if version := bootstrap(ctx, kd.workerMgr, kd.verifier, kd.fetcher, kd.cache, sources...); version > 0 {
+		kd.lastVersion = version
+	}
+	kd.mu.Unlock()
+
+	err := kd.consumer.Follow(ctx, kd.handleRecord)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("failed to follow Kafka topic: %w", err)
+}
+
+// kafkaSource reads the latest compacted value addressed to this agent
+func kafkaSource(consumer *kafka.Consumer, match func(key string) bool) configSource {
+	return configSource{name: "kafka", fetch: func(ctx context.Context) (*models.ConfigResponse, error) {
+		record, err := consumer.Latest(ctx, match)
+		if err != nil || record == nil {
+			return nil, err
+		}
+		return envelopeResponse(record.Envelope), nil
+	}}
+}
+
+func (kd *KafkaDistributor) matches(key string) bool {
+	return kafka.MatchesTarget(key, kd.config.Environment, kd.config.Groups, kd.config.AgentID)
+}
+
+// handleRecord forwards a new version to the worker. Only a failed forward
+// returns an error, which leaves the record's offset uncommitted.
+func (kd *KafkaDistributor) handleRecord(record *kafka.Record) error {
+	if !kd.matches(record.Key) {
+		return nil
+	}
+
+	envelope := record.Envelope
+	if err := kd.verifier.Verify(signing.EnvelopePayload(envelope), envelope.Signature); err != nil {
+		logger.Log.Errorf("Rejected config from Kafka: version %d: %v", envelope.Version, err)
+		return nil
+	}
+
+	kd.mu.Lock()
+	// The log is replayed from the committed offset; only move forward. Records
+	// keyed to a group only reach that group's workers, which skip versions
+	// they already run.
+	group := kafka.TargetGroup(record.Key)
+	if envelope.Resync {
+		// A resync re-applies the current version but never an older one
+		if envelope.Version < kd.lastVersion {
+			kd.mu.Unlock()
+			return nil
+		}
+		forgetApplied(kd.workerMgr, kd.fetcher)
+	} else if group == "" && envelope.Version <= kd.lastVersion {
+		kd.mu.Unlock()
+		return nil
+	}
+	// Forwarding can take a while; status reads must not wait for it
+	kd.mu.Unlock()
+
+	logger.Log.Infof("Received config from Kafka: version %d (origin %s, key %s)", envelope.Version, envelope.Origin, record.Key)
+
+	results, err := forwardPushed(context.Background(), kd.workerMgr, kd.fetcher, envelope, group)
+	if len(results) > 0 || err != nil {
+		kd.fetcher.report(newAck(kd.config.AgentID, envelope.Version, envelope.Timestamp, results, err))
+	}
+	if err != nil {
+		logger.Log.Errorf("Failed to forward Kafka config to workers: %v", err)
+		return err
+	}
+
+	if group == "" {
+		kd.mu.Lock()
+		// Records are handled one at a time, but a resync may have advanced meanwhile
+		if envelope.Version >= kd.lastVersion {
+			kd.lastConfig = &envelope.Config
+			kd.lastVersion = envelope.Version
+		}
+		kd.mu.Unlock()
+		cachePushed(kd.cache, envelope)
+	}
+	logger.Log.Info("Successfully forwarded Kafka config to workers")
+	return nil
+}
+
+func (kd *KafkaDistributor) Stop() error {
+	logger.Log.Info("Stopping Kafka distributor")
+	return kd.consumer.Close()
+}
+
+func (kd *KafkaDistributor) GetType() DistributionStrategy {
+	return StrategyKafka
+}
+
+func (kd *KafkaDistributor) GetLastConfig() *models.WorkerConfig {
+	kd.mu.RLock()
+	defer kd.mu.RUnlock()
+	return kd.lastConfig
+}
+
+// advance records a version applied outside the consumer, e.g. by a resync
+func (kd *KafkaDistributor) advance(version int64) {
+	kd.mu.Lock()
+	defer kd.mu.Unlock()
+	if version > kd.lastVersion {
+		kd.lastVersion = version
+	}
+}
+
+func (kd *KafkaDistributor) GetLastVersion() int64 {
+	kd.mu.RLock()
+	defer kd.mu.RUnlock()
+	return kd.lastVersion
+}
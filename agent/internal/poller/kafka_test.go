@@ -0,0 +1,291 @@
+package poller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/kafka"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTopic = "config-updates"
+
+type kafkaTestRecord struct {
+	key string
+	url string
+}
+
+// newMockKafka serves records on partition 0 of testTopic from a protocol-level fake broker
+func newMockKafka(t *testing.T, signer *signing.Signer, group string, records []kafkaTestRecord) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	t.Cleanup(broker.Close)
+
+	fetch := sarama.NewMockFetchResponse(t, len(records))
+	for i, record := range records {
+		data, err := json.Marshal(signedEnvelope(t, signer, int64(i+1), models.WorkerConfig{URL: record.url}))
+		require.NoError(t, err)
+		fetch.SetMessageWithKey(testTopic, 0, int64(i), sarama.StringEncoder(record.key), sarama.ByteEncoder(data))
+	}
+	fetch.SetHighWaterMark(testTopic, 0, int64(len(records)))
+
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader(testTopic, 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset(testTopic, 0, sarama.OffsetOldest, 0).
+			SetOffset(testTopic, 0, sarama.OffsetNewest, int64(len(records))),
+		"FetchRequest": fetch,
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, group, broker),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset(group, testTopic, 0, -1, "", sarama.ErrNoError),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t),
+	})
+
+	return broker
+}
+
+func newTestKafkaDistributor(t *testing.T, broker *sarama.MockBroker, workerURL string, verifier *signing.Verifier) *KafkaDistributor {
+	kd, err := NewKafkaDistributor(kafka.Config{
+		Brokers:     []string{broker.Addr()},
+		Topic:       testTopic,
+		Enabled:     true,
+		Environment: "prod",
+		Groups:      []string{"eu"},
+		AgentID:     "agent-1",
+	}, worker.NewManager(workerURL, nil), verifier, nil, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { kd.Stop() })
+	return kd
+}
+
+func commitCount(broker *sarama.MockBroker) int {
+	count := 0
+	for _, rr := range broker.History() {
+		if _, ok := rr.Request.(*sarama.OffsetCommitRequest); ok {
+			count++
+		}
+	}
+	return count
+}
+
+func TestKafkaLatestCompactedValueForAgent(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	broker := newMockKafka(t, signer, "config-agent-agent-1", []kafkaTestRecord{
+		{key: "all.all.all", url: "https://v1.example.com"},
+		{key: "prod.eu.all", url: "https://v2.example.com"},
+		{key: "staging.all.all", url: "https://v3.example.com"},
+		{key: "all.all.agent-2", url: "https://v4.example.com"},
+	})
+
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+
+	kd := newTestKafkaDistributor(t, broker, workerServer.URL, verifier)
+	version := bootstrap(context.Background(), kd.workerMgr, verifier, nil, nil, kafkaSource(kd.consumer, kd.matches))
+
+	assert.Equal(t, int64(2), version)
+	assert.Equal(t, []string{"https://v2.example.com"}, fw.urls())
+}
+
+func TestKafkaCommitsOnlyAfterForward(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	broker := newMockKafka(t, signer, "config-agent-agent-1", []kafkaTestRecord{
+		{key: "all.all.all", url: "https://ok.example.com"},
+		{key: "all.all.all", url: "https://fail.example.com"},
+		{key: "all.all.all", url: "https://later.example.com"},
+	})
+
+	var mu sync.Mutex
+	var forwarded []string
+	workerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var config models.WorkerConfig
+		json.NewDecoder(r.Body).Decode(&config)
+		mu.Lock()
+		forwarded = append(forwarded, config.URL)
+		mu.Unlock()
+		if config.URL == "https://fail.example.com" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer workerServer.Close()
+
+	kd := newTestKafkaDistributor(t, broker, workerServer.URL, verifier)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handled := make(chan error, 3)
+	go kd.consumer.Follow(ctx, func(record *kafka.Record) error {
+		err := kd.handleRecord(record)
+		handled <- err
+		return err
+	})
+
+	var results []error
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-handled:
+			results = append(results, err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for Kafka records")
+		}
+	}
+	cancel()
+
+	assert.NoError(t, results[0])
+	assert.Error(t, results[1])
+	assert.NoError(t, results[2])
+
+	// Only the first record is committed; a restart retries from the failed one
+	assert.Equal(t, 1, commitCount(broker))
+	assert.Equal(t, int64(3), kd.GetLastVersion())
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, forwarded, "https://fail.example.com")
+}
+
+// committedOffset returns the newest offset committed for partition 0 of testTopic, or -1
+func committedOffset(broker *sarama.MockBroker) int64 {
+	offset := int64(-1)
+	for _, rr := range broker.History() {
+		if req, ok := rr.Request.(*sarama.OffsetCommitRequest); ok {
+			if committed, _, err := req.Offset(testTopic, 0); err == nil {
+				offset = committed
+			}
+		}
+	}
+	return offset
+}
+
+func TestKafkaRetriesFailedRecord(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	broker := newMockKafka(t, signer, "config-agent-agent-1", []kafkaTestRecord{
+		{key: "prod.eu.all", url: "https://canary.example.com"},
+		{key: "prod.us.all", url: "https://other.example.com"},
+	})
+	kd := newTestKafkaDistributor(t, broker, "http://127.0.0.1:0", verifier)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handled := make(chan int64, 4)
+	failures := 0
+	go kd.consumer.Follow(ctx, func(record *kafka.Record) error {
+		handled <- record.Offset
+		if record.Offset == 0 && failures == 0 {
+			failures++
+			return errors.New("worker unavailable")
+		}
+		return nil
+	})
+
+	var offsets []int64
+	for i := 0; i < 3; i++ {
+		select {
+		case offset := <-handled:
+			offsets = append(offsets, offset)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for Kafka records")
+		}
+	}
+
+	// The later record is delivered meanwhile, and the failed one retried in process
+	assert.Equal(t, []int64{0, 1, 0}, offsets)
+
+	// Once the retry succeeds, commits resume past both records
+	assert.Eventually(t, func() bool { return committedOffset(broker) == 2 }, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestKafkaResyncForwardsCurrentVersionAgain(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+
+	kd := &KafkaDistributor{
+		config:    kafka.Config{Environment: "prod", AgentID: "agent-1"},
+		workerMgr: worker.NewManager(workerServer.URL, nil),
+		verifier:  verifier,
+	}
+
+	handle := func(key string, version int64, url string, resync bool) {
+		envelope := signedEnvelope(t, signer, version, models.WorkerConfig{URL: url})
+		envelope.Resync = resync
+		var err error
+		envelope.Signature, err = signer.Sign(signing.EnvelopePayload(envelope))
+		require.NoError(t, err)
+		require.NoError(t, kd.handleRecord(&kafka.Record{Key: key, Envelope: envelope}))
+	}
+
+	handle(kafka.TargetKey("", "", ""), 2, "https://ip.me", false)
+	handle(kafka.TargetKey("", "", ""), 2, "https://ip.me", false)
+	assert.Equal(t, []string{"https://ip.me"}, fw.urls())
+
+	// The resync flag is signed, so a replayed envelope cannot be marked as one
+	replayed := signedEnvelope(t, signer, 2, models.WorkerConfig{URL: "https://ip.me"})
+	replayed.Resync = true
+	require.NoError(t, kd.handleRecord(&kafka.Record{Key: kafka.TargetKey("prod", "", "agent-1"), Envelope: replayed}))
+	assert.Equal(t, []string{"https://ip.me"}, fw.urls())
+
+	// A resync produced for this agent reaches the worker that already runs the version
+	handle(kafka.TargetKey("prod", "", "agent-1"), 2, "https://ip.me", true)
+	assert.Equal(t, []string{"https://ip.me", "https://ip.me"}, fw.urls())
+
+	// A resync never rolls the agent back to an older version
+	handle(kafka.TargetKey("prod", "", "agent-1"), 1, "https://old.example.com", true)
+	assert.Equal(t, []string{"https://ip.me", "https://ip.me"}, fw.urls())
+}
+
+func TestKafkaStatusReadableWhileForwarding(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	forwarding, release := make(chan struct{}), make(chan struct{})
+	workerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(forwarding)
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer workerServer.Close()
+
+	kd := &KafkaDistributor{
+		config:      kafka.Config{Environment: "prod", AgentID: "agent-1"},
+		workerMgr:   worker.NewManager(workerServer.URL, nil),
+		verifier:    verifier,
+		lastVersion: 2,
+	}
+	envelope := signedEnvelope(t, signer, 3, models.WorkerConfig{URL: "https://ip.me"})
+
+	done := make(chan error)
+	go func() {
+		done <- kd.handleRecord(&kafka.Record{Key: kafka.TargetKey("", "", ""), Envelope: envelope})
+	}()
+
+	<-forwarding
+	read := make(chan int64)
+	go func() { read <- kd.GetLastVersion() }()
+	select {
+	case version := <-read:
+		// Kafka only counts a version once it was forwarded, so it can be committed
+		assert.Equal(t, int64(2), version)
+	case <-time.After(time.Second):
+		t.Fatal("status blocked while forwarding")
+	}
+
+	close(release)
+	require.NoError(t, <-done)
+	assert.Equal(t, int64(3), kd.GetLastVersion())
+}
@@ -1,43 +1,75 @@
 package poller
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/doniyusdinar/config-management/agent/internal/backoff"
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
 	"github.com/doniyusdinar/config-management/agent/internal/worker"
 	"github.com/doniyusdinar/config-management/pkg/auth"
 	"github.com/doniyusdinar/config-management/pkg/logger"
 	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/signing"
 )
 
+// ErrUnknownAgent is returned when the controller has no registration for the
+// agent, e.g. after its database was reset
+var ErrUnknownAgent = errors.New("controller does not know this agent")
+
+// PollState is the outcome of the poller's recent requests to the controller
+type PollState struct {
+	LastPollAt    *time.Time `json:"last_poll_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+	Failures      int        `json:"consecutive_failures"`
+	NextRetryAt   *time.Time `json:"next_retry_at,omitempty"` // set while backing off
+}
+
 type Poller struct {
 	controllerURL string
 	authHeader    string
 	client        *http.Client
 	workerMgr     *worker.Manager
 	backoff       *backoff.Backoff
-	cacheFile     string
+	cache         *cache.Cache
+	verifier      *signing.Verifier
+
+	mu             sync.RWMutex
+	agentID        string // acknowledges applied versions when set
+	currentVersion int64
+	state          PollState
+	unknownAgent   func() // called when the controller does not know agentID
 
-	currentVersion   int64
 	pollInterval     time.Duration
 	updateIntervalCh chan time.Duration
 }
 
-func NewPoller(controllerURL, username, password string, workerMgr *worker.Manager, cacheFile string) *Poller {
+func NewPoller(controllerURL, username, password string, workerMgr *worker.Manager, store *cache.Cache, tlsConfig *tls.Config, verifier *signing.Verifier) *Poller {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+
 	return &Poller{
 		controllerURL:    controllerURL,
 		authHeader:       auth.CreateBasicAuthHeader(username, password),
-		client:           &http.Client{Timeout: 10 * time.Second},
+		client:           client,
 		workerMgr:        workerMgr,
 		backoff:          backoff.New(1*time.Second, 5*time.Minute, 2.0),
-		cacheFile:        cacheFile,
+		cache:            store,
+		verifier:         verifier,
 		pollInterval:     30 * time.Second, // Default, will be updated by controller
 		updateIntervalCh: make(chan time.Duration, 1),
 	}
@@ -68,6 +100,7 @@ func (p *Poller) Start(ctx context.Context) error {
 				logger.Log.Errorf("Poll failed: %v", err)
 
 				backoffDuration := p.backoff.Next()
+				p.recordPoll(err, backoffDuration)
 				logger.Log.Infof("Retrying in %v", backoffDuration)
 
 				select {
@@ -77,6 +110,7 @@ func (p *Poller) Start(ctx context.Context) error {
 				}
 			} else {
 				p.backoff.Reset()
+				p.recordPoll(nil, 0)
 			}
 		}
 	}
@@ -84,60 +118,151 @@ func (p *Poller) Start(ctx context.Context) error {
 
 // poll fetches configuration from controller
 func (p *Poller) poll(ctx context.Context) error {
+	currentVersion := p.version()
+	configResp, err := p.fetch(ctx, currentVersion)
+	if err != nil {
+		return err
+	}
+	if configResp == nil {
+		logger.Log.Debug("Configuration unchanged")
+		return nil
+	}
+
+	if configResp.Version != currentVersion {
+		if err := p.apply(*configResp); err != nil {
+			return err
+		}
+	}
+
+	if configResp.PollIntervalSecs > 0 {
+		newInterval := time.Duration(configResp.PollIntervalSecs) * time.Second
+		if newInterval != p.pollInterval {
+			select {
+			case p.updateIntervalCh <- newInterval:
+			default:
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetch requests the active configuration from the controller. It returns nil
+// when the controller reports that knownVersion is still current.
+func (p *Poller) fetch(ctx context.Context, knownVersion int64) (*models.ConfigResponse, error) {
 	url := fmt.Sprintf("%s/api/v1/config", p.controllerURL)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", p.authHeader)
-	if p.currentVersion > 0 {
-		req.Header.Set("If-None-Match", strconv.FormatInt(p.currentVersion, 10))
+	if agentID := p.currentAgentID(); agentID != "" {
+		req.Header.Set(models.AgentIDHeader, agentID)
+	}
+	if knownVersion > 0 {
+		req.Header.Set("If-None-Match", strconv.FormatInt(knownVersion, 10))
 	}
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to fetch config: %w", err)
+		return nil, fmt.Errorf("failed to fetch config: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotModified {
-		logger.Log.Debug("Configuration unchanged")
-		return nil
+		return nil, nil
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("controller returned status %d: %s", resp.StatusCode, string(body))
+		return nil, p.statusError(resp.StatusCode, body)
 	}
 
 	var configResp models.ConfigResponse
 	if err := json.NewDecoder(resp.Body).Decode(&configResp); err != nil {
-		return fmt.Errorf("failed to decode config: %w", err)
+		return nil, fmt.Errorf("failed to decode config: %w", err)
 	}
+	return &configResp, nil
+}
 
-	if configResp.Version != p.currentVersion {
-		logger.Log.Infof("Configuration changed: version %d -> %d", p.currentVersion, configResp.Version)
-		p.currentVersion = configResp.Version
+// transports requests the distribution strategies the controller advertises
+func (p *Poller) transports(ctx context.Context) ([]models.TransportOffer, error) {
+	url := fmt.Sprintf("%s/api/v1/transports", p.controllerURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", p.authHeader)
+	if agentID := p.currentAgentID(); agentID != "" {
+		req.Header.Set(models.AgentIDHeader, agentID)
+	}
 
-		if err := p.workerMgr.ForwardConfig(configResp.Data); err != nil {
-			logger.Log.Errorf("Failed to forward config to worker: %v", err)
-			return err
-		}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch transports: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, p.statusError(resp.StatusCode, body)
+	}
+
+	var offers []models.TransportOffer
+	if err := json.NewDecoder(resp.Body).Decode(&offers); err != nil {
+		return nil, fmt.Errorf("failed to decode transports: %w", err)
+	}
+	return offers, nil
+}
 
-		if err := p.saveCache(configResp); err != nil {
-			logger.Log.Warnf("Failed to save cache: %v", err)
+// statusError describes an error reply from the controller. A reply that the
+// agent is unknown is passed on to the handler set with onUnknownAgent.
+func (p *Poller) statusError(status int, body []byte) error {
+	var reply struct {
+		Error string `json:"error"`
+	}
+	if status == http.StatusNotFound && json.Unmarshal(body, &reply) == nil && reply.Error == models.UnknownAgentError {
+		p.mu.RLock()
+		handler := p.unknownAgent
+		p.mu.RUnlock()
+		if handler != nil {
+			go handler()
 		}
+		return ErrUnknownAgent
 	}
+	return fmt.Errorf("controller returned status %d: %s", status, string(body))
+}
 
-	if configResp.PollIntervalSecs > 0 {
-		newInterval := time.Duration(configResp.PollIntervalSecs) * time.Second
-		if newInterval != p.pollInterval {
-			select {
-			case p.updateIntervalCh <- newInterval:
-			default:
-			}
+// apply verifies a configuration, forwards it to the workers and caches it
+func (p *Poller) apply(configResp models.ConfigResponse) error {
+	if err := p.verifier.Verify(signing.ResponsePayload(&configResp), configResp.Signature); err != nil {
+		return fmt.Errorf("rejected config version %d: %w", configResp.Version, err)
+	}
+
+	p.mu.Lock()
+	logger.Log.Infof("Configuration changed: version %d -> %d", p.currentVersion, configResp.Version)
+	p.currentVersion = configResp.Version
+	agentID := p.agentID
+	p.mu.Unlock()
+
+	results, err := p.workerMgr.Forward(configResp.Version, configResp.Data, "")
+	if len(results) > 0 || err != nil {
+		p.report(newAck(agentID, configResp.Version, time.Time{}, results, err))
+	}
+	if err != nil {
+		// The worker manager keeps retrying the version, so it still becomes
+		// the agent's current configuration
+		logger.Log.Errorf("Failed to forward config to workers, delivery queued: %v", err)
+	}
+
+	if configResp.Data.HasSecrets() {
+		// Never persist secret values; drop older versions so they are not replayed
+		if err := p.cache.Clear(); err != nil {
+			logger.Log.Warnf("Failed to clear cache: %v", err)
 		}
+	} else if err := p.cache.Save(configResp); err != nil {
+		logger.Log.Warnf("Failed to save cache: %v", err)
 	}
 
 	return nil
@@ -145,19 +270,21 @@ func (p *Poller) poll(ctx context.Context) error {
 
 // loadCache loads configuration from cache file
 func (p *Poller) loadCache() error {
-	data, err := os.ReadFile(p.cacheFile)
+	configResp, err := p.cache.Load()
 	if err != nil {
 		return err
 	}
 
-	var configResp models.ConfigResponse
-	if err := json.Unmarshal(data, &configResp); err != nil {
-		return err
+	p.mu.Lock()
+	if configResp.Version <= p.currentVersion {
+		// Already applied, e.g. by the distribution manager this one replaced
+		p.mu.Unlock()
+		return nil
 	}
-
 	p.currentVersion = configResp.Version
+	p.mu.Unlock()
 
-	if err := p.workerMgr.ForwardConfig(configResp.Data); err != nil {
+	if _, err := p.workerMgr.Forward(configResp.Version, configResp.Data, ""); err != nil {
 		return err
 	}
 
@@ -165,14 +292,100 @@ func (p *Poller) loadCache() error {
 	return nil
 }
 
-// saveCache saves configuration to cache file
-func (p *Poller) saveCache(config models.ConfigResponse) error {
-	data, err := json.Marshal(config)
+// report sends an acknowledgement to the controller over HTTP, for agents
+// without a push transport to acknowledge on. Failures are only logged.
+func (p *Poller) report(ack models.ConfigAck) {
+	if p == nil || ack.AgentID == "" {
+		return
+	}
+
+	data, err := json.Marshal(ack)
 	if err != nil {
-		return err
+		logger.Log.Warnf("Failed to marshal ack: %v", err)
+		return
+	}
+
+	url := fmt.Sprintf("%s/api/v1/acks", p.controllerURL)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	if err != nil {
+		logger.Log.Warnf("Failed to create ack request: %v", err)
+		return
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", p.authHeader)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		logger.Log.Warnf("Failed to send ack for config version %d: %v", ack.Version, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		logger.Log.Warnf("Controller rejected ack for config version %d: status %d", ack.Version, resp.StatusCode)
+	}
+}
+
+// version returns the configuration version the poller applied last
+func (p *Poller) version() int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.currentVersion
+}
+
+// setVersion records a version applied before the poller started
+func (p *Poller) setVersion(version int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.currentVersion = version
+}
+
+// setAgentID changes the agent ID acknowledgements are sent for
+func (p *Poller) setAgentID(agentID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.agentID = agentID
+}
+
+// onUnknownAgent sets the handler called, in its own goroutine, when the
+// controller reports that it does not know the agent
+func (p *Poller) onUnknownAgent(handler func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unknownAgent = handler
+}
+
+// currentAgentID returns the agent ID acknowledgements are sent for
+func (p *Poller) currentAgentID() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.agentID
+}
+
+// recordPoll records the outcome of a poll; a failed one is retried after retryIn
+func (p *Poller) recordPoll(err error, retryIn time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	p.state.LastPollAt = &now
+	p.state.NextRetryAt = nil
+	if err == nil {
+		p.state.LastSuccessAt = &now
+		p.state.LastError = ""
+		p.state.Failures = 0
+		return
+	}
+	next := now.Add(retryIn)
+	p.state.LastError = err.Error()
+	p.state.Failures++
+	p.state.NextRetryAt = &next
+}
 
-	return os.WriteFile(p.cacheFile, data, 0644)
+// State returns the outcome of the poller's recent requests to the controller
+func (p *Poller) State() PollState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.state
 }
 
 // SetPollingInterval updates the polling interval dynamically
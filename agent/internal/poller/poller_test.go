@@ -0,0 +1,64 @@
+package poller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnknownAgentRegistersAgain(t *testing.T) {
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+
+	// The controller lost agent-1, e.g. its database was reset
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(models.AgentIDHeader) != "agent-2" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": models.UnknownAgentError})
+			return
+		}
+		json.NewEncoder(w).Encode(models.ConfigResponse{Version: 1, Data: models.WorkerConfig{URL: "https://ip.me"}})
+	}))
+	defer controller.Close()
+
+	p := NewPoller(controller.URL, "agent", "secret", worker.NewManager(workerServer.URL, nil), nil, nil, nil)
+	p.setAgentID("agent-1")
+	registered := make(chan struct{}, 1)
+	p.onUnknownAgent(func() {
+		p.setAgentID("agent-2")
+		registered <- struct{}{}
+	})
+
+	assert.ErrorIs(t, p.poll(context.Background()), ErrUnknownAgent)
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("unknown agent handler not called")
+	}
+
+	require.NoError(t, p.poll(context.Background()))
+	assert.Equal(t, []string{"https://ip.me"}, fw.urls())
+}
+
+func TestNotFoundIsNotUnknownAgent(t *testing.T) {
+	// Older controllers without the transports endpoint
+	controller := httptest.NewServer(http.NotFoundHandler())
+	defer controller.Close()
+
+	p := NewPoller(controller.URL, "agent", "secret", worker.NewManager("http://worker", nil), nil, nil, nil)
+	p.setAgentID("agent-1")
+	p.onUnknownAgent(func() { t.Error("unknown agent handler called") })
+
+	_, err := p.transports(context.Background())
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnknownAgent)
+}
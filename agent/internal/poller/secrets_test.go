@@ -0,0 +1,86 @@
+package poller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/signing"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPushedSecretsFetchedFromController(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+
+	var forwarded []models.WorkerConfig
+	workerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var config models.WorkerConfig
+		json.NewDecoder(r.Body).Decode(&config)
+		forwarded = append(forwarded, config)
+	}))
+	defer workerServer.Close()
+	workerMgr := worker.NewManager(workerServer.URL, nil)
+
+	full := models.WorkerConfig{
+		URL:           "https://api.example.com",
+		SecretHeaders: map[string]models.Secret{"Authorization": "Bearer s3cret"},
+	}
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, ok := r.BasicAuth()
+		require.True(t, ok)
+		assert.Equal(t, "agent", user)
+		resp := models.ConfigResponse{Version: 2, Data: full}
+		resp.Signature, _ = signer.Sign(signing.ResponsePayload(&resp))
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer controller.Close()
+
+	store := cache.New(filepath.Join(t.TempDir(), "cache"), 3, verifier)
+	fetcher := NewPoller(controller.URL, "agent", "secret", workerMgr, store, nil, verifier)
+	nd := &NatsDistributor{workerMgr: workerMgr, verifier: verifier, fetcher: fetcher, cache: store, ctx: context.Background()}
+
+	data, err := json.Marshal(signedEnvelope(t, signer, 2, full.Redacted()))
+	require.NoError(t, err)
+	nd.handleNatsMessage(&nats.Msg{Data: data})
+
+	require.Len(t, forwarded, 1)
+	assert.Equal(t, models.Secret("Bearer s3cret"), forwarded[0].SecretHeaders["Authorization"])
+	assert.Empty(t, store.Status().Entries, "configs with secrets must not be cached")
+}
+
+func TestPushedPlainHeadersForwardedAndCached(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+
+	var forwarded []models.WorkerConfig
+	workerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var config models.WorkerConfig
+		json.NewDecoder(r.Body).Decode(&config)
+		forwarded = append(forwarded, config)
+	}))
+	defer workerServer.Close()
+
+	// No fetcher: plain headers are not secrets, so the push is applied as is
+	store := cache.New(filepath.Join(t.TempDir(), "cache"), 3, verifier)
+	nd := &NatsDistributor{workerMgr: worker.NewManager(workerServer.URL, nil), verifier: verifier, cache: store, ctx: context.Background()}
+
+	config := models.WorkerConfig{URL: "https://api.example.com", Headers: map[string]string{"Accept": "application/json"}}
+	envelope := signedEnvelope(t, signer, 2, config.Redacted())
+	assert.False(t, envelope.Config.IsRedacted())
+	data, err := json.Marshal(envelope)
+	require.NoError(t, err)
+	nd.handleNatsMessage(&nats.Msg{Data: data})
+
+	require.Len(t, forwarded, 1)
+	assert.Equal(t, config, forwarded[0])
+	cached, err := store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, config, cached.Data)
+}
@@ -0,0 +1,243 @@
+package poller
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/doniyusdinar/config-management/agent/internal/cache"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/signing"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWorker records the configs forwarded to it
+type fakeWorker struct {
+	mu       sync.Mutex
+	received []string
+}
+
+func (f *fakeWorker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var config models.WorkerConfig
+	json.NewDecoder(r.Body).Decode(&config)
+	f.mu.Lock()
+	f.received = append(f.received, config.URL)
+	f.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (f *fakeWorker) urls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.received...)
+}
+
+func newTestSigner(t *testing.T) (*signing.Signer, *signing.Verifier) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return signing.NewSigner(private), signing.NewVerifier(public)
+}
+
+func signedResponse(t *testing.T, signer *signing.Signer, version int64, url string) models.ConfigResponse {
+	resp := models.ConfigResponse{Version: version, Data: models.WorkerConfig{URL: url}}
+	sig, err := signer.Sign(signing.ResponsePayload(&resp))
+	require.NoError(t, err)
+	resp.Signature = sig
+	return resp
+}
+
+func TestPollRejectsTamperedConfig(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+
+	var response models.ConfigResponse
+	controller := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer controller.Close()
+
+	store := cache.New(filepath.Join(t.TempDir(), "cache"), 3, verifier)
+	p := NewPoller(controller.URL, "agent", "secret", worker.NewManager(workerServer.URL, nil), store, nil, verifier)
+
+	response = signedResponse(t, signer, 1, "https://ip.me")
+	require.NoError(t, p.poll(context.Background()))
+
+	// Same signature, different URL
+	response.Version = 2
+	response.Data.URL = "https://evil.example.com"
+	assert.ErrorIs(t, p.poll(context.Background()), signing.ErrInvalidSignature)
+
+	response = models.ConfigResponse{Version: 3, Data: models.WorkerConfig{URL: "https://evil.example.com"}}
+	assert.ErrorIs(t, p.poll(context.Background()), signing.ErrUnsigned)
+
+	assert.Equal(t, []string{"https://ip.me"}, fw.urls())
+	assert.Equal(t, int64(1), p.currentVersion)
+}
+
+func TestLoadCacheVerifiesSignature(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+	workerMgr := worker.NewManager(workerServer.URL, nil)
+
+	cacheFile := filepath.Join(t.TempDir(), "cache")
+	store := cache.New(cacheFile, 3, verifier)
+	p := NewPoller("http://unused", "agent", "secret", workerMgr, store, nil, verifier)
+
+	require.NoError(t, store.Save(signedResponse(t, signer, 4, "https://ip.me")))
+	require.NoError(t, p.loadCache())
+
+	// An edited newer version must not reach the worker; the previous one is used
+	tampered := signedResponse(t, signer, 5, "https://ip.me")
+	tampered.Data.URL = "https://evil.example.com"
+	require.NoError(t, store.Save(tampered))
+
+	p = NewPoller("http://unused", "agent", "secret", workerMgr, store, nil, verifier)
+	require.NoError(t, p.loadCache())
+	assert.Equal(t, int64(4), p.currentVersion)
+	assert.Equal(t, []string{"https://ip.me"}, fw.urls())
+
+	// Neither does an edited cache file of an older agent
+	require.NoError(t, store.Clear())
+	data, err := json.Marshal(tampered)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(cacheFile, data, 0644))
+
+	p = NewPoller("http://unused", "agent", "secret", workerMgr, store, nil, verifier)
+	assert.ErrorIs(t, p.loadCache(), cache.ErrEmpty)
+	assert.Equal(t, int64(0), p.currentVersion)
+	assert.Equal(t, []string{"https://ip.me"}, fw.urls())
+}
+
+// signedEnvelope builds a push message as published by the controller
+func signedEnvelope(t *testing.T, signer *signing.Signer, version int64, config models.WorkerConfig) *models.ConfigEnvelope {
+	envelope, err := models.NewConfigEnvelope(version, config, "test-controller")
+	require.NoError(t, err)
+	envelope.Signature, err = signer.Sign(signing.EnvelopePayload(envelope))
+	require.NoError(t, err)
+	return envelope
+}
+
+func TestPushDistributorsVerifySignature(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+	workerMgr := worker.NewManager(workerServer.URL, nil)
+
+	valid := signedEnvelope(t, signer, 7, models.WorkerConfig{URL: "https://ip.me"})
+
+	// Re-signed content hash, but the signature still covers the original config
+	forged := *valid
+	forged.Version = 8
+	forged.Config = models.WorkerConfig{URL: "https://evil.example.com"}
+	forged.ContentHash, _ = models.ContentHash(forged.Config)
+
+	t.Run("NATS", func(t *testing.T) {
+		nd := &NatsDistributor{workerMgr: workerMgr, verifier: verifier}
+
+		data, _ := json.Marshal(forged)
+		nd.handleNatsMessage(&nats.Msg{Data: data})
+		assert.Equal(t, int64(0), nd.GetLastVersion())
+
+		data, _ = json.Marshal(valid)
+		nd.handleNatsMessage(&nats.Msg{Data: data})
+		assert.Equal(t, int64(7), nd.GetLastVersion())
+	})
+
+	t.Run("Redis", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		// A fresh manager, since workers skip versions they already run
+		rdWorkerMgr := worker.NewManager(workerServer.URL, nil)
+		rd := &RedisDistributor{workerMgr: rdWorkerMgr, verifier: verifier, ctx: ctx, cancel: cancel}
+
+		unsigned := forged
+		unsigned.Signature = ""
+
+		ch := make(chan models.ConfigEnvelope, 2)
+		ch <- unsigned
+		ch <- *valid
+		close(ch)
+		rd.handleRedisMessages(ch)
+
+		assert.Equal(t, int64(7), rd.GetLastVersion())
+	})
+
+	assert.Equal(t, []string{"https://ip.me", "https://ip.me"}, fw.urls())
+}
+
+func TestNatsRejectsUnknownEnvelopes(t *testing.T) {
+	signer, _ := newTestSigner(t)
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+
+	nd := &NatsDistributor{workerMgr: worker.NewManager(workerServer.URL, nil)}
+
+	future := signedEnvelope(t, signer, 3, models.WorkerConfig{URL: "https://ip.me"})
+	future.SchemaVersion = models.ConfigSchemaVersion + 1
+	data, _ := json.Marshal(future)
+	nd.handleNatsMessage(&nats.Msg{Data: data})
+
+	tampered := signedEnvelope(t, signer, 4, models.WorkerConfig{URL: "https://ip.me"})
+	tampered.Config.URL = "https://evil.example.com"
+	data, _ = json.Marshal(tampered)
+	nd.handleNatsMessage(&nats.Msg{Data: data})
+
+	legacy, _ := json.Marshal(map[string]interface{}{"version": "5", "config": models.WorkerConfig{URL: "https://ip.me"}})
+	nd.handleNatsMessage(&nats.Msg{Data: legacy})
+
+	assert.Equal(t, int64(0), nd.GetLastVersion())
+	assert.Empty(t, fw.urls())
+}
+
+func TestPushDistributorsIgnoreReplayedVersions(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+	fw := &fakeWorker{}
+	workerServer := httptest.NewServer(fw)
+	defer workerServer.Close()
+
+	current := signedEnvelope(t, signer, 2, models.WorkerConfig{URL: "https://ip.me"})
+	replayed := signedEnvelope(t, signer, 1, models.WorkerConfig{URL: "https://old.example.com"})
+
+	t.Run("NATS", func(t *testing.T) {
+		nd := &NatsDistributor{workerMgr: worker.NewManager(workerServer.URL, nil), verifier: verifier}
+
+		for _, envelope := range []*models.ConfigEnvelope{current, replayed, current} {
+			data, _ := json.Marshal(envelope)
+			nd.handleNatsMessage(&nats.Msg{Data: data})
+		}
+		assert.Equal(t, int64(2), nd.GetLastVersion())
+		assert.Equal(t, "https://ip.me", nd.GetLastConfig().URL)
+	})
+
+	t.Run("Redis", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		rd := &RedisDistributor{workerMgr: worker.NewManager(workerServer.URL, nil), verifier: verifier, ctx: ctx, cancel: cancel}
+
+		ch := make(chan models.ConfigEnvelope, 3)
+		ch <- *current
+		ch <- *replayed
+		ch <- *current
+		close(ch)
+		rd.handleRedisMessages(ch)
+
+		assert.Equal(t, int64(2), rd.GetLastVersion())
+		assert.Equal(t, "https://ip.me", rd.GetLastConfig().URL)
+	})
+
+	assert.Equal(t, []string{"https://ip.me", "https://ip.me"}, fw.urls())
+}
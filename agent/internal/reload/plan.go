@@ -0,0 +1,111 @@
+package reload
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/doniyusdinar/config-management/agent/internal/config"
+)
+
+// Plan lists the components a settings change rebuilds
+type Plan struct {
+	// Controller is set when the controller address, credentials or transport
+	// changed; the agent registers again
+	Controller bool
+	// Distribution is set when the distribution manager is rebuilt, which a
+	// controller change also requires
+	Distribution bool
+	Workers      bool
+	LogLevel     bool
+}
+
+// Empty reports whether nothing needs to change
+func (p Plan) Empty() bool {
+	return !p.Controller && !p.Distribution && !p.Workers && !p.LogLevel
+}
+
+// setting reads the value of one or more related settings
+type setting struct {
+	name  string
+	value func(c *config.Config) interface{}
+}
+
+// restartOnly lists the settings of components that are only set up at startup
+var restartOnly = []setting{
+	{"TLS_*", func(c *config.Config) interface{} {
+		return []interface{}{c.TLSCertFile, c.TLSKeyFile, c.TLSCAFile, c.TLSServerName, c.TLSReloadInterval}
+	}},
+	{"ENROLLMENT_TOKEN", func(c *config.Config) interface{} { return c.EnrollmentToken }},
+	{"SIGNING_PUBLIC_KEY_FILE", func(c *config.Config) interface{} { return c.SigningPublicKeyFile }},
+	{"CACHE_*", func(c *config.Config) interface{} { return []interface{}{c.CacheFile, c.CacheRetain} }},
+	{"ADMIN_ADDR", func(c *config.Config) interface{} { return c.AdminAddr }},
+	{"RECONCILE_INTERVAL", func(c *config.Config) interface{} { return c.ReconcileInterval }},
+	{"WORKER_DISCOVERY_*", func(c *config.Config) interface{} {
+		return []interface{}{c.DiscoveryFile, c.DiscoverySRV, c.DiscoveryDNS, c.DiscoveryDocker, c.DockerHost, c.DiscoveryScheme, c.DiscoveryInterval}
+	}},
+	{"WORKER_COMMAND", func(c *config.Config) interface{} {
+		return []interface{}{c.WorkerCommand, c.WorkerArgs, c.WorkerEnv, c.WorkerStartTimeout}
+	}},
+	// The supervisor health-checks the worker at WORKER_URL
+	{"WORKER_URL", func(c *config.Config) interface{} {
+		if c.WorkerCommand == "" {
+			return nil
+		}
+		return c.WorkerURL
+	}},
+}
+
+var controllerSettings = []setting{
+	{"CONTROLLER_*", func(c *config.Config) interface{} {
+		return []interface{}{c.ControllerURL, c.ControllerUsername, c.ControllerPassword, c.ControllerTransport}
+	}},
+}
+
+var distributionSettings = []setting{
+	{"DISTRIBUTION_STRATEGY", func(c *config.Config) interface{} { return c.SupportedStrategies }},
+	{"TRANSPORT_REFRESH_INTERVAL", func(c *config.Config) interface{} { return c.TransportRefresh }},
+	{"REDIS_*", func(c *config.Config) interface{} {
+		return []interface{}{c.RedisAddress, c.RedisPassword, c.RedisDB}
+	}},
+	// NATS_GROUPS includes the groups of every worker
+	{"NATS_*", func(c *config.Config) interface{} {
+		return []interface{}{c.NatsURL, c.NatsUsername, c.NatsPassword, c.NatsToken, c.NatsTLSEnabled,
+			c.NatsSubject, c.NatsQueueGroup, c.NatsEnvironment, c.NatsGroups}
+	}},
+	{"KAFKA_*", func(c *config.Config) interface{} { return []interface{}{c.KafkaBrokers, c.KafkaTopic} }},
+}
+
+var workerSettings = []setting{
+	{"WORKERS", func(c *config.Config) interface{} { return c.Workers }},
+}
+
+// NewPlan works out what changing from previous to next settings rebuilds. It
+// fails when a setting that only takes effect on restart changed.
+func NewPlan(previous, next *config.Config) (Plan, error) {
+	if names := changed(restartOnly, previous, next); len(names) > 0 {
+		return Plan{}, fmt.Errorf("%s cannot change without a restart", strings.Join(names, ", "))
+	}
+
+	plan := Plan{
+		Controller:   len(changed(controllerSettings, previous, next)) > 0,
+		Distribution: len(changed(distributionSettings, previous, next)) > 0,
+		Workers:      len(changed(workerSettings, previous, next)) > 0,
+		LogLevel:     previous.LogLevel != next.LogLevel,
+	}
+	if plan.Controller {
+		plan.Distribution = true
+	}
+	return plan, nil
+}
+
+// changed returns the names of the settings that differ
+func changed(settings []setting, previous, next *config.Config) []string {
+	var names []string
+	for _, s := range settings {
+		if !reflect.DeepEqual(s.value(previous), s.value(next)) {
+			names = append(names, s.name)
+		}
+	}
+	return names
+}
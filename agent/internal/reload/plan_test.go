@@ -0,0 +1,72 @@
+package reload
+
+import (
+	"testing"
+
+	"github.com/doniyusdinar/config-management/agent/internal/config"
+	"github.com/doniyusdinar/config-management/agent/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func baseConfig() *config.Config {
+	return &config.Config{
+		ControllerURL:       "http://controller:8080",
+		ControllerUsername:  "agent",
+		ControllerPassword:  "secret",
+		SupportedStrategies: []string{"POLLER"},
+		Workers:             []worker.Target{{Name: "default", URL: "http://worker:8082"}},
+		LogLevel:            "info",
+		CacheFile:           "cache.json",
+		CacheRetain:         3,
+	}
+}
+
+func TestNewPlan(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *config.Config)
+		want   Plan
+	}{
+		{"unchanged", func(c *config.Config) {}, Plan{}},
+		{"log level", func(c *config.Config) { c.LogLevel = "debug" }, Plan{LogLevel: true}},
+		{"workers", func(c *config.Config) {
+			c.Workers = append(c.Workers, worker.Target{Name: "batch", URL: "http://batch:8082"})
+		}, Plan{Workers: true}},
+		{"strategy", func(c *config.Config) { c.SupportedStrategies = []string{"NATS", "POLLER"} }, Plan{Distribution: true}},
+		{"nats groups", func(c *config.Config) { c.NatsGroups = []string{"eu"} }, Plan{Distribution: true}},
+		{"controller", func(c *config.Config) { c.ControllerPassword = "rotated" }, Plan{Controller: true, Distribution: true}},
+		{"worker url without supervisor", func(c *config.Config) { c.WorkerURL = "http://other:8082" }, Plan{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := baseConfig()
+			tt.change(next)
+			plan, err := NewPlan(baseConfig(), next)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, plan)
+			assert.Equal(t, tt.want == Plan{}, plan.Empty())
+		})
+	}
+}
+
+func TestNewPlanRejectsRestartOnlySettings(t *testing.T) {
+	next := baseConfig()
+	next.TLSCertFile = "agent.crt"
+	next.CacheRetain = 5
+	next.LogLevel = "debug"
+
+	_, err := NewPlan(baseConfig(), next)
+	require.Error(t, err)
+	assert.Equal(t, "TLS_*, CACHE_* cannot change without a restart", err.Error())
+
+	// The supervised worker is health-checked at WORKER_URL
+	previous := baseConfig()
+	previous.WorkerCommand = "./worker"
+	next = baseConfig()
+	next.WorkerCommand = "./worker"
+	next.WorkerURL = "http://other:8082"
+	_, err = NewPlan(previous, next)
+	assert.ErrorContains(t, err, "WORKER_URL")
+}
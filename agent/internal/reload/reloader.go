@@ -0,0 +1,120 @@
+package reload
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/config"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/fsnotify/fsnotify"
+)
+
+// settleDelay lets an editor finish writing before the file is read
+const settleDelay = 500 * time.Millisecond
+
+// Loader reads the agent's settings
+type Loader func() (*config.Config, error)
+
+// Applier rebuilds the components a plan lists for the next settings. An
+// error rejects the reload, so it must not leave anything half applied.
+type Applier func(next *config.Config, plan Plan) error
+
+// Reloader watches the agent's config file and applies changed settings at
+// runtime. Invalid settings are rejected and the current ones stay in effect.
+type Reloader struct {
+	path  string
+	load  Loader
+	apply Applier
+
+	mu      sync.Mutex
+	current *config.Config
+}
+
+// NewReloader creates a reloader for the config file at path, starting from current
+func NewReloader(path string, current *config.Config, load Loader, apply Applier) *Reloader {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return &Reloader{path: path, load: load, apply: apply, current: current}
+}
+
+// Current returns the settings in effect
+func (r *Reloader) Current() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Start reloads the settings whenever the config file changes, until the
+// context is cancelled. The directory is watched, so the file may be created
+// later or replaced atomically.
+func (r *Reloader) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(r.path)); err != nil {
+		return err
+	}
+	logger.Log.Infof("Watching %s for settings changes", r.path)
+
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Later events are covered by the pending reload, so a file that
+			// keeps changing is still reloaded
+			if filepath.Clean(event.Name) == r.path && settle == nil {
+				settle = time.After(settleDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+		case <-settle:
+			settle = nil
+			if err := r.Reload(); err != nil {
+				logger.Log.Errorf("Rejected settings reload: %v", err)
+			}
+		}
+	}
+}
+
+// Reload reads the settings again and applies what changed
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := r.load()
+	if err != nil {
+		return err
+	}
+	plan, err := NewPlan(r.current, next)
+	if err != nil {
+		return err
+	}
+	if plan.Empty() {
+		logger.Log.Debug("Settings unchanged")
+		return nil
+	}
+
+	logger.Log.Infof("Settings changed (controller: %t, distribution: %t, workers: %t, log level: %t)",
+		plan.Controller, plan.Distribution, plan.Workers, plan.LogLevel)
+	if err := r.apply(next, plan); err != nil {
+		return fmt.Errorf("failed to apply settings: %w", err)
+	}
+	r.current = next
+	logger.Log.Info("Settings reloaded")
+	return nil
+}
@@ -0,0 +1,111 @@
+package reload
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubAgent loads whatever settings it is given and records the plans it applies
+type stubAgent struct {
+	mu       sync.Mutex
+	next     *config.Config
+	loadErr  error
+	applyErr error
+	plans    []Plan
+}
+
+func (a *stubAgent) load() (*config.Config, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.next, a.loadErr
+}
+
+func (a *stubAgent) apply(next *config.Config, plan Plan) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.plans = append(a.plans, plan)
+	return a.applyErr
+}
+
+func (a *stubAgent) set(next *config.Config) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.next = next
+}
+
+func (a *stubAgent) applied() []Plan {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Plan(nil), a.plans...)
+}
+
+func TestReload(t *testing.T) {
+	current := baseConfig()
+	agent := &stubAgent{next: baseConfig()}
+	reloader := NewReloader("config.yaml", current, agent.load, agent.apply)
+
+	// Nothing changed, nothing is applied
+	require.NoError(t, reloader.Reload())
+	assert.Empty(t, agent.applied())
+
+	next := baseConfig()
+	next.LogLevel = "debug"
+	agent.set(next)
+	require.NoError(t, reloader.Reload())
+	assert.Equal(t, []Plan{{LogLevel: true}}, agent.applied())
+	assert.Same(t, next, reloader.Current())
+}
+
+func TestReloadRejectsInvalidSettings(t *testing.T) {
+	current := baseConfig()
+	agent := &stubAgent{loadErr: errors.New("invalid DISTRIBUTION_STRATEGY")}
+	reloader := NewReloader("config.yaml", current, agent.load, agent.apply)
+
+	assert.EqualError(t, reloader.Reload(), "invalid DISTRIBUTION_STRATEGY")
+
+	agent.loadErr = nil
+	next := baseConfig()
+	next.ControllerURL = "http://other:8080"
+	agent.set(next)
+	agent.applyErr = errors.New("controller unreachable")
+	assert.EqualError(t, reloader.Reload(), "failed to apply settings: controller unreachable")
+
+	next = baseConfig()
+	next.ReconcileInterval = 60
+	agent.set(next)
+	assert.ErrorContains(t, reloader.Reload(), "RECONCILE_INTERVAL cannot change without a restart")
+
+	// Every rejected reload keeps the settings in effect
+	assert.Same(t, current, reloader.Current())
+	assert.Len(t, agent.applied(), 1)
+}
+
+func TestReloaderWatchesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	agent := &stubAgent{next: baseConfig()}
+	reloader := NewReloader(path, baseConfig(), agent.load, agent.apply)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Start(ctx)
+
+	next := baseConfig()
+	next.LogLevel = "debug"
+	agent.set(next)
+
+	// Writes until the watcher, which starts asynchronously, picks one up
+	require.Eventually(t, func() bool {
+		os.WriteFile(path, []byte("LOG_LEVEL: debug\n"), 0644)
+		return len(agent.applied()) > 0
+	}, 10*time.Second, 200*time.Millisecond)
+	assert.Equal(t, Plan{LogLevel: true}, agent.applied()[0])
+}
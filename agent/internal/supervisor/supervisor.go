@@ -0,0 +1,245 @@
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/backoff"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+)
+
+const (
+	healthPollInterval = 200 * time.Millisecond
+	// stopTimeout is how long the worker gets to exit after SIGTERM before it is killed
+	stopTimeout = 10 * time.Second
+	// stableAfter is how long a healthy worker must run before the restart backoff resets
+	stableAfter = time.Minute
+)
+
+// Status describes the supervised worker process
+type Status struct {
+	Running     bool       `json:"running"`
+	Healthy     bool       `json:"healthy"`
+	PID         int        `json:"pid,omitempty"`
+	Restarts    int        `json:"restarts"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	LastExit    string     `json:"last_exit,omitempty"`
+	LastExitAt  *time.Time `json:"last_exit_at,omitempty"`
+	NextStartAt *time.Time `json:"next_start_at,omitempty"`
+}
+
+// Supervisor launches the worker binary, restarts it with backoff when it
+// exits, and reports each start once the worker's /health endpoint answers
+type Supervisor struct {
+	command      string
+	args         []string
+	env          []string
+	healthURL    string
+	startTimeout time.Duration
+	onReady      func()
+	client       *http.Client
+	backoff      *backoff.Backoff
+
+	mu     sync.RWMutex
+	status Status
+}
+
+// NewSupervisor creates a supervisor for command. env is added to the agent's
+// environment. onReady runs after every start once healthURL returns 200, so
+// the worker can be given its configuration. A non-nil tlsConfig is used for
+// HTTPS health checks.
+func NewSupervisor(command string, args, env []string, healthURL string, startTimeout time.Duration, tlsConfig *tls.Config, onReady func()) *Supervisor {
+	client := &http.Client{Timeout: time.Second}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+
+	return &Supervisor{
+		command:      command,
+		args:         args,
+		env:          env,
+		healthURL:    healthURL,
+		startTimeout: startTimeout,
+		onReady:      onReady,
+		client:       client,
+		backoff:      backoff.New(time.Second, time.Minute, 2.0),
+	}
+}
+
+// Start runs the worker until the context is cancelled, which stops it
+func (s *Supervisor) Start(ctx context.Context) {
+	for {
+		started := time.Now()
+		healthy, err := s.run(ctx)
+		if ctx.Err() != nil {
+			logger.Log.Info("Supervised worker stopped")
+			return
+		}
+
+		if healthy && time.Since(started) >= stableAfter {
+			s.backoff.Reset()
+		}
+		delay := s.backoff.Next()
+		next := time.Now().Add(delay)
+
+		s.mu.Lock()
+		now := time.Now()
+		s.status.Running = false
+		s.status.Healthy = false
+		s.status.PID = 0
+		s.status.LastExit = err.Error()
+		s.status.LastExitAt = &now
+		s.status.NextStartAt = &next
+		s.mu.Unlock()
+
+		logger.Log.Errorf("Supervised worker exited: %v, restarting in %v", err, delay.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		s.mu.Lock()
+		s.status.Restarts++
+		s.mu.Unlock()
+	}
+}
+
+// Status returns the current state of the worker process
+func (s *Supervisor) Status() Status {
+	if s == nil {
+		return Status{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+// run starts the worker once and waits for it to exit. It reports whether the
+// worker became healthy and why it stopped.
+func (s *Supervisor) run(ctx context.Context) (bool, error) {
+	cmd := exec.CommandContext(ctx, s.command, s.args...)
+	cmd.Env = append(os.Environ(), s.env...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = stopTimeout
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return false, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return false, fmt.Errorf("failed to start worker: %w", err)
+	}
+
+	var logs sync.WaitGroup
+	logs.Add(2)
+	go s.capture(stdout, &logs)
+	go s.capture(stderr, &logs)
+
+	now := time.Now()
+	s.mu.Lock()
+	s.status.Running = true
+	s.status.PID = cmd.Process.Pid
+	s.status.StartedAt = &now
+	s.status.NextStartAt = nil
+	s.mu.Unlock()
+	logger.Log.Infof("Started supervised worker %s (pid %d)", s.command, cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() {
+		logs.Wait()
+		exited <- cmd.Wait()
+	}()
+
+	if err := s.waitHealthy(ctx, exited); err != nil {
+		if ctx.Err() == nil {
+			// Still running but unhealthy; the context stops it otherwise
+			cmd.Process.Kill()
+		}
+		<-exited
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.status.Healthy = true
+	s.mu.Unlock()
+	logger.Log.Info("Supervised worker is healthy")
+	if s.onReady != nil {
+		s.onReady()
+	}
+
+	if err := <-exited; err != nil {
+		return true, err
+	}
+	return true, fmt.Errorf("worker exited")
+}
+
+// waitHealthy polls the health endpoint until it answers 200. It fails when
+// the worker exits first or does not become healthy within the start timeout;
+// in the first case the exit is passed back on exited.
+func (s *Supervisor) waitHealthy(ctx context.Context, exited chan error) error {
+	deadline := time.After(s.startTimeout)
+	ticker := time.NewTicker(healthPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.healthy(ctx) {
+			return nil
+		}
+
+		select {
+		case err := <-exited:
+			exited <- err
+			if err == nil {
+				err = fmt.Errorf("worker exited")
+			}
+			return fmt.Errorf("exited before becoming healthy: %w", err)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("worker not healthy after %v", s.startTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Supervisor) healthy(ctx context.Context) bool {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.healthURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// capture logs the worker's output line by line with a worker prefix
+func (s *Supervisor) capture(r io.Reader, done *sync.WaitGroup) {
+	defer done.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		logger.Log.Infof("[worker] %s", scanner.Text())
+	}
+}
@@ -0,0 +1,133 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestHelperWorker is not a real test: the supervisor runs the test binary with
+// HELPER_WORKER_ADDR set to act as a worker. With HELPER_WORKER_CRASH_FILE it
+// exits shortly after becoming healthy unless that file exists.
+func TestHelperWorker(t *testing.T) {
+	addr := os.Getenv("HELPER_WORKER_ADDR")
+	if addr == "" {
+		return
+	}
+
+	fmt.Println("worker starting")
+	if crashFile := os.Getenv("HELPER_WORKER_CRASH_FILE"); crashFile != "" {
+		if _, err := os.Stat(crashFile); os.IsNotExist(err) {
+			os.WriteFile(crashFile, nil, 0644)
+			go func() {
+				time.Sleep(500 * time.Millisecond)
+				os.Exit(3)
+			}()
+		}
+	}
+	if os.Getenv("HELPER_WORKER_UNHEALTHY") != "" {
+		time.Sleep(time.Hour)
+	}
+
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	http.ListenAndServe(addr, nil)
+	os.Exit(0)
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// syncBuffer collects log output written from several goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSupervisorRestartsCrashedWorker(t *testing.T) {
+	var logs syncBuffer
+	logger.Log.SetOutput(&logs)
+	defer logger.Log.SetOutput(os.Stderr)
+
+	addr := freeAddr(t)
+	env := []string{
+		"HELPER_WORKER_ADDR=" + addr,
+		"HELPER_WORKER_CRASH_FILE=" + t.TempDir() + "/crashed",
+	}
+
+	var ready int32
+	s := NewSupervisor(os.Args[0], []string{"-test.run=^TestHelperWorker$"}, env, "http://"+addr+"/health", 10*time.Second, nil, func() {
+		atomic.AddInt32(&ready, 1)
+	})
+	s.backoff.InitialInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Start(ctx)
+		close(done)
+	}()
+
+	// The first run crashes after becoming healthy; config is pushed after each start
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&ready) == 2 }, 20*time.Second, 50*time.Millisecond)
+	status := s.Status()
+	assert.True(t, status.Running)
+	assert.True(t, status.Healthy)
+	assert.Equal(t, 1, status.Restarts)
+	assert.Contains(t, status.LastExit, "exit status 3")
+	assert.Contains(t, logs.String(), "[worker] worker starting")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(15 * time.Second):
+		t.Fatal("supervisor did not stop")
+	}
+}
+
+func TestSupervisorGatesStartOnHealth(t *testing.T) {
+	addr := freeAddr(t)
+	env := []string{"HELPER_WORKER_ADDR=" + addr, "HELPER_WORKER_UNHEALTHY=1"}
+
+	var ready int32
+	s := NewSupervisor(os.Args[0], []string{"-test.run=^TestHelperWorker$"}, env, "http://"+addr+"/health", 300*time.Millisecond, nil, func() {
+		atomic.AddInt32(&ready, 1)
+	})
+	s.backoff.InitialInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+
+	require.Eventually(t, func() bool { return s.Status().Restarts >= 1 }, 10*time.Second, 50*time.Millisecond)
+	assert.Contains(t, s.Status().LastExit, "not healthy after")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&ready))
+}
@@ -2,40 +2,417 @@ package worker
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/doniyusdinar/config-management/pkg/logger"
 	"github.com/doniyusdinar/config-management/pkg/models"
 )
 
+const (
+	defaultAttempts   = 3
+	defaultRetryDelay = 500 * time.Millisecond
+	requestTimeout    = 10 * time.Second
+)
+
+// Target is a worker instance managed by the agent. A worker with groups only
+// receives configurations broadcast to every worker or targeted at one of its
+// groups; a worker without groups receives all of them.
+type Target struct {
+	Name   string
+	URL    string
+	Groups []string
+}
+
+// Accepts reports whether the worker takes configurations targeted at group
+// ("" for broadcasts)
+func (t Target) Accepts(group string) bool {
+	if group == "" || len(t.Groups) == 0 {
+		return true
+	}
+	for _, g := range t.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTargets parses a comma-separated worker list. Each entry is
+// name=url, optionally followed by |group for every group, e.g.
+// "api=http://worker-1:8082|eu|canary,batch=http://worker-2:8082".
+func ParseTargets(value string) ([]Target, error) {
+	var targets []Target
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, "|")
+		name, url, ok := strings.Cut(parts[0], "=")
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("invalid worker %q, expected name=url", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate worker name %q", name)
+		}
+		seen[name] = true
+
+		target := Target{Name: name, URL: url}
+		for _, group := range parts[1:] {
+			if group = strings.TrimSpace(group); group != "" {
+				target.Groups = append(target.Groups, group)
+			}
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// Manager forwards configurations to the agent's workers concurrently,
+// retrying each worker independently. Deliveries that still fail are queued
+// per worker and retried with backoff while Start runs.
 type Manager struct {
-	workerURL string
-	client    *http.Client
+	client         *http.Client
+	attempts       int
+	retryDelay     time.Duration
+	backoffInitial time.Duration
+	backoffMax     time.Duration
+	wake           chan struct{}
+
+	mu      sync.RWMutex
+	ctx     context.Context // set by Start, cancels deliveries
+	targets []Target
+	status  map[string]*models.WorkerStatus
+	latest  map[string]delivery // newest configuration per group, "" for broadcasts
+	locks   map[string]*sync.Mutex
+	pending map[string]*pendingDelivery
+}
+
+// delivery is a configuration version as forwarded to the workers
+type delivery struct {
+	version     int64
+	contentHash string
+	configJSON  []byte
+}
+
+// NewManager creates a manager for the worker at workerURL. A non-nil tlsConfig
+// is used for HTTPS workers, including presenting the agent's client certificate.
+func NewManager(workerURL string, tlsConfig *tls.Config) *Manager {
+	return NewMultiManager([]Target{{Name: "default", URL: workerURL}}, tlsConfig)
 }
 
-func NewManager(workerURL string) *Manager {
+// NewMultiManager creates a manager for several workers
+func NewMultiManager(targets []Target, tlsConfig *tls.Config) *Manager {
+	client := &http.Client{Timeout: requestTimeout}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+
+	status := make(map[string]*models.WorkerStatus, len(targets))
+	for _, target := range targets {
+		status[target.Name] = &models.WorkerStatus{Name: target.Name, URL: target.URL, Groups: target.Groups}
+	}
+
 	return &Manager{
-		workerURL: workerURL,
-		client:    &http.Client{},
+		targets:        targets,
+		client:         client,
+		attempts:       defaultAttempts,
+		retryDelay:     defaultRetryDelay,
+		backoffInitial: defaultBackoffInitial,
+		backoffMax:     defaultBackoffMax,
+		wake:           make(chan struct{}, 1),
+		ctx:            context.Background(),
+		status:         status,
+		latest:         make(map[string]delivery),
+		locks:          make(map[string]*sync.Mutex),
+		pending:        make(map[string]*pendingDelivery),
+	}
+}
+
+// Targets returns the managed workers
+func (m *Manager) Targets() []Target {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Target(nil), m.targets...)
+}
+
+// SetTargets replaces the managed workers, e.g. after discovery. Workers that
+// were not managed before immediately receive the newest configuration they
+// accept, and their results are returned.
+func (m *Manager) SetTargets(targets []Target) []models.WorkerResult {
+	m.mu.Lock()
+	status := make(map[string]*models.WorkerStatus, len(targets))
+	var added []Target
+	for _, target := range targets {
+		if existing, ok := m.status[target.Name]; ok && existing.URL == target.URL {
+			existing.Groups = target.Groups
+			status[target.Name] = existing
+			continue
+		}
+		status[target.Name] = &models.WorkerStatus{Name: target.Name, URL: target.URL, Groups: target.Groups}
+		added = append(added, target)
+	}
+	m.targets = targets
+	m.status = status
+	for name, p := range m.pending {
+		// Deliveries to removed or moved workers are not retried
+		if current, ok := status[name]; !ok || current.URL != p.target.URL {
+			delete(m.pending, name)
+		}
+	}
+	m.mu.Unlock()
+
+	var results []models.WorkerResult
+	for _, target := range added {
+		logger.Log.Infof("Worker %s added at %s", target.Name, target.URL)
+		current, ok := m.current(target)
+		if !ok {
+			continue
+		}
+		results = append(results, m.forwardWithRetry(target, current))
+	}
+	return results
+}
+
+// Resend forwards the newest configuration a worker accepts again, even if it
+// accepted that version before, e.g. after the worker restarted. It reports
+// false when the worker is unknown or nothing was forwarded yet.
+func (m *Manager) Resend(name string) (models.WorkerResult, bool) {
+	var target Target
+	found := false
+	for _, t := range m.Targets() {
+		if t.Name == name {
+			target, found = t, true
+		}
+	}
+	if !found {
+		return models.WorkerResult{}, false
+	}
+
+	current, ok := m.current(target)
+	if !ok {
+		return models.WorkerResult{}, false
+	}
+	m.setVersion(name, 0)
+	return m.forwardWithRetry(target, current), true
+}
+
+// ForgetVersions clears the version recorded for every worker, so the next
+// forward reaches all of them even if they accepted that version before
+func (m *Manager) ForgetVersions() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, status := range m.status {
+		status.Version = 0
+	}
+}
+
+// current returns the newest configuration forwarded so far that target accepts
+func (m *Manager) current(target Target) (delivery, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var newest delivery
+	found := false
+	for group, d := range m.latest {
+		if target.Accepts(group) && (!found || d.version > newest.version) {
+			newest = d
+			found = true
+		}
+	}
+	return newest, found
+}
+
+// Groups returns every group any worker selects, so the agent can subscribe to them
+func (m *Manager) Groups() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var groups []string
+	seen := make(map[string]bool)
+	for _, target := range m.targets {
+		for _, group := range target.Groups {
+			if !seen[group] {
+				seen[group] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups
+}
+
+// SetRetry changes how often and how quickly a failed worker is retried before
+// the delivery is queued
+func (m *Manager) SetRetry(attempts int, delay time.Duration) {
+	if attempts < 1 {
+		attempts = 1
 	}
+	m.attempts = attempts
+	m.retryDelay = delay
 }
 
-// ForwardConfig forwards configuration to the worker
+// ForwardConfig forwards configuration to every worker
 func (m *Manager) ForwardConfig(config models.WorkerConfig) error {
+	_, err := m.Forward(0, config, "")
+	return err
+}
+
+// Forward forwards a configuration version to the workers that accept group
+// ("" for a broadcast) and returns the outcome per worker; it is empty when every
+// selected worker already runs the version. The error joins the failures of all
+// workers that did not accept it.
+func (m *Manager) Forward(version int64, config models.WorkerConfig, group string) ([]models.WorkerResult, error) {
 	configJSON, err := json.Marshal(config)
 	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	hash, err := models.ContentHash(config)
+	if err != nil {
+		return nil, err
+	}
+	d := delivery{version: version, contentHash: hash, configJSON: configJSON}
+
+	// Workers that already accepted this version are left alone, so a version
+	// targeted at a group and then broadcast reaches each worker once
+	var selected []Target
+	m.mu.Lock()
+	if latest, ok := m.latest[group]; !ok || version == 0 || version >= latest.version {
+		m.latest[group] = d
+	}
+	for _, target := range m.targets {
+		if target.Accepts(group) && (version == 0 || m.status[target.Name].Version != version) {
+			selected = append(selected, target)
+		}
+	}
+	m.mu.Unlock()
+	if len(selected) == 0 {
+		return nil, nil
+	}
+
+	results := make([]models.WorkerResult, len(selected))
+	var wg sync.WaitGroup
+	for i, target := range selected {
+		wg.Add(1)
+		go func(i int, target Target) {
+			defer wg.Done()
+			results[i] = m.forwardWithRetry(target, d)
+		}(i, target)
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, result := range results {
+		if !result.Success {
+			errs = append(errs, fmt.Errorf("worker %s: %s", result.Worker, result.Error))
+		}
+	}
+	if len(errs) > 0 {
+		return results, errors.Join(errs...)
+	}
+
+	logger.Log.Infof("Configuration forwarded to %d worker(s) successfully", len(results))
+	return results, nil
+}
+
+// forwardWithRetry posts the configuration to one worker, retrying with a
+// doubling delay, and records the outcome. A delivery that still fails is
+// queued; one that succeeds replaces any queued older version.
+func (m *Manager) forwardWithRetry(target Target, d delivery) models.WorkerResult {
+	lock := m.lock(target.Name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	result := models.WorkerResult{Worker: target.Name}
+	if m.superseded(target.Name, d) {
+		// A newer version reached the worker while this one waited
+		result.Success = true
+		return result
+	}
+
+	ctx := m.context()
+	delay := m.retryDelay
+
+	var err error
+attempts:
+	for result.Attempts < m.attempts {
+		if result.Attempts > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				break attempts
+			}
+			delay *= 2
+		}
+		result.Attempts++
+
+		if err = m.post(ctx, target, d); err == nil {
+			break
+		}
+		logger.Log.Warnf("Failed to forward config to worker %s (attempt %d/%d): %v", target.Name, result.Attempts, m.attempts, err)
+	}
+
+	result.Success = err == nil
+	m.record(target.Name, d.version, err)
+	if err != nil {
+		result.Error = err.Error()
+		m.enqueue(target, d, result.Attempts, err)
+	} else {
+		m.dequeue(target.Name, d.version)
+	}
+	return result
+}
+
+// lock returns the mutex that serializes deliveries to a worker, so an older
+// version never lands after a newer one
+func (m *Manager) lock(name string) *sync.Mutex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	lock, ok := m.locks[name]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.locks[name] = lock
 	}
+	return lock
+}
+
+// superseded reports whether a worker already accepted a newer version than d
+func (m *Manager) superseded(name string, d delivery) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	status, ok := m.status[name]
+	return d.version > 0 && ok && status.Version > d.version
+}
 
-	url := fmt.Sprintf("%s/config", m.workerURL)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(configJSON))
+func (m *Manager) context() context.Context {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ctx
+}
+
+func (m *Manager) post(ctx context.Context, target Target, d delivery) error {
+	url := fmt.Sprintf("%s/config", target.URL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(d.configJSON))
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if d.version > 0 {
+		req.Header.Set(models.ConfigVersionHeader, strconv.FormatInt(d.version, 10))
+	}
 
 	resp, err := m.client.Do(req)
 	if err != nil {
@@ -46,7 +423,39 @@ func (m *Manager) ForwardConfig(config models.WorkerConfig) error {
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("worker returned status %d", resp.StatusCode)
 	}
-
-	logger.Log.Info("Configuration forwarded to worker successfully")
 	return nil
 }
+
+func (m *Manager) record(name string, version int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	status, ok := m.status[name]
+	if !ok {
+		// Removed by discovery while the configuration was being forwarded
+		return
+	}
+	status.LastForwardAt = &now
+	if err != nil {
+		status.LastError = err.Error()
+		return
+	}
+	status.LastError = ""
+	status.LastSuccessAt = &now
+	if version > 0 {
+		status.Version = version
+	}
+}
+
+// Status returns the last known state of every worker, in configuration order
+func (m *Manager) Status() []models.WorkerStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := make([]models.WorkerStatus, 0, len(m.targets))
+	for _, target := range m.targets {
+		status = append(status, *m.status[target.Name])
+	}
+	return status
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/doniyusdinar/config-management/pkg/models"
 	"github.com/stretchr/testify/assert"
@@ -29,7 +31,7 @@ func TestForwardConfig(t *testing.T) {
 	}))
 	defer server.Close()
 
-	manager := NewManager(server.URL)
+	manager := NewManager(server.URL, nil)
 	config := models.WorkerConfig{URL: "https://example.com"}
 
 	err := manager.ForwardConfig(config)
@@ -40,7 +42,7 @@ func TestForwardConfig(t *testing.T) {
 
 func TestForwardConfigError(t *testing.T) {
 	// Use invalid URL to cause error
-	manager := NewManager("http://invalid-url-that-does-not-exist:9999")
+	manager := NewManager("http://invalid-url-that-does-not-exist:9999", nil)
 	config := models.WorkerConfig{URL: "https://example.com"}
 
 	err := manager.ForwardConfig(config)
@@ -54,10 +56,120 @@ func TestForwardConfigBadStatus(t *testing.T) {
 	}))
 	defer server.Close()
 
-	manager := NewManager(server.URL)
+	manager := NewManager(server.URL, nil)
 	config := models.WorkerConfig{URL: "https://example.com"}
 
 	err := manager.ForwardConfig(config)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "status")
 }
+
+// countingWorker counts the configs it receives and fails the first failures of them
+func countingWorker(t *testing.T, failures int) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(atomic.AddInt32(&calls, 1)) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestParseTargets(t *testing.T) {
+	targets, err := ParseTargets("api=http://worker-1:8082|eu|canary, batch=http://worker-2:8082")
+	require.NoError(t, err)
+	assert.Equal(t, []Target{
+		{Name: "api", URL: "http://worker-1:8082", Groups: []string{"eu", "canary"}},
+		{Name: "batch", URL: "http://worker-2:8082"},
+	}, targets)
+
+	_, err = ParseTargets("http://worker-1:8082")
+	assert.Error(t, err)
+	_, err = ParseTargets("api=http://a,api=http://b")
+	assert.Error(t, err)
+}
+
+func TestForwardToMultipleWorkers(t *testing.T) {
+	api, apiCalls := countingWorker(t, 0)
+	flaky, flakyCalls := countingWorker(t, 1)
+	batch, batchCalls := countingWorker(t, 0)
+
+	manager := NewMultiManager([]Target{
+		{Name: "api", URL: api.URL, Groups: []string{"eu"}},
+		{Name: "flaky", URL: flaky.URL},
+		{Name: "batch", URL: batch.URL, Groups: []string{"us"}},
+	}, nil)
+	manager.SetRetry(2, time.Millisecond)
+	assert.Equal(t, []string{"eu", "us"}, manager.Groups())
+
+	// Targeted at eu: the us-only worker is left out, the flaky one is retried
+	results, err := manager.Forward(3, models.WorkerConfig{URL: "https://example.com"}, "eu")
+	require.NoError(t, err)
+	assert.Equal(t, []models.WorkerResult{
+		{Worker: "api", Success: true, Attempts: 1},
+		{Worker: "flaky", Success: true, Attempts: 2},
+	}, results)
+	assert.Equal(t, int32(0), atomic.LoadInt32(batchCalls))
+
+	// The broadcast of the same version only reaches the worker that lacks it
+	results, err = manager.Forward(3, models.WorkerConfig{URL: "https://example.com"}, "")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "batch", results[0].Worker)
+	assert.Equal(t, int32(1), atomic.LoadInt32(apiCalls))
+	assert.Equal(t, int32(2), atomic.LoadInt32(flakyCalls))
+
+	results, err = manager.Forward(3, models.WorkerConfig{URL: "https://example.com"}, "")
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	for _, status := range manager.Status() {
+		assert.Equal(t, int64(3), status.Version, status.Name)
+		assert.Empty(t, status.LastError)
+		assert.NotNil(t, status.LastSuccessAt)
+	}
+}
+
+func TestForwardReportsFailingWorker(t *testing.T) {
+	ok, _ := countingWorker(t, 0)
+	down, downCalls := countingWorker(t, 10)
+
+	manager := NewMultiManager([]Target{{Name: "ok", URL: ok.URL}, {Name: "down", URL: down.URL}}, nil)
+	manager.SetRetry(3, time.Millisecond)
+
+	results, err := manager.Forward(5, models.WorkerConfig{URL: "https://example.com"}, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "worker down")
+	require.Len(t, results, 2)
+	assert.True(t, results[0].Success)
+	assert.False(t, results[1].Success)
+	assert.Equal(t, 3, results[1].Attempts)
+	assert.Equal(t, int32(3), atomic.LoadInt32(downCalls))
+
+	status := manager.Status()
+	assert.Equal(t, int64(5), status[0].Version)
+	assert.Equal(t, int64(0), status[1].Version)
+	assert.Contains(t, status[1].LastError, "status 503")
+}
+
+func TestResendAfterRestart(t *testing.T) {
+	server, calls := countingWorker(t, 0)
+	manager := NewManager(server.URL, nil)
+
+	_, ok := manager.Resend("default")
+	assert.False(t, ok, "nothing forwarded yet")
+
+	_, err := manager.Forward(3, models.WorkerConfig{URL: "https://example.com"}, "")
+	require.NoError(t, err)
+
+	result, ok := manager.Resend("default")
+	require.True(t, ok)
+	assert.True(t, result.Success)
+	assert.Equal(t, int32(2), atomic.LoadInt32(calls))
+
+	_, ok = manager.Resend("unknown")
+	assert.False(t, ok)
+}
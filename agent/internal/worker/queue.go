@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/doniyusdinar/config-management/agent/internal/backoff"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+)
+
+const (
+	defaultBackoffInitial = time.Second
+	defaultBackoffMax     = 5 * time.Minute
+	// idleWait is how long the queue sleeps when nothing is pending
+	idleWait = time.Minute
+)
+
+// pendingDelivery is the newest configuration a worker has not accepted yet.
+// Older versions are coalesced into it, so only the newest one is retried.
+type pendingDelivery struct {
+	target    Target
+	delivery  delivery
+	backoff   *backoff.Backoff
+	attempts  int
+	lastError string
+	since     time.Time
+	nextAt    time.Time
+	inFlight  bool
+}
+
+// SetBackoff changes the delay before the first queued retry and its maximum
+func (m *Manager) SetBackoff(initial, max time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.backoffInitial = initial
+	m.backoffMax = max
+}
+
+// Start retries queued deliveries with exponential backoff until the context
+// is cancelled, which also aborts deliveries in progress
+func (m *Manager) Start(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.mu.Unlock()
+
+	for {
+		wait := m.retryDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		case <-m.wake:
+		}
+	}
+}
+
+// Pending returns the queued deliveries, by worker name
+func (m *Manager) Pending() []models.PendingDelivery {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	pending := make([]models.PendingDelivery, 0, len(m.pending))
+	for name, p := range m.pending {
+		pending = append(pending, models.PendingDelivery{
+			Worker:        name,
+			Version:       p.delivery.version,
+			Attempts:      p.attempts,
+			LastError:     p.lastError,
+			Since:         p.since,
+			NextAttemptAt: p.nextAt,
+		})
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Worker < pending[j].Worker })
+	return pending
+}
+
+// enqueue queues a failed delivery unless a newer version is already queued
+func (m *Manager) enqueue(target Target, d delivery, attempts int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.status[target.Name]; !ok {
+		return
+	}
+
+	now := time.Now()
+	p, ok := m.pending[target.Name]
+	if !ok {
+		p = &pendingDelivery{backoff: backoff.New(m.backoffInitial, m.backoffMax, 2.0), since: now}
+		m.pending[target.Name] = p
+	} else if d.version > 0 && p.delivery.version > d.version {
+		return
+	}
+
+	if ok && p.delivery.version != d.version {
+		p.attempts = 0
+		p.since = now
+	}
+	p.target = target
+	p.delivery = d
+	p.attempts += attempts
+	p.lastError = err.Error()
+	p.nextAt = now.Add(p.backoff.Next())
+	logger.Log.Warnf("Queued config version %d for worker %s, retrying in %v", d.version, target.Name, p.nextAt.Sub(now).Round(time.Millisecond))
+	m.signal()
+}
+
+// dequeue drops a queued delivery that version replaces
+func (m *Manager) dequeue(name string, version int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.pending[name]; ok && (version == 0 || p.delivery.version <= version) {
+		delete(m.pending, name)
+	}
+}
+
+func (m *Manager) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// retryDue starts the retries that are due and returns how long to wait for the next one
+func (m *Manager) retryDue(ctx context.Context) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	wait := idleWait
+	for name, p := range m.pending {
+		if p.inFlight {
+			continue
+		}
+		if !p.nextAt.After(now) {
+			p.inFlight = true
+			go m.retry(ctx, name, p)
+			continue
+		}
+		if until := p.nextAt.Sub(now); until < wait {
+			wait = until
+		}
+	}
+	return wait
+}
+
+// retry makes one more attempt at a queued delivery
+func (m *Manager) retry(ctx context.Context, name string, p *pendingDelivery) {
+	defer func() {
+		m.mu.Lock()
+		p.inFlight = false
+		m.mu.Unlock()
+		m.signal()
+	}()
+
+	lock := m.lock(name)
+	lock.Lock()
+	defer lock.Unlock()
+
+	m.mu.RLock()
+	queued := m.pending[name] == p
+	d, target := p.delivery, p.target
+	m.mu.RUnlock()
+	if !queued {
+		// Delivered or replaced while waiting for the worker
+		return
+	}
+	if m.superseded(name, d) {
+		m.dequeue(name, d.version)
+		return
+	}
+
+	err := m.post(ctx, target, d)
+	if ctx.Err() != nil {
+		return
+	}
+	m.record(name, d.version, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.attempts++
+	if err == nil {
+		if m.pending[name] == p && p.delivery.version == d.version {
+			delete(m.pending, name)
+		}
+		logger.Log.Infof("Delivered queued config version %d to worker %s after %d attempts", d.version, name, p.attempts)
+		return
+	}
+
+	p.lastError = err.Error()
+	p.nextAt = time.Now().Add(p.backoff.Next())
+	logger.Log.Warnf("Queued delivery of config version %d to worker %s failed (attempt %d): %v", d.version, name, p.attempts, err)
+}
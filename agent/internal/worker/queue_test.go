@@ -0,0 +1,157 @@
+package worker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// switchableWorker fails until it is told to recover and records accepted versions
+type switchableWorker struct {
+	mu       sync.Mutex
+	failing  bool
+	versions []string
+}
+
+func (w *switchableWorker) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failing {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.versions = append(w.versions, r.Header.Get(models.ConfigVersionHeader))
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (w *switchableWorker) setFailing(failing bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failing = failing
+}
+
+func (w *switchableWorker) accepted() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.versions...)
+}
+
+func TestQueueRetriesNewestPendingVersion(t *testing.T) {
+	w := &switchableWorker{failing: true}
+	server := httptest.NewServer(w)
+	defer server.Close()
+
+	manager := NewManager(server.URL, nil)
+	manager.SetRetry(1, time.Millisecond)
+	manager.SetBackoff(10*time.Millisecond, 50*time.Millisecond)
+
+	_, err := manager.Forward(1, models.WorkerConfig{URL: "https://one.example.com"}, "")
+	require.Error(t, err)
+	_, err = manager.Forward(2, models.WorkerConfig{URL: "https://two.example.com"}, "")
+	require.Error(t, err)
+
+	// Version 1 is coalesced into version 2
+	pending := manager.Pending()
+	require.Len(t, pending, 1)
+	assert.Equal(t, "default", pending[0].Worker)
+	assert.Equal(t, int64(2), pending[0].Version)
+	assert.Equal(t, 1, pending[0].Attempts)
+	assert.Contains(t, pending[0].LastError, "status 503")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go manager.Start(ctx)
+
+	w.setFailing(false)
+	require.Eventually(t, func() bool { return len(manager.Pending()) == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"2"}, w.accepted())
+	assert.Equal(t, int64(2), manager.Status()[0].Version)
+}
+
+func TestQueueDroppedByNewerDelivery(t *testing.T) {
+	w := &switchableWorker{failing: true}
+	server := httptest.NewServer(w)
+	defer server.Close()
+
+	manager := NewManager(server.URL, nil)
+	manager.SetRetry(1, time.Millisecond)
+
+	_, err := manager.Forward(1, models.WorkerConfig{URL: "https://one.example.com"}, "")
+	require.Error(t, err)
+	require.Len(t, manager.Pending(), 1)
+
+	w.setFailing(false)
+	_, err = manager.Forward(2, models.WorkerConfig{URL: "https://two.example.com"}, "")
+	require.NoError(t, err)
+	assert.Empty(t, manager.Pending())
+
+	// An older version forwarded late does not overwrite the newer one
+	_, err = manager.Forward(1, models.WorkerConfig{URL: "https://one.example.com"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2"}, w.accepted())
+}
+
+func TestQueueStopsWithContext(t *testing.T) {
+	w := &switchableWorker{failing: true}
+	server := httptest.NewServer(w)
+	defer server.Close()
+
+	manager := NewManager(server.URL, nil)
+	manager.SetRetry(1, time.Millisecond)
+	manager.SetBackoff(time.Millisecond, time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		manager.Start(ctx)
+		close(done)
+	}()
+
+	_, err := manager.Forward(1, models.WorkerConfig{URL: "https://one.example.com"}, "")
+	require.Error(t, err)
+	require.Eventually(t, func() bool {
+		pending := manager.Pending()
+		return len(pending) == 1 && pending[0].Attempts > 2
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery queue did not stop")
+	}
+	assert.Len(t, manager.Pending(), 1)
+}
+
+func TestQueueFollowsMovedWorker(t *testing.T) {
+	old := &switchableWorker{failing: true}
+	oldServer := httptest.NewServer(old)
+	defer oldServer.Close()
+	moved := &switchableWorker{}
+	movedServer := httptest.NewServer(moved)
+	defer movedServer.Close()
+
+	manager := NewMultiManager([]Target{{Name: "api", URL: oldServer.URL}}, nil)
+	manager.SetRetry(1, time.Millisecond)
+
+	_, err := manager.Forward(1, models.WorkerConfig{URL: "https://one.example.com"}, "")
+	require.Error(t, err)
+	require.Len(t, manager.Pending(), 1)
+
+	// The worker moved: the old address is no longer retried, the new one gets the config
+	results := manager.SetTargets([]Target{{Name: "api", URL: movedServer.URL}})
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Success)
+	assert.Empty(t, manager.Pending())
+	assert.Equal(t, []string{"1"}, moved.accepted())
+
+	manager.SetTargets(nil)
+	assert.Empty(t, manager.Pending())
+}
@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+)
+
+// statusTimeout bounds a single worker status check
+const statusTimeout = 5 * time.Second
+
+// errStatusUnsupported is returned for workers without the config status endpoint
+var errStatusUnsupported = errors.New("worker does not report its config status")
+
+// StartReconciler repairs worker drift every interval until the context is cancelled
+func (m *Manager) StartReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Reconcile(ctx)
+		}
+	}
+}
+
+// Reconcile asks every worker which configuration it applies and re-forwards
+// the newest configuration it accepts when the version or content hash differ,
+// e.g. after the worker restarted without one. It returns the re-forward results.
+func (m *Manager) Reconcile(ctx context.Context) []models.WorkerResult {
+	targets := m.Targets()
+	repaired := make([]*models.WorkerResult, len(targets))
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		desired, ok := m.current(target)
+		if !ok {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, target Target, desired delivery) {
+			defer wg.Done()
+			if result, ok := m.reconcile(ctx, target, desired); ok {
+				repaired[i] = &result
+			}
+		}(i, target, desired)
+	}
+	wg.Wait()
+
+	var results []models.WorkerResult
+	for _, result := range repaired {
+		if result != nil {
+			results = append(results, *result)
+		}
+	}
+	return results
+}
+
+func (m *Manager) reconcile(ctx context.Context, target Target, desired delivery) (models.WorkerResult, bool) {
+	status, err := m.configStatus(ctx, target)
+	if errors.Is(err, errStatusUnsupported) {
+		logger.Log.Debugf("Skipping reconciliation of worker %s: %v", target.Name, err)
+		return models.WorkerResult{}, false
+	}
+	if err != nil {
+		logger.Log.Warnf("Failed to check config of worker %s: %v", target.Name, err)
+		return models.WorkerResult{}, false
+	}
+	if status.HasConfig && status.Version == desired.version && status.ContentHash == desired.contentHash {
+		return models.WorkerResult{}, false
+	}
+
+	// A newer version may have been forwarded while the worker was checked
+	if current, _ := m.current(target); current.version != desired.version {
+		return models.WorkerResult{}, false
+	}
+
+	logger.Log.Warnf("Worker %s applies config version %d (has config: %t), re-forwarding version %d",
+		target.Name, status.Version, status.HasConfig, desired.version)
+	m.setVersion(target.Name, status.Version)
+	return m.forwardWithRetry(target, desired), true
+}
+
+// configStatus fetches the configuration a worker currently applies
+func (m *Manager) configStatus(ctx context.Context, target Target) (models.WorkerConfigStatus, error) {
+	var status models.WorkerConfigStatus
+
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/config/status", target.URL)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return status, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return status, fmt.Errorf("failed to get config status: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return status, errStatusUnsupported
+	default:
+		return status, fmt.Errorf("worker returned status %d", resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("failed to decode config status: %w", err)
+	}
+	return status, nil
+}
+
+// setVersion records the version a worker reported, so forwards of the
+// version it lost are no longer skipped
+func (m *Manager) setVersion(name string, version int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if status, ok := m.status[name]; ok {
+		status.Version = version
+	}
+}
@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statefulWorker applies configs like the worker service and can be restarted
+type statefulWorker struct {
+	mu     sync.Mutex
+	status models.WorkerConfigStatus
+	posts  int
+}
+
+func (w *statefulWorker) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if r.Method == http.MethodGet {
+		json.NewEncoder(rw).Encode(w.status)
+		return
+	}
+
+	var config models.WorkerConfig
+	json.NewDecoder(r.Body).Decode(&config)
+	version, _ := strconv.ParseInt(r.Header.Get(models.ConfigVersionHeader), 10, 64)
+	hash, _ := models.ContentHash(config)
+	w.status = models.WorkerConfigStatus{HasConfig: true, Version: version, ContentHash: hash}
+	w.posts++
+	rw.WriteHeader(http.StatusOK)
+}
+
+func (w *statefulWorker) restart() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status = models.WorkerConfigStatus{}
+}
+
+func TestReconcileRepairsRestartedWorker(t *testing.T) {
+	healthy, restarted := &statefulWorker{}, &statefulWorker{}
+	healthyServer, restartedServer := httptest.NewServer(healthy), httptest.NewServer(restarted)
+	defer healthyServer.Close()
+	defer restartedServer.Close()
+
+	manager := NewMultiManager([]Target{
+		{Name: "healthy", URL: healthyServer.URL},
+		{Name: "restarted", URL: restartedServer.URL},
+	}, nil)
+	manager.SetRetry(1, time.Millisecond)
+
+	// Nothing to reconcile before a config was forwarded
+	assert.Empty(t, manager.Reconcile(context.Background()))
+
+	_, err := manager.Forward(4, models.WorkerConfig{URL: "https://ip.me"}, "")
+	require.NoError(t, err)
+	assert.Empty(t, manager.Reconcile(context.Background()))
+
+	restarted.restart()
+	results := manager.Reconcile(context.Background())
+	assert.Equal(t, []models.WorkerResult{{Worker: "restarted", Success: true, Attempts: 1}}, results)
+	assert.Equal(t, int64(4), restarted.status.Version)
+	assert.Equal(t, 1, healthy.posts)
+	assert.Equal(t, 2, restarted.posts)
+
+	assert.Empty(t, manager.Reconcile(context.Background()))
+}
+
+func TestReconcileSkipsWorkersWithoutStatus(t *testing.T) {
+	posts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		posts++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	manager := NewManager(server.URL, nil)
+	_, err := manager.Forward(2, models.WorkerConfig{URL: "https://ip.me"}, "")
+	require.NoError(t, err)
+
+	assert.Empty(t, manager.Reconcile(context.Background()))
+	assert.Equal(t, 1, posts)
+}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/mtls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue writes a leaf certificate and key signed by the CA and returns their paths
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func TestForwardConfigMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, ca.pem, 0600))
+
+	serverCert, serverKey := ca.issue(t, dir, "worker", 2)
+	serverReloader, err := mtls.NewReloader(mtls.Config{
+		CertFile:   serverCert,
+		KeyFile:    serverKey,
+		CAFile:     caFile,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	})
+	require.NoError(t, err)
+
+	var identity string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity = mtls.Identity(r.TLS)
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = serverReloader.ServerTLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	clientCert, clientKey := ca.issue(t, dir, "agent-1", 3)
+	clientReloader, err := mtls.NewReloader(mtls.Config{
+		CertFile:   clientCert,
+		KeyFile:    clientKey,
+		CAFile:     caFile,
+		ServerName: "localhost",
+	})
+	require.NoError(t, err)
+
+	manager := NewManager(server.URL, clientReloader.ClientTLSConfig())
+	require.NoError(t, manager.ForwardConfig(models.WorkerConfig{URL: "https://example.com"}))
+	assert.Equal(t, "agent-1", identity)
+
+	// Rotate the client certificate in place and reload it
+	rotatedCert, rotatedKey := ca.issue(t, dir, "agent-2", 4)
+	require.NoError(t, os.Rename(rotatedCert, clientCert))
+	require.NoError(t, os.Rename(rotatedKey, clientKey))
+	require.NoError(t, clientReloader.Reload())
+
+	manager = NewManager(server.URL, clientReloader.ClientTLSConfig())
+	require.NoError(t, manager.ForwardConfig(models.WorkerConfig{URL: "https://example.com"}))
+	assert.Equal(t, "agent-2", identity)
+
+	// Without a client certificate the worker rejects the handshake
+	anonymous, err := mtls.NewReloader(mtls.Config{CAFile: caFile, ServerName: "localhost"})
+	require.NoError(t, err)
+	manager = NewManager(server.URL, anonymous.ClientTLSConfig())
+	assert.Error(t, manager.ForwardConfig(models.WorkerConfig{URL: "https://example.com"}))
+
+	// Client certificates are never verified against the system roots
+	_, err = mtls.NewReloader(mtls.Config{CertFile: serverCert, KeyFile: serverKey, ClientAuth: tls.VerifyClientCertIfGiven})
+	assert.Error(t, err)
+}
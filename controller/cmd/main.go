@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
@@ -12,9 +13,15 @@ import (
 	"time"
 
 	"github.com/doniyusdinar/config-management/controller/internal/api"
+	"github.com/doniyusdinar/config-management/controller/internal/ca"
 	"github.com/doniyusdinar/config-management/controller/internal/database"
+	"github.com/doniyusdinar/config-management/controller/internal/secrets"
+	"github.com/doniyusdinar/config-management/pkg/kafka"
 	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/mtls"
 	"github.com/doniyusdinar/config-management/pkg/redis"
+	"github.com/doniyusdinar/config-management/pkg/signing"
 	natspkg "github.com/doniyusdinar/config-management/pkg/nats"
 
 	_ "github.com/doniyusdinar/config-management/controller/docs"
@@ -37,6 +44,10 @@ import (
 
 // @securityDefinitions.basic BasicAuth
 
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+
 func main() {
 	logLevel := getEnv("LOG_LEVEL", "info")
 	logger.SetLevel(logLevel)
@@ -53,20 +64,30 @@ func main() {
 
 	logger.Log.Info("Database initialized successfully")
 
-	// Initialize Redis client (optional based on distribution strategy)
+	// Encrypt secret configuration values at rest when a key-encryption key is configured
+	if kek := getEnv("SECRETS_KEK", ""); kek != "" {
+		key, err := secrets.ParseKey(kek)
+		if err != nil {
+			logger.Log.Fatalf("Invalid SECRETS_KEK: %v", err)
+		}
+		cipher, err := secrets.NewCipher(key)
+		if err != nil {
+			logger.Log.Fatalf("Invalid SECRETS_KEK: %v", err)
+		}
+		db.SetSecretsCipher(cipher)
+		logger.Log.Info("Secret configuration values will be encrypted at rest")
+	}
+
+	// Initialize every requested distribution transport; several can run side by
+	// side, e.g. DISTRIBUTION_STRATEGY=REDIS,NATS while agents migrate. Agents are
+	// offered the transports in the listed order.
 	var redisClient *redis.Client
 	var natsClient *natspkg.Client
-	distributionStrategy := getEnv("DISTRIBUTION_STRATEGY", "POLLER")
-	
-	if distributionStrategy == "REDIS" {
-		redisConfig := redis.Config{
-			Address:  getEnv("REDIS_ADDRESS", "localhost:6379"),
-			Password: getEnv("REDIS_PASSWORD", ""),
-			DB:       getEnvInt("REDIS_DB", 0),
-			Enabled:  true,
-		}
+	var kafkaProducer *kafka.Producer
+	strategies := parseStrategies(getEnv("DISTRIBUTION_STRATEGY", "POLLER"))
 
-		redisClient, err = redis.NewClient(redisConfig)
+	if strategies["REDIS"] > 0 {
+		redisClient, err = connectRedis()
 		if err != nil {
 			logger.Log.Warnf("Failed to connect to Redis, Redis distribution disabled: %v", err)
 			redisClient = nil
@@ -74,23 +95,9 @@ func main() {
 			defer redisClient.Close()
 			logger.Log.Info("Redis client initialized successfully for distribution")
 		}
-	} else if distributionStrategy == "NATS" {
-		natsConfig := natspkg.Config{
-			URLs:            strings.Split(getEnv("NATS_URL", "nats://localhost:4222"), ","),
-			Username:        getEnv("NATS_USERNAME", ""),
-			Password:        getEnv("NATS_PASSWORD", ""),
-			Token:           getEnv("NATS_TOKEN", ""),
-			TLSEnabled:      getEnvBool("NATS_TLS_ENABLED", false),
-			MaxReconnect:    10,
-			ReconnectWait:   2 * time.Second,
-			ConnectionName:  "controller-publisher",
-			Subject:         getEnv("NATS_SUBJECT", "config.worker.update"),
-			QueueGroup:      getEnv("NATS_QUEUE_GROUP", "config-workers"),
-			Enabled:         true,
-		}
-
-		natsClient = natspkg.NewClient(natsConfig)
-		err = natsClient.Connect()
+	}
+	if strategies["NATS"] > 0 {
+		natsClient, err = connectNats()
 		if err != nil {
 			logger.Log.Warnf("Failed to connect to NATS, NATS distribution disabled: %v", err)
 			natsClient = nil
@@ -98,11 +105,93 @@ func main() {
 			defer natsClient.Close()
 			logger.Log.Info("NATS client initialized successfully for distribution")
 		}
-	} else {
-		logger.Log.Infof("Distribution strategy: %s (Redis/NATS not needed)", distributionStrategy)
+	}
+	if strategies["KAFKA"] > 0 {
+		kafkaProducer, err = connectKafka()
+		if err != nil {
+			logger.Log.Warnf("Failed to connect to Kafka, Kafka distribution disabled: %v", err)
+			kafkaProducer = nil
+		} else {
+			defer kafkaProducer.Close()
+			logger.Log.Info("Kafka producer initialized successfully for distribution")
+		}
+	}
+	if strategies["REDIS"] == 0 && strategies["NATS"] == 0 && strategies["KAFKA"] == 0 {
+		logger.Log.Infof("Distribution strategy: %s (Redis/NATS/Kafka not needed)", getEnv("DISTRIBUTION_STRATEGY", "POLLER"))
 	}
 
 	handler := api.NewHandler(db, redisClient, natsClient)
+	if kafkaProducer != nil {
+		handler.EnableKafka(kafkaProducer)
+	}
+
+	// Advertise the transports to agents; endpoints can differ from the ones the
+	// controller uses, e.g. behind NAT or in another network
+	if strategies["POLLER"] > 0 {
+		handler.AdvertiseTransport(models.TransportOffer{Strategy: "POLLER", Priority: strategies["POLLER"]})
+	}
+	if redisClient != nil {
+		handler.AdvertiseTransport(models.TransportOffer{
+			Strategy:  "REDIS",
+			Priority:  strategies["REDIS"],
+			Endpoints: []string{getEnv("REDIS_ADVERTISE_ADDRESS", getEnv("REDIS_ADDRESS", "localhost:6379"))},
+			Channel:   redisClient.Channel(),
+		})
+	}
+	if natsClient != nil {
+		handler.AdvertiseTransport(models.TransportOffer{
+			Strategy:  "NATS",
+			Priority:  strategies["NATS"],
+			Endpoints: strings.Split(getEnv("NATS_ADVERTISE_URL", getEnv("NATS_URL", "nats://localhost:4222")), ","),
+			Subject:   natsClient.Config().Subject,
+		})
+	}
+	if kafkaProducer != nil {
+		handler.AdvertiseTransport(models.TransportOffer{
+			Strategy:  "KAFKA",
+			Priority:  strategies["KAFKA"],
+			Endpoints: strings.Split(getEnv("KAFKA_ADVERTISE_BROKERS", getEnv("KAFKA_BROKERS", "localhost:9092")), ","),
+			Topic:     getEnv("KAFKA_TOPIC", kafka.DefaultTopic),
+		})
+	}
+
+	// Serve config and registration requests for NATS-only agents
+	if natsClient != nil {
+		if err := handler.StartNatsResponder(natsClient.Config().QueueGroup); err != nil {
+			logger.Log.Warnf("Failed to start NATS responder: %v", err)
+		}
+	}
+
+	// Record push-mode agents' acknowledgements of each version
+	if err := handler.StartAckCollector(); err != nil {
+		logger.Log.Warnf("Failed to start config ack collector: %v", err)
+	}
+
+	// Sign configuration versions so agents can reject tampered payloads
+	signingPublicKeyFile := getEnv("SIGNING_PUBLIC_KEY_FILE", "./signing.pub")
+	signer, err := signing.LoadOrCreateSigner(getEnv("SIGNING_KEY_FILE", "./signing.key"), signingPublicKeyFile)
+	if err != nil {
+		logger.Log.Fatalf("Failed to load config signing key: %v", err)
+	}
+	handler.EnableSigning(signer)
+	logger.Log.Infof("Configuration signing enabled, agents should pin %s", signingPublicKeyFile)
+
+	// Run the internal CA for agent enrollment if enabled
+	caCertFile := getEnv("CA_CERT_FILE", "./ca.crt")
+	if getEnvBool("CA_ENABLED", false) {
+		authority, err := ca.LoadOrCreate(caCertFile, getEnv("CA_KEY_FILE", "./ca.key"))
+		if err != nil {
+			logger.Log.Fatalf("Failed to initialize certificate authority: %v", err)
+		}
+		handler.EnableCA(authority, time.Duration(getEnvInt("CA_CERT_TTL_HOURS", 24))*time.Hour)
+		logger.Log.Infof("Internal certificate authority enabled (%s)", caCertFile)
+	}
+
+	// Deliver queued configuration versions to every transport, retrying until they succeed
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	defer stopOutbox()
+	go handler.StartOutbox(outboxCtx)
+
 	router := api.SetupRouter(handler)
 
 	srv := &http.Server{
@@ -110,10 +199,48 @@ func main() {
 		Handler: router,
 	}
 
+	// Enable TLS with client certificate verification if a certificate is configured
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+
+	tlsConfig := mtls.Config{
+		CertFile:       getEnv("TLS_CERT_FILE", ""),
+		KeyFile:        getEnv("TLS_KEY_FILE", ""),
+		CAFile:         getEnv("TLS_CLIENT_CA_FILE", ""),
+		ReloadInterval: time.Duration(getEnvInt("TLS_RELOAD_INTERVAL", 30)) * time.Second,
+	}
+	if tlsConfig.CAFile == "" && getEnvBool("CA_ENABLED", false) {
+		// Trust agent certificates issued by the internal CA
+		tlsConfig.CAFile = caCertFile
+	}
+	if tlsConfig.CertFile != "" {
+		tlsConfig.ClientAuth, err = mtls.ParseClientAuth(getEnv("TLS_CLIENT_AUTH", "verify-if-given"))
+		if err != nil {
+			logger.Log.Fatalf("Invalid TLS_CLIENT_AUTH: %v", err)
+		}
+		if tlsConfig.ClientAuth != tls.NoClientCert && tlsConfig.CAFile == "" {
+			logger.Log.Fatalf("TLS_CLIENT_CA_FILE (or CA_ENABLED) is required unless TLS_CLIENT_AUTH=none")
+		}
+
+		reloader, err := mtls.NewReloader(tlsConfig)
+		if err != nil {
+			logger.Log.Fatalf("Failed to load TLS certificates: %v", err)
+		}
+		srv.TLSConfig = reloader.ServerTLSConfig()
+		go reloader.Start(reloadCtx)
+		logger.Log.Info("TLS enabled for controller listener")
+	}
+
 	go func() {
 		logger.Log.Infof("Controller listening on port %s", port)
 		logger.Log.Infof("Swagger docs available at http://localhost:%s/swagger/index.html", port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -134,6 +261,79 @@ func main() {
 	logger.Log.Info("Server exited")
 }
 
+// parseStrategies splits a comma-separated DISTRIBUTION_STRATEGY into the
+// enabled strategies and their priority; the first listed has the highest.
+// Strategies that are not listed have priority 0. HTTP polling is always served.
+func parseStrategies(value string) map[string]int {
+	names := strings.Split(value, ",")
+	strategies := make(map[string]int)
+	for i, strategy := range names {
+		strategy = strings.ToUpper(strings.TrimSpace(strategy))
+		switch strategy {
+		case "":
+		case "POLLER", "REDIS", "NATS", "KAFKA":
+			if strategies[strategy] == 0 {
+				strategies[strategy] = len(names) - i
+			}
+		default:
+			logger.Log.Warnf("Ignoring unknown distribution strategy %q", strategy)
+		}
+	}
+	return strategies
+}
+
+func connectRedis() (*redis.Client, error) {
+	return redis.NewClient(redis.Config{
+		Address:  getEnv("REDIS_ADDRESS", "localhost:6379"),
+		Password: getEnv("REDIS_PASSWORD", ""),
+		DB:       getEnvInt("REDIS_DB", 0),
+		Channel:  getEnv("REDIS_CHANNEL", redis.GlobalConfigChannel),
+		Enabled:  true,
+	})
+}
+
+func connectNats() (*natspkg.Client, error) {
+	client := natspkg.NewClient(natspkg.Config{
+		URLs:           strings.Split(getEnv("NATS_URL", "nats://localhost:4222"), ","),
+		Username:       getEnv("NATS_USERNAME", ""),
+		Password:       getEnv("NATS_PASSWORD", ""),
+		Token:          getEnv("NATS_TOKEN", ""),
+		TLSEnabled:     getEnvBool("NATS_TLS_ENABLED", false),
+		MaxReconnect:   10,
+		ReconnectWait:  2 * time.Second,
+		ConnectionName: "controller-publisher",
+		Subject:        getEnv("NATS_SUBJECT", natspkg.DefaultSubject),
+		QueueGroup:     getEnv("NATS_QUEUE_GROUP", "config-workers"),
+		Enabled:        true,
+	})
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// connectKafka creates the producer and the compacted config topic
+func connectKafka() (*kafka.Producer, error) {
+	config := kafka.Config{
+		Brokers:  strings.Split(getEnv("KAFKA_BROKERS", "localhost:9092"), ","),
+		Topic:    getEnv("KAFKA_TOPIC", kafka.DefaultTopic),
+		ClientID: "controller-publisher",
+		Enabled:  true,
+	}
+
+	producer, err := kafka.NewProducer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	partitions := int32(getEnvInt("KAFKA_PARTITIONS", 1))
+	replication := int16(getEnvInt("KAFKA_REPLICATION_FACTOR", 1))
+	if err := producer.EnsureTopic(partitions, replication); err != nil {
+		logger.Log.Warnf("Failed to create compacted Kafka topic %s: %v", config.Topic, err)
+	}
+	return producer, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/doniyusdinar/config-management/controller/internal/database"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	natspkg "github.com/doniyusdinar/config-management/pkg/nats"
+	"github.com/doniyusdinar/config-management/pkg/redis"
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+)
+
+// errUnknownAgent rejects acks for agent IDs that are not registered
+var errUnknownAgent = errors.New("unknown agent")
+
+// StartAckCollector stores the acknowledgements push-mode agents publish on
+// Redis and NATS. NATS acks are load-balanced across controller replicas with
+// the queue group; Redis acks reach every replica and are stored idempotently.
+//
+// These channels are trusted as far as the broker credentials go: any client
+// allowed to publish on them can report acks for any registered agent. Acks
+// for agent IDs the controller never registered are dropped.
+func (h *Handler) StartAckCollector() error {
+	if h.natsClient != nil {
+		queueGroup := h.natsClient.Config().QueueGroup
+		_, err := h.natsClient.QueueSubscribe(natspkg.AckSubject, queueGroup, func(msg *nats.Msg) {
+			var ack models.ConfigAck
+			if err := json.Unmarshal(msg.Data, &ack); err != nil {
+				logger.Log.Errorf("Rejected ack message from NATS: %v", err)
+				return
+			}
+			if err := h.recordAck(ack); err != nil {
+				logger.Log.Warnf("Rejected ack from NATS: %v", err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to NATS subject %s: %w", natspkg.AckSubject, err)
+		}
+		logger.Log.Infof("Collecting config acks on NATS subject: %s", natspkg.AckSubject)
+	}
+
+	if h.redisClient != nil {
+		acks, err := h.redisClient.SubscribeToAcks()
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to Redis acks: %w", err)
+		}
+		go func() {
+			for ack := range acks {
+				if err := h.recordAck(ack); err != nil {
+					logger.Log.Warnf("Rejected ack from Redis: %v", err)
+				}
+			}
+		}()
+		logger.Log.Infof("Collecting config acks on Redis channel: %s", redis.AckChannel)
+	}
+
+	return nil
+}
+
+// recordAck stores an ack from a registered agent and counts it as activity
+func (h *Handler) recordAck(ack models.ConfigAck) error {
+	if ack.AgentID == "" || ack.Version <= 0 {
+		return errors.New("ack without agent ID or version")
+	}
+
+	err := h.db.UpdateAgentPoll(ack.AgentID)
+	if errors.Is(err, database.ErrNotFound) {
+		return fmt.Errorf("%w %s", errUnknownAgent, ack.AgentID)
+	}
+	if err != nil {
+		logger.Log.Warnf("Failed to record activity for agent %s: %v", ack.AgentID, err)
+	}
+
+	ack.ReceivedAt = time.Now()
+	if err := h.db.RecordAck(&ack); err != nil {
+		logger.Log.Errorf("Failed to record ack from agent %s: %v", ack.AgentID, err)
+		return nil
+	}
+
+	if ack.Success {
+		logger.Log.Debugf("Agent %s applied config version %d in %dms", ack.AgentID, ack.Version, ack.LatencyMs)
+	} else {
+		logger.Log.Warnf("Agent %s failed to apply config version %d: %s", ack.AgentID, ack.Version, ack.Error)
+	}
+	return nil
+}
+
+// PostAck godoc
+// @Summary Acknowledge a configuration version
+// @Description Report the outcome of applying a configuration version, per worker. Used by agents without a Redis or NATS ack channel, such as polling agents.
+// @Tags agents
+// @Accept json
+// @Produce json
+// @Param request body models.ConfigAck true "Acknowledgement"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/acks [post]
+// @Security BasicAuth
+func (h *Handler) PostAck(c *gin.Context) {
+	var ack models.ConfigAck
+	if err := c.ShouldBindJSON(&ack); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if ack.AgentID == "" || ack.Version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "agent_id and version are required"})
+		return
+	}
+
+	// Agents authenticated by certificate can only acknowledge for themselves
+	if certAgentID := c.GetString(contextAgentIDKey); certAgentID != "" && certAgentID != ack.AgentID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Ack agent_id does not match the client certificate"})
+		return
+	}
+
+	if err := h.recordAck(ack); errors.Is(err, errUnknownAgent) {
+		c.JSON(http.StatusNotFound, gin.H{"error": models.UnknownAgentError})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"status": "accepted"})
+}
+
+// GetConfigDelivery godoc
+// @Summary Configuration delivery status
+// @Description Show which recently active agents acknowledged a configuration version, with the outcome per worker (viewer or above)
+// @Tags config
+// @Produce json
+// @Param version path int true "Configuration version"
+// @Success 200 {object} models.DeliveryStatus
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/config/versions/{version}/delivery [get]
+// @Security BasicAuth
+// @Security BearerAuth
+func (h *Handler) GetConfigDelivery(c *gin.Context) {
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	status, err := h.db.GetDeliveryStatus(version, time.Now().Add(-h.agentActiveWindow))
+	if err != nil {
+		logger.Log.Errorf("Failed to get delivery status for version %d: %v", version, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get delivery status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
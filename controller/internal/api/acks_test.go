@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetConfigDelivery(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	router.GET("/config/versions/:version/delivery", handler.GetConfigDelivery)
+
+	require.NoError(t, handler.db.RegisterAgent(&models.Agent{ID: "agent-1", RegisteredAt: time.Now()}))
+	require.NoError(t, handler.db.RegisterAgent(&models.Agent{ID: "agent-2", RegisteredAt: time.Now()}))
+
+	require.NoError(t, handler.recordAck(models.ConfigAck{AgentID: "agent-1", Version: 1, Success: true, LatencyMs: 12, Timestamp: time.Now()}))
+	assert.Error(t, handler.recordAck(models.ConfigAck{Version: 1, Success: true})) // no agent ID, ignored
+	// Acks on Redis and NATS are not authenticated; unregistered agents are ignored
+	assert.ErrorIs(t, handler.recordAck(models.ConfigAck{AgentID: "agent-9", Version: 1, Success: true}), errUnknownAgent)
+
+	req := httptest.NewRequest(http.MethodGet, "/config/versions/1/delivery", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var status models.DeliveryStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, 2, status.ExpectedAgents)
+	assert.Equal(t, 1, status.Acked)
+	assert.Equal(t, []string{"agent-2"}, status.Pending)
+	assert.False(t, status.Complete)
+	require.Len(t, status.Acks, 1)
+	assert.Equal(t, int64(12), status.Acks[0].LatencyMs)
+
+	req = httptest.NewRequest(http.MethodGet, "/config/versions/abc/delivery", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPostAckWithWorkerResults(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	router.POST("/acks", handler.AgentAuthMiddleware(), handler.PostAck)
+	require.NoError(t, handler.db.RegisterAgent(&models.Agent{ID: "agent-1", RegisteredAt: time.Now()}))
+
+	ack := models.ConfigAck{
+		AgentID:   "agent-1",
+		Version:   1,
+		Error:     "worker batch: worker returned status 503",
+		Timestamp: time.Now(),
+		Workers: []models.WorkerResult{
+			{Worker: "api", Success: true, Attempts: 1},
+			{Worker: "batch", Error: "worker returned status 503", Attempts: 3},
+		},
+	}
+	body, _ := json.Marshal(ack)
+
+	req := httptest.NewRequest(http.MethodPost, "/acks", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("agent", "secret123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	status, err := handler.db.GetDeliveryStatus(1, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, status.Acks, 1)
+	assert.Equal(t, 1, status.Failed)
+	assert.Equal(t, ack.Workers, status.Acks[0].Workers)
+
+	// Acks need credentials and an agent and version
+	req = httptest.NewRequest(http.MethodPost, "/acks", bytes.NewBuffer(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/acks", bytes.NewBufferString(`{"version": 1}`))
+	req.SetBasicAuth("agent", "secret123")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Acks for agents the controller does not know are refused
+	req = httptest.NewRequest(http.MethodPost, "/acks", bytes.NewBufferString(`{"agent_id": "agent-9", "version": 1}`))
+	req.SetBasicAuth("agent", "secret123")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
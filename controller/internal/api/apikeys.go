@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/doniyusdinar/config-management/controller/internal/database"
+	"github.com/doniyusdinar/config-management/pkg/auth"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAPIKeyExpiryDays = 90
+	maxAPIKeyExpiryDays     = 365
+)
+
+// ListAPIKeys godoc
+// @Summary List API keys
+// @Description Get all API keys with their scopes, expiry and last use (admin only)
+// @Tags apikeys
+// @Produce json
+// @Success 200 {array} models.APIKey
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/apikeys [get]
+// @Security BasicAuth
+func (h *Handler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.db.ListAPIKeys()
+	if err != nil {
+		logger.Log.Errorf("Failed to list API keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// CreateAPIKey godoc
+// @Summary Create API key
+// @Description Issue a scoped API key for automation; the key is only shown in this response (admin only)
+// @Tags apikeys
+// @Accept json
+// @Produce json
+// @Param key body models.CreateAPIKeyRequest true "Key name, scopes and expiry"
+// @Success 201 {object} models.CreateAPIKeyResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/apikeys [post]
+// @Security BasicAuth
+func (h *Handler) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Log.Errorf("Invalid request body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !scope.IsValid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope: " + string(scope)})
+			return
+		}
+	}
+
+	expiresInDays := req.ExpiresInDays
+	if expiresInDays == 0 {
+		expiresInDays = defaultAPIKeyExpiryDays
+	}
+	if expiresInDays < 0 || expiresInDays > maxAPIKeyExpiryDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_days must be between 1 and 365"})
+		return
+	}
+
+	token, err := auth.GenerateAPIKey()
+	if err != nil {
+		logger.Log.Errorf("Failed to generate API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	now := time.Now()
+	key := models.APIKey{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		KeyHash:   auth.HashAPIKey(token),
+		Scopes:    req.Scopes,
+		ExpiresAt: now.Add(time.Duration(expiresInDays) * 24 * time.Hour),
+		CreatedBy: c.GetString(contextUsernameKey),
+		CreatedAt: now,
+	}
+
+	if err := h.db.CreateAPIKey(&key); err != nil {
+		logger.Log.Errorf("Failed to create API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	logger.Log.Infof("API key %s (%s) created by %s", key.ID, key.Name, key.CreatedBy)
+	c.JSON(http.StatusCreated, models.CreateAPIKeyResponse{APIKey: key, Key: token})
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke API key
+// @Description Revoke an API key so it can no longer authenticate (admin only)
+// @Tags apikeys
+// @Produce json
+// @Param id path string true "API key ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/apikeys/{id} [delete]
+// @Security BasicAuth
+func (h *Handler) RevokeAPIKey(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.db.RevokeAPIKey(id); err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		logger.Log.Errorf("Failed to revoke API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	logger.Log.Infof("API key %s revoked by %s", id, c.GetString(contextUsernameKey))
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/auth"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestAPIKey(t *testing.T, router *gin.Engine, scopes ...models.APIKeyScope) models.CreateAPIKeyResponse {
+	body, _ := json.Marshal(models.CreateAPIKeyRequest{Name: "ci", Scopes: scopes})
+	req := httptest.NewRequest(http.MethodPost, "/apikeys", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("admin", "admin123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var response models.CreateAPIKeyResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+func setupAPIKeyRoutes(handler *Handler, router *gin.Engine) {
+	router.POST("/apikeys", handler.AdminAuthMiddleware(), handler.CreateAPIKey)
+	router.DELETE("/apikeys/:id", handler.AdminAuthMiddleware(), handler.RevokeAPIKey)
+	router.POST("/config", handler.ScopedAuthMiddleware(models.RoleAdmin, models.ScopeWriteConfig), handler.UpdateConfig)
+	router.GET("/agents", handler.ScopedAuthMiddleware(models.RoleViewer, models.ScopeReadAgents), handler.GetAgents)
+	router.GET("/users", handler.AdminAuthMiddleware(), handler.ListUsers)
+}
+
+func bearerRequest(router *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(models.WorkerConfig{URL: "https://ci.example.com"})
+	req := httptest.NewRequest(method, path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", auth.CreateBearerAuthHeader(token))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+	setupAPIKeyRoutes(handler, router)
+
+	key := createTestAPIKey(t, router, models.ScopeWriteConfig)
+	assert.NotEmpty(t, key.Key)
+	assert.WithinDuration(t, time.Now().Add(90*24*time.Hour), key.ExpiresAt, time.Minute)
+
+	assert.Equal(t, http.StatusOK, bearerRequest(router, http.MethodPost, "/config", key.Key).Code)
+	assert.Equal(t, http.StatusForbidden, bearerRequest(router, http.MethodGet, "/agents", key.Key).Code)
+	assert.Equal(t, http.StatusForbidden, bearerRequest(router, http.MethodGet, "/users", key.Key).Code)
+	assert.Equal(t, http.StatusUnauthorized, bearerRequest(router, http.MethodPost, "/config", "cmk_unknown").Code)
+
+	config, err := handler.db.GetConfigVersion(2)
+	require.NoError(t, err)
+	assert.Equal(t, "apikey:ci", config.CreatedBy)
+
+	stored, err := handler.db.GetAPIKeyByHash(auth.HashAPIKey(key.Key))
+	require.NoError(t, err)
+	assert.NotNil(t, stored.LastUsedAt)
+}
+
+func TestRevokedAndExpiredAPIKeys(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+	setupAPIKeyRoutes(handler, router)
+
+	key := createTestAPIKey(t, router, models.ScopeReadAgents)
+	assert.Equal(t, http.StatusOK, bearerRequest(router, http.MethodGet, "/agents", key.Key).Code)
+
+	req := httptest.NewRequest(http.MethodDelete, "/apikeys/"+key.ID, nil)
+	req.SetBasicAuth("admin", "admin123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusUnauthorized, bearerRequest(router, http.MethodGet, "/agents", key.Key).Code)
+
+	token, err := auth.GenerateAPIKey()
+	require.NoError(t, err)
+	require.NoError(t, handler.db.CreateAPIKey(&models.APIKey{
+		ID:        "expired",
+		Name:      "old",
+		KeyHash:   auth.HashAPIKey(token),
+		Scopes:    []models.APIKeyScope{models.ScopeReadAgents},
+		ExpiresAt: time.Now().Add(-time.Hour),
+		CreatedAt: time.Now().Add(-48 * time.Hour),
+	}))
+	assert.Equal(t, http.StatusUnauthorized, bearerRequest(router, http.MethodGet, "/agents", token).Code)
+}
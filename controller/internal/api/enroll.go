@@ -0,0 +1,331 @@
+package api
+
+import (
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"github.com/doniyusdinar/config-management/controller/internal/ca"
+	"github.com/doniyusdinar/config-management/controller/internal/database"
+	"github.com/doniyusdinar/config-management/pkg/auth"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultEnrollmentTokenHours = 24
+	crlValidity                 = time.Hour
+)
+
+// EnableCA lets the controller issue client certificates valid for certTTL to enrolling agents
+func (h *Handler) EnableCA(authority *ca.Authority, certTTL time.Duration) {
+	h.ca = authority
+	h.certTTL = certTTL
+}
+
+// CAEnabledMiddleware rejects certificate authority requests when no CA is configured
+func (h *Handler) CAEnabledMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.ca == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Certificate authority is not enabled"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// isRevoked reports whether a client certificate issued by the internal CA has been revoked
+func (h *Handler) isRevoked(cert *x509.Certificate) bool {
+	if h.ca == nil || cert == nil || !h.ca.IssuedBy(cert) {
+		return false
+	}
+
+	revoked, err := h.db.IsCertificateRevoked(ca.SerialString(cert))
+	if err != nil {
+		// Fail closed: a certificate we cannot check is not trusted
+		logger.Log.Errorf("Failed to check certificate revocation: %v", err)
+		return true
+	}
+	return revoked
+}
+
+// issueCertificate signs the CSR and records the issued certificate
+func (h *Handler) issueCertificate(c *gin.Context, csrPEM string, agentID string) {
+	csr, err := ca.ParseCSR([]byte(csrPEM))
+	if err != nil {
+		logger.Log.Warnf("Rejected CSR: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid CSR"})
+		return
+	}
+	if csr.Subject.CommonName != agentID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "CSR common name does not match agent ID"})
+		return
+	}
+
+	cert, certPEM, err := h.ca.Sign(csr, h.certTTL)
+	if err != nil {
+		logger.Log.Errorf("Failed to sign certificate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue certificate"})
+		return
+	}
+
+	issued := &models.IssuedCertificate{
+		Serial:   ca.SerialString(cert),
+		AgentID:  agentID,
+		IssuedAt: time.Now(),
+		NotAfter: cert.NotAfter,
+	}
+	if err := h.db.RecordCertificate(issued); err != nil {
+		logger.Log.Errorf("Failed to record certificate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue certificate"})
+		return
+	}
+
+	logger.Log.Infof("Issued certificate %s to agent %s, expires %s", issued.Serial, agentID, cert.NotAfter.Format(time.RFC3339))
+
+	c.JSON(http.StatusOK, models.EnrollResponse{
+		Certificate:   string(certPEM),
+		CACertificate: string(h.ca.CertificatePEM()),
+		Serial:        issued.Serial,
+		ExpiresAt:     cert.NotAfter,
+	})
+}
+
+// Enroll godoc
+// @Summary Enroll agent
+// @Description Issue a short-lived client certificate to a registered agent in exchange for a bootstrap token and a CSR whose common name is the agent ID
+// @Tags ca
+// @Accept json
+// @Produce json
+// @Param request body models.EnrollRequest true "Bootstrap token and PEM-encoded CSR"
+// @Success 200 {object} models.EnrollResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/enroll [post]
+func (h *Handler) Enroll(c *gin.Context) {
+	var req models.EnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" || req.CSR == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token and CSR are required"})
+		return
+	}
+
+	csr, err := ca.ParseCSR([]byte(req.CSR))
+	if err != nil {
+		logger.Log.Warnf("Rejected CSR: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid CSR"})
+		return
+	}
+
+	agentID := csr.Subject.CommonName
+	if _, err := h.db.GetAgent(agentID); err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Agent is not registered"})
+			return
+		}
+		logger.Log.Errorf("Failed to look up agent %s: %v", agentID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll agent"})
+		return
+	}
+
+	if err := h.db.ConsumeEnrollmentToken(auth.HashToken(req.Token)); err != nil {
+		if err == database.ErrNotFound {
+			logger.Log.Warnf("Rejected enrollment for agent %s: invalid bootstrap token", agentID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid bootstrap token"})
+			return
+		}
+		logger.Log.Errorf("Failed to consume bootstrap token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enroll agent"})
+		return
+	}
+
+	h.issueCertificate(c, req.CSR, agentID)
+}
+
+// RenewCertificate godoc
+// @Summary Renew agent certificate
+// @Description Issue a new client certificate to an agent authenticated by its current certificate
+// @Tags ca
+// @Accept json
+// @Produce json
+// @Param request body models.EnrollRequest true "PEM-encoded CSR"
+// @Success 200 {object} models.EnrollResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/enroll/renew [post]
+func (h *Handler) RenewCertificate(c *gin.Context) {
+	agentID := c.GetString(contextAgentIDKey)
+	if agentID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Client certificate required"})
+		return
+	}
+
+	var req models.EnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.CSR == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSR is required"})
+		return
+	}
+
+	h.issueCertificate(c, req.CSR, agentID)
+}
+
+// GetCACertificate godoc
+// @Summary Get CA certificate
+// @Description Get the PEM-encoded certificate of the internal CA
+// @Tags ca
+// @Produce application/x-pem-file
+// @Success 200 {string} string "CA certificate"
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/ca/certificate [get]
+func (h *Handler) GetCACertificate(c *gin.Context) {
+	c.Data(http.StatusOK, "application/x-pem-file", h.ca.CertificatePEM())
+}
+
+// GetRevocationList godoc
+// @Summary Get certificate revocation list
+// @Description Get a PEM-encoded CRL of revoked, unexpired agent certificates
+// @Tags ca
+// @Produce application/x-pem-file
+// @Success 200 {string} string "CRL"
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ca/crl [get]
+func (h *Handler) GetRevocationList(c *gin.Context) {
+	revoked, err := h.db.RevokedCertificates()
+	if err != nil {
+		logger.Log.Errorf("Failed to list revoked certificates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build revocation list"})
+		return
+	}
+
+	crl, err := h.ca.RevocationList(revoked, time.Now().Unix(), crlValidity)
+	if err != nil {
+		logger.Log.Errorf("Failed to build revocation list: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build revocation list"})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/x-pem-file", crl)
+}
+
+// ListCertificates godoc
+// @Summary List issued certificates
+// @Description Get all certificates issued by the internal CA (admin only)
+// @Tags ca
+// @Produce json
+// @Success 200 {array} models.IssuedCertificate
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ca/certificates [get]
+// @Security BasicAuth
+func (h *Handler) ListCertificates(c *gin.Context) {
+	certs, err := h.db.ListCertificates()
+	if err != nil {
+		logger.Log.Errorf("Failed to list certificates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list certificates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, certs)
+}
+
+// RevokeCertificate godoc
+// @Summary Revoke certificate
+// @Description Revoke an agent certificate issued by the internal CA (admin only)
+// @Tags ca
+// @Produce json
+// @Param serial path string true "Certificate serial (hex)"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ca/certificates/{serial}/revoke [post]
+// @Security BasicAuth
+func (h *Handler) RevokeCertificate(c *gin.Context) {
+	serial := c.Param("serial")
+
+	if err := h.db.RevokeCertificate(serial); err != nil {
+		if err == database.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Certificate not found"})
+			return
+		}
+		logger.Log.Errorf("Failed to revoke certificate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke certificate"})
+		return
+	}
+
+	logger.Log.Infof("Certificate %s revoked by %s", serial, c.GetString(contextUsernameKey))
+	c.JSON(http.StatusOK, gin.H{"message": "Certificate revoked"})
+}
+
+// CreateEnrollmentToken godoc
+// @Summary Create bootstrap token
+// @Description Issue a bootstrap token agents use to enroll; the token is only shown in this response (admin only)
+// @Tags ca
+// @Accept json
+// @Produce json
+// @Param token body models.CreateEnrollmentTokenRequest false "Expiry and number of uses"
+// @Success 201 {object} models.CreateEnrollmentTokenResponse
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/ca/tokens [post]
+// @Security BasicAuth
+func (h *Handler) CreateEnrollmentToken(c *gin.Context) {
+	var req models.CreateEnrollmentTokenRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	if req.ExpiresInHours == 0 {
+		req.ExpiresInHours = defaultEnrollmentTokenHours
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+	if req.ExpiresInHours < 0 || req.MaxUses < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_hours and max_uses must be positive"})
+		return
+	}
+
+	token, err := auth.GenerateToken(auth.EnrollmentTokenPrefix)
+	if err != nil {
+		logger.Log.Errorf("Failed to generate bootstrap token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create bootstrap token"})
+		return
+	}
+
+	now := time.Now()
+	record := models.EnrollmentToken{
+		ID:        uuid.New().String(),
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: now.Add(time.Duration(req.ExpiresInHours) * time.Hour),
+		MaxUses:   req.MaxUses,
+		CreatedBy: c.GetString(contextUsernameKey),
+		CreatedAt: now,
+	}
+	if err := h.db.CreateEnrollmentToken(&record); err != nil {
+		logger.Log.Errorf("Failed to create bootstrap token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create bootstrap token"})
+		return
+	}
+
+	logger.Log.Infof("Bootstrap token %s created by %s (%d uses)", record.ID, record.CreatedBy, record.MaxUses)
+	c.JSON(http.StatusCreated, models.CreateEnrollmentTokenResponse{EnrollmentToken: record, Token: token})
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/controller/internal/ca"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCSR(t *testing.T, commonName string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+func postEnroll(router *gin.Engine, path string, req models.EnrollRequest, state *tls.ConnectionState) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest(http.MethodPost, path, bytes.NewBuffer(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.TLS = state
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httpReq)
+	return w
+}
+
+func TestEnrollRenewAndRevoke(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	dir := t.TempDir()
+	authority, err := ca.LoadOrCreate(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"))
+	require.NoError(t, err)
+	handler.EnableCA(authority, time.Hour)
+
+	router.POST("/enroll", handler.CAEnabledMiddleware(), handler.Enroll)
+	router.POST("/enroll/renew", handler.CAEnabledMiddleware(), handler.AgentAuthMiddleware(), handler.RenewCertificate)
+	router.POST("/ca/tokens", handler.AdminAuthMiddleware(), handler.CreateEnrollmentToken)
+	router.POST("/ca/certificates/:serial/revoke", handler.AdminAuthMiddleware(), handler.RevokeCertificate)
+	router.GET("/ca/crl", handler.CAEnabledMiddleware(), handler.GetRevocationList)
+
+	require.NoError(t, handler.db.RegisterAgent(&models.Agent{ID: "agent-1", RegisteredAt: time.Now()}))
+
+	req := httptest.NewRequest(http.MethodPost, "/ca/tokens", nil)
+	req.SetBasicAuth("admin", "admin123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var token models.CreateEnrollmentTokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &token))
+
+	// Unregistered agents cannot enroll
+	w = postEnroll(router, "/enroll", models.EnrollRequest{Token: token.Token, CSR: newTestCSR(t, "agent-2")}, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = postEnroll(router, "/enroll", models.EnrollRequest{Token: token.Token, CSR: newTestCSR(t, "agent-1")}, nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var enrolled models.EnrollResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &enrolled))
+
+	block, _ := pem.Decode([]byte(enrolled.Certificate))
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", cert.Subject.CommonName)
+	assert.True(t, authority.IssuedBy(cert))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), cert.NotAfter, time.Minute)
+
+	// The single-use token is spent
+	w = postEnroll(router, "/enroll", models.EnrollRequest{Token: token.Token, CSR: newTestCSR(t, "agent-1")}, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Renewal authenticates with the current certificate and keeps the identity
+	state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	w = postEnroll(router, "/enroll/renew", models.EnrollRequest{CSR: newTestCSR(t, "agent-9")}, state)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = postEnroll(router, "/enroll/renew", models.EnrollRequest{CSR: newTestCSR(t, "agent-1")}, state)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/ca/certificates/"+enrolled.Serial+"/revoke", nil)
+	req.SetBasicAuth("admin", "admin123")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	w = postEnroll(router, "/enroll/renew", models.EnrollRequest{CSR: newTestCSR(t, "agent-1")}, state)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/ca/crl", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	block, _ = pem.Decode(w.Body.Bytes())
+	require.NotNil(t, block)
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	require.NoError(t, err)
+	require.NoError(t, crl.CheckSignatureFrom(authority.Certificate()))
+	require.Len(t, crl.RevokedCertificateEntries, 1)
+	assert.Equal(t, cert.SerialNumber, crl.RevokedCertificateEntries[0].SerialNumber)
+}
+
+func TestEnrollDisabled(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	router.POST("/enroll", handler.CAEnabledMiddleware(), handler.Enroll)
+
+	w := postEnroll(router, "/enroll", models.EnrollRequest{Token: "x", CSR: "y"}, nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
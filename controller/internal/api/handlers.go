@@ -1,43 +1,129 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
+	"time"
 
+	"github.com/doniyusdinar/config-management/controller/internal/ca"
 	"github.com/doniyusdinar/config-management/controller/internal/database"
+	"github.com/doniyusdinar/config-management/controller/internal/outbox"
+	"github.com/doniyusdinar/config-management/controller/internal/secrets"
 	"github.com/doniyusdinar/config-management/pkg/auth"
+	"github.com/doniyusdinar/config-management/pkg/kafka"
 	"github.com/doniyusdinar/config-management/pkg/logger"
 	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/mtls"
 	"github.com/doniyusdinar/config-management/pkg/redis"
+	"github.com/doniyusdinar/config-management/pkg/signing"
 	natspkg "github.com/doniyusdinar/config-management/pkg/nats"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// Context keys set by the admin auth middleware
+const (
+	contextUsernameKey = "username"
+	contextRoleKey     = "role"
+	contextAPIKeyKey   = "api_key_id"
+	contextAgentIDKey  = "agent_id"
+)
+
 type Handler struct {
 	db            *database.DB
 	redisClient   *redis.Client
 	natsClient    *natspkg.Client
 	agentUsername string
 	agentPassword string
-	adminUsername string
-	adminPassword string
 	pollInterval  int
+	controllerID  string // origin recorded in published config envelopes
+
+	// Agents silent for longer are left out of delivery status
+	agentActiveWindow time.Duration
+
+	// Internal certificate authority for agent enrollment (optional)
+	ca      *ca.Authority
+	certTTL time.Duration
+
+	// Signs every configuration version handed to agents (optional)
+	signer *signing.Signer
+
+	// Fans configuration versions out to every enabled transport with retries
+	outbox        *outbox.Dispatcher
+	rebroadcaster *outbox.Rebroadcaster
+
+	// Distribution strategies advertised to agents at registration
+	offers []models.TransportOffer
 }
 
 func NewHandler(db *database.DB, redisClient *redis.Client, natsClient *natspkg.Client) *Handler {
-	return &Handler{
+	h := &Handler{
 		db:            db,
 		redisClient:   redisClient,
 		natsClient:    natsClient,
 		agentUsername: getEnv("AGENT_USERNAME", "agent"),
 		agentPassword: getEnv("AGENT_PASSWORD", "secret123"),
-		adminUsername: getEnv("ADMIN_USERNAME", "admin"),
-		adminPassword: getEnv("ADMIN_PASSWORD", "admin123"),
 		pollInterval:  getEnvInt("DEFAULT_POLL_INTERVAL", 30),
+		controllerID:  getEnv("CONTROLLER_ID", hostname()),
+
+		agentActiveWindow: time.Duration(getEnvInt("AGENT_ACTIVE_WINDOW", 900)) * time.Second,
+	}
+
+	outboxInterval := time.Duration(getEnvInt("OUTBOX_INTERVAL", 5)) * time.Second
+	h.outbox = outbox.NewDispatcher(db, h.buildEnvelope,
+		outboxInterval,
+		time.Second,
+		time.Duration(getEnvInt("OUTBOX_MAX_BACKOFF", 300))*time.Second)
+	h.rebroadcaster = outbox.NewRebroadcaster(db, h.outbox,
+		time.Duration(getEnvInt("REBROADCAST_INTERVAL", 300))*time.Second,
+		outboxInterval)
+	if redisClient != nil {
+		h.outbox.Register(outbox.TransportRedis, &redisPublisher{client: redisClient})
+	}
+	if natsClient != nil {
+		h.outbox.Register(outbox.TransportNats, &natsPublisher{client: natsClient})
+	}
+
+	if err := h.bootstrapAdmin(getEnv("ADMIN_USERNAME", "admin"), getEnv("ADMIN_PASSWORD", "admin123")); err != nil {
+		logger.Log.Errorf("Failed to bootstrap admin account: %v", err)
+	}
+
+	return h
+}
+
+// EnableSigning makes the controller sign every configuration version it serves or publishes
+func (h *Handler) EnableSigning(signer *signing.Signer) {
+	h.signer = signer
+}
+
+// bootstrapAdmin creates the initial admin account from the environment
+// when no accounts exist yet
+func (h *Handler) bootstrapAdmin(username, password string) error {
+	count, err := h.db.CountUsers("")
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.CreateUser(&models.User{Username: username, PasswordHash: hash, Role: models.RoleAdmin}); err != nil {
+		return err
 	}
+
+	logger.Log.Infof("Bootstrapped admin account: %s", username)
+	return nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -56,9 +142,29 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
-// AgentAuthMiddleware validates agent credentials
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "controller"
+	}
+	return name
+}
+
+// AgentAuthMiddleware validates agent credentials. A verified client certificate
+// authenticates the agent on its own and its identity becomes the agent ID.
 func (h *Handler) AgentAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if agentID := mtls.Identity(c.Request.TLS); agentID != "" {
+			if h.isRevoked(mtls.PeerCertificate(c.Request.TLS)) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Certificate revoked"})
+				c.Abort()
+				return
+			}
+			c.Set(contextAgentIDKey, agentID)
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if !auth.ValidateBasicAuth(authHeader, h.agentUsername, h.agentPassword) {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
@@ -69,19 +175,89 @@ func (h *Handler) AgentAuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-// AdminAuthMiddleware validates admin credentials
+// AdminAuthMiddleware validates credentials of an account with the admin role
 func (h *Handler) AdminAuthMiddleware() gin.HandlerFunc {
+	return h.RoleAuthMiddleware(models.RoleAdmin)
+}
+
+// RoleAuthMiddleware validates account credentials and requires at least the given role
+func (h *Handler) RoleAuthMiddleware(required models.Role) gin.HandlerFunc {
+	return h.ScopedAuthMiddleware(required, "")
+}
+
+// ScopedAuthMiddleware accepts either Basic credentials of an account with at least
+// the given role, or a Bearer API key granting the given scope. An empty scope
+// restricts the route to account credentials.
+func (h *Handler) ScopedAuthMiddleware(required models.Role, scope models.APIKeyScope) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
-		if !auth.ValidateBasicAuth(authHeader, h.adminUsername, h.adminPassword) {
+		if token, ok := auth.ParseBearerToken(authHeader); ok {
+			h.authenticateAPIKey(c, token, scope)
+			return
+		}
+
+		username, password, ok := auth.ParseBasicAuth(authHeader)
+		if !ok {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			c.Abort()
 			return
 		}
+
+		user, err := h.db.GetUser(username)
+		if err != nil && err != database.ErrNotFound {
+			logger.Log.Errorf("Failed to look up user %s: %v", username, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+			c.Abort()
+			return
+		}
+		if user == nil || !auth.CheckPassword(user.PasswordHash, password) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		if !user.Role.Allows(required) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		c.Set(contextUsernameKey, user.Username)
+		c.Set(contextRoleKey, user.Role)
 		c.Next()
 	}
 }
 
+// authenticateAPIKey validates a Bearer API key and checks that it grants the scope
+func (h *Handler) authenticateAPIKey(c *gin.Context, token string, scope models.APIKeyScope) {
+	key, err := h.db.GetAPIKeyByHash(auth.HashAPIKey(token))
+	if err != nil && err != database.ErrNotFound {
+		logger.Log.Errorf("Failed to look up API key: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+		c.Abort()
+		return
+	}
+	if key == nil || !key.IsActive(time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		c.Abort()
+		return
+	}
+
+	if scope == "" || !key.HasScope(scope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		c.Abort()
+		return
+	}
+
+	if err := h.db.TouchAPIKey(key.ID); err != nil {
+		logger.Log.Warnf("Failed to record API key usage: %v", err)
+	}
+
+	c.Set(contextUsernameKey, "apikey:"+key.Name)
+	c.Set(contextAPIKeyKey, key.ID)
+	c.Next()
+}
+
 // RegisterAgent godoc
 // @Summary Register a new agent
 // @Description Register a new agent with the controller
@@ -102,27 +278,40 @@ func (h *Handler) RegisterAgent(c *gin.Context) {
 		return
 	}
 
-	agentID := uuid.New().String()
+	// Agents authenticated by certificate keep their certificate identity as ID
+	response, err := h.registerAgent(c.GetString(contextAgentIDKey), req)
+	if err != nil {
+		logger.Log.Errorf("Failed to register agent: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register agent"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// registerAgent records an agent, generating an ID when it has none
+func (h *Handler) registerAgent(agentID string, req models.RegisterRequest) (*models.RegisterResponse, error) {
+	if agentID == "" {
+		agentID = uuid.New().String()
+	}
 	agent := &models.Agent{
-		ID:       agentID,
-		Metadata: req.Metadata,
+		ID:           agentID,
+		RegisteredAt: time.Now(),
+		Metadata:     req.Metadata,
 	}
 
 	if err := h.db.RegisterAgent(agent); err != nil {
-		logger.Log.Errorf("Failed to register agent: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register agent"})
-		return
+		return nil, err
 	}
 
 	logger.Log.Infof("Agent registered: %s", agentID)
 
-	response := models.RegisterResponse{
+	return &models.RegisterResponse{
 		AgentID:          agentID,
 		PollURL:          "/api/v1/config",
 		PollIntervalSecs: h.pollInterval,
-	}
-
-	c.JSON(http.StatusOK, response)
+		Transports:       h.advertisedTransports(),
+	}, nil
 }
 
 // GetConfig godoc
@@ -130,12 +319,18 @@ func (h *Handler) RegisterAgent(c *gin.Context) {
 // @Description Get the current active configuration for agents
 // @Tags config
 // @Produce json
+// @Param X-Agent-ID header string false "Agent ID from registration, for agents without a client certificate"
 // @Success 200 {object} models.ConfigResponse
 // @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/config [get]
 // @Security BasicAuth
 func (h *Handler) GetConfig(c *gin.Context) {
+	if !h.seenAgent(c) {
+		return
+	}
+
 	config, err := h.db.GetActiveConfig()
 	if err != nil {
 		logger.Log.Errorf("Failed to get config: %v", err)
@@ -143,10 +338,41 @@ func (h *Handler) GetConfig(c *gin.Context) {
 		return
 	}
 
+	config.Signature, err = h.signer.Sign(signing.ResponsePayload(config))
+	if err != nil {
+		logger.Log.Errorf("Failed to sign config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get config"})
+		return
+	}
+
 	c.Header("ETag", strconv.FormatInt(config.Version, 10))
 	c.JSON(http.StatusOK, config)
 }
 
+// seenAgent records a request from the agent named by its client certificate or
+// the X-Agent-ID header. When the agent is not registered, e.g. after the
+// database was reset, it replies 404 so the agent registers again, and returns false.
+func (h *Handler) seenAgent(c *gin.Context) bool {
+	agentID := c.GetString(contextAgentIDKey)
+	if agentID == "" {
+		agentID = c.GetHeader(models.AgentIDHeader)
+	}
+	if agentID == "" {
+		return true
+	}
+
+	err := h.db.UpdateAgentPoll(agentID)
+	if errors.Is(err, database.ErrNotFound) {
+		logger.Log.Warnf("Request from unknown agent %s", agentID)
+		c.JSON(http.StatusNotFound, gin.H{"error": models.UnknownAgentError})
+		return false
+	}
+	if err != nil {
+		logger.Log.Warnf("Failed to record poll for agent %s: %v", agentID, err)
+	}
+	return true
+}
+
 // UpdateConfig godoc
 // @Summary Update configuration
 // @Description Update the global configuration (admin only)
@@ -158,9 +384,11 @@ func (h *Handler) GetConfig(c *gin.Context) {
 // @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/config [post]
 // @Security BasicAuth
+// @Security BearerAuth
 func (h *Handler) UpdateConfig(c *gin.Context) {
 	var config models.WorkerConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
@@ -176,68 +404,380 @@ func (h *Handler) UpdateConfig(c *gin.Context) {
 		}
 	}
 
-	version, err := h.db.UpdateConfig(config, pollInterval)
+	version, err := h.db.UpdateConfigAs(config, pollInterval, c.GetString(contextUsernameKey), h.outbox.Transports()...)
+	if errors.Is(err, secrets.ErrNoKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Secret values require SECRETS_KEK to be configured"})
+		return
+	}
 	if err != nil {
 		logger.Log.Errorf("Failed to update config: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update config"})
 		return
 	}
 
-	logger.Log.Infof("Configuration updated to version %d", version)
+	logger.Log.Infof("Configuration updated to version %d by %s", version, c.GetString(contextUsernameKey))
+
+	h.outbox.Notify()
 
-	// Publish to Redis if available (non-blocking)
-	if h.redisClient != nil && h.redisClient.IsConnected() {
-		versionStr := strconv.Itoa(int(version))
-		if err := h.redisClient.PublishConfig(config, versionStr); err != nil {
-			logger.Log.Warnf("Failed to publish config to Redis (continuing with polling): %v", err)
-		} else {
-			// Store backup in Redis
-			h.redisClient.StoreConfigInRedis(config, versionStr)
-			logger.Log.Info("Configuration published to Redis successfully")
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Configuration updated successfully",
+		"version": version,
+	})
+}
+
+// buildEnvelope builds the signed push message for a configuration version.
+// Secret values are redacted: agents fetch them over the authenticated config endpoint.
+func (h *Handler) buildEnvelope(version int64) (*models.ConfigEnvelope, error) {
+	var config models.WorkerConfig
+	active, err := h.db.GetActiveConfig()
+	if err != nil {
+		return nil, err
+	}
+	if active.Version == version {
+		config = active.Data
+	} else {
+		stored, err := h.db.GetConfigVersion(version)
+		if err != nil {
+			return nil, err
 		}
+		config = stored.Data
+	}
+
+	envelope, err := models.NewConfigEnvelope(version, config.Redacted(), h.controllerID)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope.Signature, err = h.signer.Sign(signing.EnvelopePayload(envelope))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign config: %w", err)
+	}
+	return envelope, nil
+}
+
+// redisPublisher publishes envelopes on the Redis config channel and stores
+// the latest one for agents that start later
+type redisPublisher struct {
+	client *redis.Client
+}
+
+func (p *redisPublisher) IsConnected() bool {
+	return p.client.IsConnected()
+}
+
+func (p *redisPublisher) Publish(envelope *models.ConfigEnvelope) error {
+	if !p.client.IsConnected() {
+		return fmt.Errorf("redis is not connected")
+	}
+	if err := p.client.PublishConfig(envelope); err != nil {
+		return err
+	}
+	return p.client.StoreConfigInRedis(envelope)
+}
+
+// natsPublisher publishes envelopes on the NATS config subject
+type natsPublisher struct {
+	client *natspkg.Client
+}
+
+func (p *natsPublisher) IsConnected() bool {
+	return p.client.IsConnected()
+}
+
+func (p *natsPublisher) Publish(envelope *models.ConfigEnvelope) error {
+	if !p.client.IsConnected() {
+		return fmt.Errorf("nats is not connected")
 	}
 
-	// Publish to NATS if available (non-blocking)
-	if h.natsClient != nil && h.natsClient.IsConnected() {
-		versionStr := strconv.Itoa(int(version))
-		configMessage := struct {
-			Version string              `json:"version"`
-			Config  models.WorkerConfig `json:"config"`
-		}{
-			Version: versionStr,
-			Config:  config,
+	messageData, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	subject := p.client.Config().Subject
+	if subject == "" {
+		subject = natspkg.DefaultSubject
+	}
+	return p.client.Publish(subject, messageData)
+}
+
+// PublishTarget publishes an envelope on the subject of an environment, group
+// or single agent
+func (p *natsPublisher) PublishTarget(environment, group, agentID string, envelope *models.ConfigEnvelope) error {
+	if !p.client.IsConnected() {
+		return fmt.Errorf("nats is not connected")
+	}
+
+	messageData, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return p.client.Publish(natspkg.TargetSubject(environment, group, agentID), messageData)
+}
+
+// configProducer produces envelopes to the compacted config topic, see kafka.Producer
+type configProducer interface {
+	IsConnected() bool
+	PublishConfig(key string, envelope *models.ConfigEnvelope) error
+}
+
+// kafkaPublisher produces envelopes to the compacted config topic under the key
+// of their target
+type kafkaPublisher struct {
+	producer configProducer
+}
+
+func (p *kafkaPublisher) IsConnected() bool {
+	return p.producer.IsConnected()
+}
+
+// Publish produces a version for every agent, as queued in the outbox
+func (p *kafkaPublisher) Publish(envelope *models.ConfigEnvelope) error {
+	return p.PublishTarget("", "", "", envelope)
+}
+
+// PublishTarget produces an envelope for an environment, group or single agent
+func (p *kafkaPublisher) PublishTarget(environment, group, agentID string, envelope *models.ConfigEnvelope) error {
+	return p.producer.PublishConfig(kafka.TargetKey(environment, group, agentID), envelope)
+}
+
+// EnableKafka publishes every configuration version and targeted resync to
+// Kafka through the outbox dispatcher
+func (h *Handler) EnableKafka(producer *kafka.Producer) {
+	h.outbox.Register(outbox.TransportKafka, &kafkaPublisher{producer: producer})
+}
+
+// StartOutbox delivers queued configuration versions to Redis and NATS, and
+// periodically rebroadcasts the active version, until the context is cancelled
+func (h *Handler) StartOutbox(ctx context.Context) {
+	if len(h.outbox.Transports()) == 0 {
+		return
+	}
+	go h.rebroadcaster.Start(ctx)
+	h.outbox.Start(ctx)
+}
+
+// ListConfigVersions godoc
+// @Summary List configuration history
+// @Description Get the most recent configuration versions with their authors
+// @Tags config
+// @Produce json
+// @Param limit query int false "Maximum number of versions" default(50)
+// @Success 200 {array} models.Config
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/config/versions [get]
+// @Security BasicAuth
+// @Security BearerAuth
+func (h *Handler) ListConfigVersions(c *gin.Context) {
+	limit := 50
+	if l := c.Query("limit"); l != "" {
+		if val, err := strconv.Atoi(l); err == nil && val > 0 {
+			limit = val
+		}
+	}
+
+	configs, err := h.db.ListConfigVersions(limit)
+	if err != nil {
+		logger.Log.Errorf("Failed to list config versions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list config versions"})
+		return
+	}
+
+	// Secret values are only ever delivered to agents
+	for i := range configs {
+		configs[i].Data = configs[i].Data.Redacted()
+	}
+
+	c.JSON(http.StatusOK, configs)
+}
+
+// RollbackConfig godoc
+// @Summary Roll back configuration
+// @Description Re-activate a previous configuration version as a new version (operator or admin)
+// @Tags config
+// @Produce json
+// @Param version path int true "Version to roll back to"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/config/rollback/{version} [post]
+// @Security BasicAuth
+func (h *Handler) RollbackConfig(c *gin.Context) {
+	target, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil || target <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return
+	}
+
+	previous, err := h.db.GetConfigVersion(target)
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return
+	}
+	if err != nil {
+		logger.Log.Errorf("Failed to get config version %d: %v", target, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get config version"})
+		return
+	}
+
+	active, err := h.db.GetActiveConfig()
+	if err != nil {
+		logger.Log.Errorf("Failed to get config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get config"})
+		return
+	}
+
+	username := c.GetString(contextUsernameKey)
+	version, err := h.db.UpdateConfigAs(previous.Data, active.PollIntervalSecs, username, h.outbox.Transports()...)
+	if err != nil {
+		logger.Log.Errorf("Failed to roll back config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back config"})
+		return
+	}
+
+	logger.Log.Infof("Configuration rolled back to version %d as version %d by %s", target, version, username)
+
+	h.outbox.Notify()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Configuration rolled back successfully",
+		"version":     version,
+		"rolled_back": target,
+	})
+}
+
+// ResyncConfig godoc
+// @Summary Resync configuration
+// @Description Republish the active configuration to push-mode agents (operator or admin).
+// @Description With environment, group or agent_id it is published over NATS and Kafka to those agents only.
+// @Tags config
+// @Produce json
+// @Param environment query string false "Target environment"
+// @Param group query string false "Target group"
+// @Param agent_id query string false "Target agent"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Failure 502 {object} map[string]string
+// @Router /api/v1/config/resync [post]
+// @Security BasicAuth
+func (h *Handler) ResyncConfig(c *gin.Context) {
+	environment, group, agentID := c.Query("environment"), c.Query("group"), c.Query("agent_id")
+	if environment != "" || group != "" || agentID != "" {
+		h.resyncTarget(c, environment, group, agentID)
+		return
+	}
+
+	version, err := h.db.EnqueueActiveConfig(h.outbox.Transports()...)
+	if err != nil {
+		logger.Log.Errorf("Failed to queue config resync: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue config resync"})
+		return
+	}
+
+	logger.Log.Infof("Configuration version %d resynced by %s", version, c.GetString(contextUsernameKey))
+
+	h.outbox.Notify()
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Configuration resync triggered",
+		"version": version,
+	})
+}
+
+// resyncTarget publishes the active configuration to one target over every
+// registered transport that supports targeting (the NATS target subject and
+// the Kafka target key). Targeted publishes are not queued in the outbox: the
+// caller sees the result directly.
+func (h *Handler) resyncTarget(c *gin.Context, environment, group, agentID string) {
+	for _, token := range []string{environment, group, agentID} {
+		if token == "" {
+			continue
+		}
+		if err := natspkg.ValidateToken(token); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
+	}
 
-		messageData, err := json.Marshal(configMessage)
+	active, err := h.db.GetActiveConfig()
+	if err != nil {
+		logger.Log.Errorf("Failed to get config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get config"})
+		return
+	}
+
+	envelope, err := h.buildEnvelope(active.Version)
+	if err != nil {
+		logger.Log.Errorf("Failed to build config envelope: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get config"})
+		return
+	}
+	// Agents already running the version forward it again instead of skipping it
+	envelope.Resync = true
+	envelope.Signature, err = h.signer.Sign(signing.EnvelopePayload(envelope))
+	if err != nil {
+		logger.Log.Errorf("Failed to sign config: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get config"})
+		return
+	}
+
+	results := h.outbox.PublishTarget(environment, group, agentID, envelope)
+	if len(results) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Targeted resync requires NATS or Kafka distribution"})
+		return
+	}
+
+	published := make([]string, 0, len(results))
+	failed := make([]string, 0, len(results))
+	for transport, err := range results {
 		if err != nil {
-			logger.Log.Warnf("Failed to marshal config for NATS: %v", err)
-		} else {
-			subject := "config.worker.update" // Default subject
-
-			if err := h.natsClient.Publish(subject, messageData); err != nil {
-				logger.Log.Warnf("Failed to publish config to NATS (continuing with polling): %v", err)
-			} else {
-				logger.Log.Infof("Configuration published to NATS successfully on subject: %s", subject)
-			}
+			logger.Log.Errorf("Failed to resync config over %s: %v", transport, err)
+			failed = append(failed, transport)
+			continue
 		}
+		published = append(published, transport)
+	}
+	sort.Strings(published)
+	sort.Strings(failed)
+
+	if len(failed) > 0 {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error":      "Failed to publish config resync",
+			"failed":     failed,
+			"transports": published,
+		})
+		return
 	}
 
+	logger.Log.Infof("Configuration version %d resynced to %s over %v by %s",
+		active.Version, natspkg.TargetSubject(environment, group, agentID), published, c.GetString(contextUsernameKey))
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Configuration updated successfully",
-		"version": version,
+		"message":    "Configuration resync published",
+		"version":    active.Version,
+		"transports": published,
 	})
 }
 
 // GetAgents godoc
 // @Summary Get all registered agents
-// @Description Get a list of all registered agents (admin only)
+// @Description Get a list of all registered agents (viewer or above)
 // @Tags agents
 // @Produce json
 // @Success 200 {array} models.Agent
 // @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /api/v1/agents [get]
 // @Security BasicAuth
+// @Security BearerAuth
 func (h *Handler) GetAgents(c *gin.Context) {
 	agents, err := h.db.GetAllAgents()
 	if err != nil {
@@ -251,11 +791,28 @@ func (h *Handler) GetAgents(c *gin.Context) {
 
 // HealthCheck godoc
 // @Summary Health check
-// @Description Check if the service is running
+// @Description Check if the service is running and report health, delivery counters and undelivered config publishes per transport
 // @Tags health
 // @Produce json
-// @Success 200 {object} map[string]string
+// @Success 200 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
 // @Router /health [get]
 func (h *Handler) HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	backlog, err := h.db.OutboxBacklog()
+	if err != nil {
+		logger.Log.Errorf("Failed to read outbox backlog: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "error": "database unavailable"})
+		return
+	}
+
+	// A disconnected transport degrades push delivery; HTTP polling keeps working
+	status := "ok"
+	transports := h.outbox.Status()
+	for _, transport := range transports {
+		if transport.Connected != nil && !*transport.Connected {
+			status = "degraded"
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": status, "transports": transports, "outbox": backlog})
 }
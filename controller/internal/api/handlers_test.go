@@ -2,6 +2,11 @@ package api
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -10,7 +15,9 @@ import (
 	"time"
 
 	"github.com/doniyusdinar/config-management/controller/internal/database"
+	"github.com/doniyusdinar/config-management/controller/internal/secrets"
 	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/signing"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -24,7 +31,7 @@ func setupTestHandler(t *testing.T) (*Handler, *gin.Engine, func()) {
 	db, err := database.New(dbPath)
 	require.NoError(t, err)
 
-	handler := NewHandler(db)
+	handler := NewHandler(db, nil, nil)
 	router := gin.New()
 
 	cleanup := func() {
@@ -47,10 +54,12 @@ func TestHealthCheck(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	var response map[string]string
+	var response map[string]interface{}
 	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
 	assert.Equal(t, "ok", response["status"])
+	assert.Contains(t, response, "outbox")
+	assert.Contains(t, response, "transports")
 }
 
 func TestRegisterAgent(t *testing.T) {
@@ -126,6 +135,110 @@ func TestGetConfig(t *testing.T) {
 	assert.NotEmpty(t, w.Header().Get("ETag"))
 }
 
+func TestGetConfigUnknownAgent(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	router.GET("/config", handler.AgentAuthMiddleware(), handler.GetConfig)
+	_, _ = handler.db.UpdateConfig(models.WorkerConfig{URL: "https://example.com"}, 30)
+	registration, err := handler.registerAgent("", models.RegisterRequest{Hostname: "test-agent"})
+	require.NoError(t, err)
+
+	get := func(agentID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/config", nil)
+		req.SetBasicAuth("agent", "secret123")
+		req.Header.Set(models.AgentIDHeader, agentID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, get(registration.AgentID).Code)
+	agent, err := handler.db.GetAgent(registration.AgentID)
+	require.NoError(t, err)
+	assert.False(t, agent.LastPoll.IsZero())
+
+	// An agent the controller lost track of is told to register again
+	w := get("forgotten-agent")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), models.UnknownAgentError)
+}
+
+func TestGetConfigSigned(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	handler.EnableSigning(signing.NewSigner(private))
+
+	router.GET("/config", handler.AgentAuthMiddleware(), handler.GetConfig)
+	_, _ = handler.db.UpdateConfig(models.WorkerConfig{URL: "https://example.com"}, 30)
+
+	req := httptest.NewRequest(http.MethodGet, "/config", nil)
+	req.SetBasicAuth("agent", "secret123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response models.ConfigResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.NotEmpty(t, response.Signature)
+
+	verifier := signing.NewVerifier(public)
+	assert.NoError(t, verifier.Verify(signing.ResponsePayload(&response), response.Signature))
+	response.Version++
+	assert.ErrorIs(t, verifier.Verify(signing.ResponsePayload(&response), response.Signature), signing.ErrInvalidSignature)
+}
+
+func TestConfigSecretsRedactedForAdmins(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	router.POST("/config", handler.AdminAuthMiddleware(), handler.UpdateConfig)
+	router.GET("/config", handler.AgentAuthMiddleware(), handler.GetConfig)
+	router.GET("/config/versions", handler.AdminAuthMiddleware(), handler.ListConfigVersions)
+
+	body, _ := json.Marshal(models.WorkerConfig{
+		URL:           "https://api.example.com",
+		SecretHeaders: map[string]models.Secret{"Authorization": "Bearer s3cret"},
+	})
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/config", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth("admin", "admin123")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Secrets are rejected until a key-encryption key is configured
+	assert.Equal(t, http.StatusBadRequest, post().Code)
+
+	cipher, err := secrets.NewCipher(bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+	handler.db.SetSecretsCipher(cipher)
+	require.Equal(t, http.StatusOK, post().Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/config/versions", nil)
+	req.SetBasicAuth("admin", "admin123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "s3cret")
+	assert.Contains(t, w.Body.String(), models.RedactedValue)
+
+	req = httptest.NewRequest(http.MethodGet, "/config", nil)
+	req.SetBasicAuth("agent", "secret123")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response models.ConfigResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.Secret("Bearer s3cret"), response.Data.SecretHeaders["Authorization"])
+}
+
 func TestUpdateConfig(t *testing.T) {
 	handler, router, cleanup := setupTestHandler(t)
 	defer cleanup()
@@ -192,3 +305,39 @@ func TestGetAgents(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, response, 2)
 }
+
+func TestRegisterAgentWithClientCert(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	router.POST("/register", handler.AgentAuthMiddleware(), handler.RegisterAgent)
+	router.GET("/config", handler.AgentAuthMiddleware(), handler.GetConfig)
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "agent-from-cert"}}
+	state := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+
+	body, _ := json.Marshal(models.RegisterRequest{Hostname: "test-agent"})
+	req := httptest.NewRequest(http.MethodPost, "/register", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.TLS = state
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.RegisterResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "agent-from-cert", response.AgentID)
+
+	req = httptest.NewRequest(http.MethodGet, "/config", nil)
+	req.TLS = state
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	agents, err := handler.db.GetAllAgents()
+	require.NoError(t, err)
+	require.Len(t, agents, 1)
+	assert.Equal(t, "agent-from-cert", agents[0].ID)
+	assert.False(t, agents[0].LastPoll.IsZero())
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/controller/internal/outbox"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProducer records the keys envelopes are produced under
+type fakeProducer struct {
+	mu       sync.Mutex
+	produced []string
+}
+
+func (f *fakeProducer) IsConnected() bool {
+	return true
+}
+
+func (f *fakeProducer) PublishConfig(key string, envelope *models.ConfigEnvelope) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.produced = append(f.produced, key)
+	return nil
+}
+
+func (f *fakeProducer) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.produced...)
+}
+
+func TestKafkaProducesUnderTargetKeys(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	producer := &fakeProducer{}
+	handler.outbox.Register(outbox.TransportKafka, &kafkaPublisher{producer: producer})
+	router.POST("/config/resync", handler.ResyncConfig)
+
+	// Versions queued in the outbox go to every agent
+	_, err := handler.db.UpdateConfigAs(models.WorkerConfig{URL: "https://ip.me"}, 30, "admin", outbox.TransportKafka)
+	require.NoError(t, err)
+	require.NoError(t, handler.outbox.Dispatch(time.Now()))
+	assert.Equal(t, []string{"all.all.all"}, producer.keys())
+
+	// Targeted resyncs go to the key of their target only
+	for _, query := range []string{"?environment=prod&group=eu", "?agent_id=agent-1"} {
+		req := httptest.NewRequest(http.MethodPost, "/config/resync"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	assert.Equal(t, []string{"all.all.all", "prod.eu.all", "all.all.agent-1"}, producer.keys())
+	assert.Equal(t, int64(3), handler.outbox.Status()[outbox.TransportKafka].Published)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/doniyusdinar/config-management/pkg/auth"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	natspkg "github.com/doniyusdinar/config-management/pkg/nats"
+	"github.com/nats-io/nats.go"
+)
+
+// StartNatsResponder answers agent config and registration requests over NATS.
+// Controller replicas share the queue group so each request is served once.
+func (h *Handler) StartNatsResponder(queueGroup string) error {
+	if h.natsClient == nil {
+		return nil
+	}
+
+	handlers := map[string]nats.MsgHandler{
+		natspkg.ConfigRequestSubject:   h.respondNats(h.natsConfigRequest),
+		natspkg.RegisterRequestSubject: h.respondNats(h.natsRegisterRequest),
+	}
+	for subject, handler := range handlers {
+		if _, err := h.natsClient.QueueSubscribe(subject, queueGroup, handler); err != nil {
+			return fmt.Errorf("failed to subscribe to NATS subject %s: %w", subject, err)
+		}
+		logger.Log.Infof("NATS responder started on subject: %s (queue group %s)", subject, queueGroup)
+	}
+
+	return nil
+}
+
+// respondNats replies to a request with the result of natsReply
+func (h *Handler) respondNats(serve func(req models.NatsRequest) (interface{}, error)) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		if msg.Reply == "" {
+			return
+		}
+
+		data, err := h.natsReply(msg.Subject, msg.Data, serve)
+		if err != nil {
+			logger.Log.Errorf("Failed to marshal NATS reply: %v", err)
+			return
+		}
+		if err := msg.Respond(data); err != nil {
+			logger.Log.Warnf("Failed to reply on NATS: %v", err)
+		}
+	}
+}
+
+// natsReply authenticates a request and encodes the result of serve, or a
+// models.NatsError when the request is rejected or fails
+func (h *Handler) natsReply(subject string, data []byte, serve func(req models.NatsRequest) (interface{}, error)) ([]byte, error) {
+	var reply interface{}
+	var req models.NatsRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		reply = models.NatsError{Error: "Invalid request body"}
+	} else if !auth.ValidateBasicAuth(req.Authorization, h.agentUsername, h.agentPassword) {
+		reply = models.NatsError{Error: "Unauthorized"}
+	} else if result, err := serve(req); err != nil {
+		logger.Log.Errorf("Failed to serve NATS request on %s: %v", subject, err)
+		reply = models.NatsError{Error: err.Error()}
+	} else {
+		reply = result
+	}
+
+	return json.Marshal(reply)
+}
+
+// natsConfigRequest returns the active configuration as a signed envelope.
+// As with pushes, secret values are redacted; they are only served over HTTP.
+func (h *Handler) natsConfigRequest(req models.NatsRequest) (interface{}, error) {
+	active, err := h.db.GetActiveConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config")
+	}
+
+	envelope, err := h.buildEnvelope(active.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config")
+	}
+
+	if req.AgentID != "" {
+		if err := h.db.UpdateAgentPoll(req.AgentID); err != nil {
+			logger.Log.Warnf("Failed to record poll for agent %s: %v", req.AgentID, err)
+		}
+	}
+
+	return envelope, nil
+}
+
+// natsRegisterRequest registers an agent that has no HTTP path to the controller
+func (h *Handler) natsRegisterRequest(req models.NatsRequest) (interface{}, error) {
+	register := models.RegisterRequest{}
+	if req.Register != nil {
+		register = *req.Register
+	}
+
+	// As over HTTP without a client certificate, the controller assigns the ID
+	response, err := h.registerAgent("", register)
+	if err != nil {
+		logger.Log.Errorf("Failed to register agent: %v", err)
+		return nil, fmt.Errorf("failed to register agent")
+	}
+	return response, nil
+}
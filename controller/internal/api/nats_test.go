@@ -0,0 +1,99 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/doniyusdinar/config-management/pkg/auth"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/doniyusdinar/config-management/pkg/signing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func natsRequest(t *testing.T, req models.NatsRequest) []byte {
+	data, err := json.Marshal(req)
+	require.NoError(t, err)
+	return data
+}
+
+func TestNatsConfigRequest(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	handler.EnableSigning(signing.NewSigner(private))
+
+	data, err := handler.natsReply("config.get", natsRequest(t, models.NatsRequest{
+		Authorization: auth.CreateBasicAuthHeader("agent", "secret123"),
+	}), handler.natsConfigRequest)
+	require.NoError(t, err)
+
+	envelope, err := models.DecodeConfigEnvelope(data)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), envelope.Version)
+	assert.NoError(t, signing.NewVerifier(public).Verify(signing.EnvelopePayload(envelope), envelope.Signature))
+
+	data, err = handler.natsReply("config.get", natsRequest(t, models.NatsRequest{
+		Authorization: auth.CreateBasicAuthHeader("agent", "wrong"),
+	}), handler.natsConfigRequest)
+	require.NoError(t, err)
+
+	var natsErr models.NatsError
+	require.NoError(t, json.Unmarshal(data, &natsErr))
+	assert.Equal(t, "Unauthorized", natsErr.Error)
+}
+
+func TestNatsRegisterRequest(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	data, err := handler.natsReply("agent.register", natsRequest(t, models.NatsRequest{
+		Authorization: auth.CreateBasicAuthHeader("agent", "secret123"),
+		AgentID:       "chosen-by-agent",
+		Register:      &models.RegisterRequest{Hostname: "agent-1", Metadata: "region=eu"},
+	}), handler.natsRegisterRequest)
+	require.NoError(t, err)
+
+	var response models.RegisterResponse
+	require.NoError(t, json.Unmarshal(data, &response))
+	assert.NotEmpty(t, response.AgentID)
+	assert.NotEqual(t, "chosen-by-agent", response.AgentID)
+
+	agent, err := handler.db.GetAgent(response.AgentID)
+	require.NoError(t, err)
+	assert.Equal(t, "region=eu", agent.Metadata)
+}
+
+func TestTargetedResync(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	router.POST("/config/resync", handler.ResyncConfig)
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"broadcast", "", http.StatusOK},
+		{"wildcard in group", "?group=eu.*", http.StatusBadRequest},
+		{"reserved token", "?environment=all", http.StatusBadRequest},
+		{"agent without NATS", "?agent_id=agent-1", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/config/resync"+tt.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
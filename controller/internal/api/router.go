@@ -1,6 +1,7 @@
 package api
 
 import (
+	"github.com/doniyusdinar/config-management/pkg/models"
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -17,8 +18,41 @@ func SetupRouter(handler *Handler) *gin.Engine {
 	{
 		v1.POST("/register", handler.AgentAuthMiddleware(), handler.RegisterAgent)
 		v1.GET("/config", handler.AgentAuthMiddleware(), handler.GetConfig)
-		v1.POST("/config", handler.AdminAuthMiddleware(), handler.UpdateConfig)
-		v1.GET("/agents", handler.AdminAuthMiddleware(), handler.GetAgents)
+		v1.GET("/transports", handler.AgentAuthMiddleware(), handler.GetTransports)
+		v1.POST("/acks", handler.AgentAuthMiddleware(), handler.PostAck)
+		v1.POST("/config", handler.ScopedAuthMiddleware(models.RoleAdmin, models.ScopeWriteConfig), handler.UpdateConfig)
+		v1.GET("/config/versions", handler.ScopedAuthMiddleware(models.RoleViewer, models.ScopeReadConfig), handler.ListConfigVersions)
+		v1.GET("/config/versions/:version/delivery", handler.ScopedAuthMiddleware(models.RoleViewer, models.ScopeReadConfig), handler.GetConfigDelivery)
+		v1.POST("/config/rollback/:version", handler.RoleAuthMiddleware(models.RoleOperator), handler.RollbackConfig)
+		v1.POST("/config/resync", handler.RoleAuthMiddleware(models.RoleOperator), handler.ResyncConfig)
+		v1.GET("/agents", handler.ScopedAuthMiddleware(models.RoleViewer, models.ScopeReadAgents), handler.GetAgents)
+
+		users := v1.Group("/users", handler.AdminAuthMiddleware())
+		{
+			users.GET("", handler.ListUsers)
+			users.POST("", handler.CreateUser)
+			users.PUT("/:username", handler.UpdateUser)
+			users.DELETE("/:username", handler.DeleteUser)
+		}
+
+		apiKeys := v1.Group("/apikeys", handler.AdminAuthMiddleware())
+		{
+			apiKeys.GET("", handler.ListAPIKeys)
+			apiKeys.POST("", handler.CreateAPIKey)
+			apiKeys.DELETE("/:id", handler.RevokeAPIKey)
+		}
+
+		v1.POST("/enroll", handler.CAEnabledMiddleware(), handler.Enroll)
+		v1.POST("/enroll/renew", handler.CAEnabledMiddleware(), handler.AgentAuthMiddleware(), handler.RenewCertificate)
+
+		caGroup := v1.Group("/ca", handler.CAEnabledMiddleware())
+		{
+			caGroup.GET("/certificate", handler.GetCACertificate)
+			caGroup.GET("/crl", handler.GetRevocationList)
+			caGroup.GET("/certificates", handler.AdminAuthMiddleware(), handler.ListCertificates)
+			caGroup.POST("/certificates/:serial/revoke", handler.AdminAuthMiddleware(), handler.RevokeCertificate)
+			caGroup.POST("/tokens", handler.AdminAuthMiddleware(), handler.CreateEnrollmentToken)
+		}
 	}
 
 	return router
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/doniyusdinar/config-management/controller/internal/outbox"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// strategyTransports maps advertised strategies to their outbox transports
+var strategyTransports = map[string]string{
+	"REDIS": outbox.TransportRedis,
+	"NATS":  outbox.TransportNats,
+	"KAFKA": outbox.TransportKafka,
+}
+
+// AdvertiseTransport offers a distribution strategy to agents. HTTP polling is
+// always offered, with priority 0 unless advertised explicitly.
+func (h *Handler) AdvertiseTransport(offer models.TransportOffer) {
+	h.offers = append(h.offers, offer)
+}
+
+// advertisedTransports returns the offered strategies, best first. Push
+// transports that are currently disconnected are left out so agents fall back
+// to the next one until it recovers.
+func (h *Handler) advertisedTransports() []models.TransportOffer {
+	status := h.outbox.Status()
+
+	offers := make([]models.TransportOffer, 0, len(h.offers)+1)
+	polling := false
+	for _, offer := range h.offers {
+		if transport, ok := strategyTransports[offer.Strategy]; ok {
+			s, registered := status[transport]
+			if !registered || (s.Connected != nil && !*s.Connected) {
+				continue
+			}
+		}
+		if offer.Strategy == "POLLER" {
+			polling = true
+		}
+		offers = append(offers, offer)
+	}
+	if !polling {
+		offers = append(offers, models.TransportOffer{Strategy: "POLLER"})
+	}
+
+	sort.SliceStable(offers, func(i, j int) bool {
+		return offers[i].Priority > offers[j].Priority
+	})
+	return offers
+}
+
+// GetTransports godoc
+// @Summary List distribution transports
+// @Description List the distribution strategies agents may use, best first. Agents re-read this to switch transports live.
+// @Tags agents
+// @Produce json
+// @Param X-Agent-ID header string false "Agent ID from registration, for agents without a client certificate"
+// @Success 200 {array} models.TransportOffer
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /api/v1/transports [get]
+// @Security BasicAuth
+func (h *Handler) GetTransports(c *gin.Context) {
+	if !h.seenAgent(c) {
+		return
+	}
+	c.JSON(http.StatusOK, h.advertisedTransports())
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/doniyusdinar/config-management/controller/internal/outbox"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubPublisher accepts every publish and reports a fixed connection state
+type stubPublisher struct {
+	connected bool
+}
+
+func (p *stubPublisher) Publish(envelope *models.ConfigEnvelope) error { return nil }
+
+func (p *stubPublisher) IsConnected() bool { return p.connected }
+
+func TestAdvertisedTransports(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	router.GET("/transports", handler.AgentAuthMiddleware(), handler.GetTransports)
+
+	nats := &stubPublisher{connected: true}
+	handler.outbox.Register(outbox.TransportRedis, &stubPublisher{connected: true})
+	handler.outbox.Register(outbox.TransportNats, nats)
+	handler.AdvertiseTransport(models.TransportOffer{Strategy: "REDIS", Priority: 2, Endpoints: []string{"redis:6379"}, Channel: "config:global"})
+	handler.AdvertiseTransport(models.TransportOffer{Strategy: "NATS", Priority: 3, Endpoints: []string{"nats://nats:4222"}, Subject: "config.worker.update"})
+	// Not registered with the outbox, so never offered
+	handler.AdvertiseTransport(models.TransportOffer{Strategy: "KAFKA", Priority: 4})
+
+	get := func() []models.TransportOffer {
+		req := httptest.NewRequest(http.MethodGet, "/transports", nil)
+		req.SetBasicAuth("agent", "secret123")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var offers []models.TransportOffer
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &offers))
+		return offers
+	}
+
+	strategies := func(offers []models.TransportOffer) []string {
+		var names []string
+		for _, offer := range offers {
+			names = append(names, offer.Strategy)
+		}
+		return names
+	}
+
+	offers := get()
+	assert.Equal(t, []string{"NATS", "REDIS", "POLLER"}, strategies(offers))
+	assert.Equal(t, "config.worker.update", offers[0].Subject)
+
+	// A disconnected transport is withdrawn until it recovers
+	nats.connected = false
+	assert.Equal(t, []string{"REDIS", "POLLER"}, strategies(get()))
+
+	response, err := handler.registerAgent("", models.RegisterRequest{Hostname: "test-agent"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"REDIS", "POLLER"}, strategies(response.Transports))
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/doniyusdinar/config-management/controller/internal/database"
+	"github.com/doniyusdinar/config-management/pkg/auth"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+const minPasswordLength = 8
+
+// ListUsers godoc
+// @Summary List admin accounts
+// @Description Get all admin accounts and their roles (admin only)
+// @Tags users
+// @Produce json
+// @Success 200 {array} models.User
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/users [get]
+// @Security BasicAuth
+func (h *Handler) ListUsers(c *gin.Context) {
+	users, err := h.db.ListUsers()
+	if err != nil {
+		logger.Log.Errorf("Failed to list users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// CreateUser godoc
+// @Summary Create admin account
+// @Description Create a new admin account with a role (admin only)
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param user body models.CreateUserRequest true "New account"
+// @Success 201 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/users [post]
+// @Security BasicAuth
+func (h *Handler) CreateUser(c *gin.Context) {
+	var req models.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Log.Errorf("Invalid request body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username is required"})
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters"})
+		return
+	}
+	if !req.Role.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	if _, err := h.db.GetUser(req.Username); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+		return
+	} else if err != database.ErrNotFound {
+		logger.Log.Errorf("Failed to look up user %s: %v", req.Username, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		logger.Log.Errorf("Failed to hash password: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	user := &models.User{Username: req.Username, PasswordHash: hash, Role: req.Role}
+	if err := h.db.CreateUser(user); err != nil {
+		logger.Log.Errorf("Failed to create user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	logger.Log.Infof("User %s created with role %s by %s", user.Username, user.Role, c.GetString(contextUsernameKey))
+	c.JSON(http.StatusCreated, user)
+}
+
+// UpdateUser godoc
+// @Summary Update admin account
+// @Description Change an admin account's role and/or password (admin only)
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param username path string true "Username"
+// @Param user body models.UpdateUserRequest true "Fields to change"
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/users/{username} [put]
+// @Security BasicAuth
+func (h *Handler) UpdateUser(c *gin.Context) {
+	var req models.UpdateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logger.Log.Errorf("Invalid request body: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	user, err := h.db.GetUser(c.Param("username"))
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		logger.Log.Errorf("Failed to look up user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	if req.Role != "" {
+		if !req.Role.IsValid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+		user.Role = req.Role
+	}
+
+	if req.Password != "" {
+		if len(req.Password) < minPasswordLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters"})
+			return
+		}
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			logger.Log.Errorf("Failed to hash password: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+			return
+		}
+		user.PasswordHash = hash
+	}
+
+	err = h.db.UpdateUser(user)
+	if err == database.ErrLastAdmin {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot remove the last admin account"})
+		return
+	}
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		logger.Log.Errorf("Failed to update user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	logger.Log.Infof("User %s updated by %s", user.Username, c.GetString(contextUsernameKey))
+	c.JSON(http.StatusOK, user)
+}
+
+// DeleteUser godoc
+// @Summary Delete admin account
+// @Description Remove an admin account (admin only)
+// @Tags users
+// @Produce json
+// @Param username path string true "Username"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /api/v1/users/{username} [delete]
+// @Security BasicAuth
+func (h *Handler) DeleteUser(c *gin.Context) {
+	username := c.Param("username")
+
+	err := h.db.DeleteUser(username)
+	if err == database.ErrLastAdmin {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot remove the last admin account"})
+		return
+	}
+	if err == database.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		logger.Log.Errorf("Failed to delete user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+
+	logger.Log.Infof("User %s deleted by %s", username, c.GetString(contextUsernameKey))
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/doniyusdinar/config-management/pkg/auth"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTestUser(t *testing.T, handler *Handler, username, password string, role models.Role) {
+	hash, err := auth.HashPassword(password)
+	require.NoError(t, err)
+	require.NoError(t, handler.db.CreateUser(&models.User{Username: username, PasswordHash: hash, Role: role}))
+}
+
+func TestBootstrapAdmin(t *testing.T) {
+	handler, _, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	user, err := handler.db.GetUser("admin")
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleAdmin, user.Role)
+	assert.True(t, auth.CheckPassword(user.PasswordHash, "admin123"))
+}
+
+func TestRoleAuthMiddleware(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	createTestUser(t, handler, "viewer", "viewer-pass", models.RoleViewer)
+	createTestUser(t, handler, "operator", "operator-pass", models.RoleOperator)
+
+	router.GET("/agents", handler.RoleAuthMiddleware(models.RoleViewer), handler.GetAgents)
+	router.POST("/config", handler.AdminAuthMiddleware(), handler.UpdateConfig)
+	router.POST("/config/resync", handler.RoleAuthMiddleware(models.RoleOperator), handler.ResyncConfig)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		username string
+		password string
+		want     int
+	}{
+		{"viewer reads agents", http.MethodGet, "/agents", "viewer", "viewer-pass", http.StatusOK},
+		{"viewer cannot resync", http.MethodPost, "/config/resync", "viewer", "viewer-pass", http.StatusForbidden},
+		{"operator resyncs", http.MethodPost, "/config/resync", "operator", "operator-pass", http.StatusOK},
+		{"operator cannot update config", http.MethodPost, "/config", "operator", "operator-pass", http.StatusForbidden},
+		{"wrong password", http.MethodGet, "/agents", "viewer", "wrong", http.StatusUnauthorized},
+		{"unknown user", http.MethodGet, "/agents", "nobody", "viewer-pass", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(models.WorkerConfig{URL: "https://example.com"})
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.SetBasicAuth(tt.username, tt.password)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
+
+func TestRollbackConfigRecordsAuthor(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	createTestUser(t, handler, "operator", "operator-pass", models.RoleOperator)
+	router.POST("/config/rollback/:version", handler.RoleAuthMiddleware(models.RoleOperator), handler.RollbackConfig)
+
+	_, err := handler.db.UpdateConfig(models.WorkerConfig{URL: "https://first.com"}, 30)
+	require.NoError(t, err)
+	_, err = handler.db.UpdateConfig(models.WorkerConfig{URL: "https://second.com"}, 30)
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/config/rollback/2", nil)
+	req.SetBasicAuth("operator", "operator-pass")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	config, err := handler.db.GetConfigVersion(4)
+	require.NoError(t, err)
+	assert.Equal(t, "https://first.com", config.Data.URL)
+	assert.Equal(t, "operator", config.CreatedBy)
+}
+
+func TestCreateAndDeleteUser(t *testing.T) {
+	handler, router, cleanup := setupTestHandler(t)
+	defer cleanup()
+
+	router.POST("/users", handler.AdminAuthMiddleware(), handler.CreateUser)
+	router.DELETE("/users/:username", handler.AdminAuthMiddleware(), handler.DeleteUser)
+
+	body, _ := json.Marshal(models.CreateUserRequest{Username: "bob", Password: "bob-password", Role: models.RoleOperator})
+	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("admin", "admin123")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "password")
+
+	// The last admin account cannot be removed
+	req = httptest.NewRequest(http.MethodDelete, "/users/admin", nil)
+	req.SetBasicAuth("admin", "admin123")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/users/bob", nil)
+	req.SetBasicAuth("admin", "admin123")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,155 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAuthority(t *testing.T) *Authority {
+	dir := t.TempDir()
+	authority, err := LoadOrCreate(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"))
+	require.NoError(t, err)
+	return authority
+}
+
+func newCSR(t *testing.T, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestLoadOrCreateReusesAuthority(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+
+	created, err := LoadOrCreate(certFile, keyFile)
+	require.NoError(t, err)
+	assert.True(t, created.Certificate().IsCA)
+
+	loaded, err := LoadOrCreate(certFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, created.CertificatePEM(), loaded.CertificatePEM())
+}
+
+func TestSignCSR(t *testing.T) {
+	authority := newTestAuthority(t)
+
+	csr, err := ParseCSR(newCSR(t, "agent-1"))
+	require.NoError(t, err)
+	cert, certPEM, err := authority.Sign(csr, time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(t, "agent-1", cert.Subject.CommonName)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, cert.ExtKeyUsage)
+	assert.True(t, authority.IssuedBy(cert))
+
+	block, _ := pem.Decode(certPEM)
+	require.NotNil(t, block)
+	assert.Equal(t, cert.Raw, block.Bytes)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.Certificate())
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(t, err)
+
+	// Certificates from another CA are not accepted as issued here
+	assert.False(t, newTestAuthority(t).IssuedBy(cert))
+
+	// Serial numbers are unique
+	other, _, err := authority.Sign(csr, time.Hour)
+	require.NoError(t, err)
+	assert.NotEqual(t, SerialString(cert), SerialString(other))
+}
+
+func TestSignRejectsInvalidCSR(t *testing.T) {
+	authority := newTestAuthority(t)
+
+	_, err := ParseCSR([]byte("not a csr"))
+	assert.Error(t, err)
+
+	// A CSR whose signature does not match its content is rejected
+	block, _ := pem.Decode(newCSR(t, "agent-1"))
+	block.Bytes[len(block.Bytes)-1] ^= 0xff
+	_, err = ParseCSR(pem.EncodeToMemory(block))
+	assert.Error(t, err)
+
+	csr, err := ParseCSR(newCSR(t, ""))
+	require.NoError(t, err)
+	_, _, err = authority.Sign(csr, time.Hour)
+	assert.Error(t, err)
+}
+
+func TestCertificateLifetime(t *testing.T) {
+	authority := newTestAuthority(t)
+	csr, err := ParseCSR(newCSR(t, "agent-1"))
+	require.NoError(t, err)
+
+	before := time.Now()
+	cert, _, err := authority.Sign(csr, 24*time.Hour)
+	require.NoError(t, err)
+
+	// Backdated slightly for clock skew, expiring after the requested TTL
+	assert.WithinDuration(t, before.Add(-time.Minute), cert.NotBefore, 5*time.Second)
+	assert.WithinDuration(t, before.Add(24*time.Hour), cert.NotAfter, 5*time.Second)
+
+	// Never valid beyond the CA itself
+	cert, _, err = authority.Sign(csr, 100*365*24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, authority.Certificate().NotAfter, cert.NotAfter)
+}
+
+func TestRevocationList(t *testing.T) {
+	authority := newTestAuthority(t)
+	csr, err := ParseCSR(newCSR(t, "agent-1"))
+	require.NoError(t, err)
+	revokedCert, _, err := authority.Sign(csr, time.Hour)
+	require.NoError(t, err)
+	validCert, _, err := authority.Sign(csr, time.Hour)
+	require.NoError(t, err)
+
+	revokedAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	crlPEM, err := authority.RevocationList(map[string]time.Time{SerialString(revokedCert): revokedAt}, 7, time.Hour)
+	require.NoError(t, err)
+
+	block, _ := pem.Decode(crlPEM)
+	require.NotNil(t, block)
+	assert.Equal(t, "X509 CRL", block.Type)
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	require.NoError(t, err)
+
+	require.NoError(t, crl.CheckSignatureFrom(authority.Certificate()))
+	assert.Equal(t, int64(7), crl.Number.Int64())
+	assert.WithinDuration(t, crl.ThisUpdate.Add(time.Hour), crl.NextUpdate, time.Second)
+
+	require.Len(t, crl.RevokedCertificateEntries, 1)
+	entry := crl.RevokedCertificateEntries[0]
+	assert.Equal(t, revokedCert.SerialNumber, entry.SerialNumber)
+	assert.NotEqual(t, validCert.SerialNumber, entry.SerialNumber)
+	assert.True(t, revokedAt.Equal(entry.RevocationTime))
+
+	// An empty list is still signed and valid
+	crlPEM, err = authority.RevocationList(nil, 8, time.Hour)
+	require.NoError(t, err)
+	block, _ = pem.Decode(crlPEM)
+	crl, err = x509.ParseRevocationList(block.Bytes)
+	require.NoError(t, err)
+	assert.NoError(t, crl.CheckSignatureFrom(authority.Certificate()))
+	assert.Empty(t, crl.RevokedCertificateEntries)
+
+	_, err = authority.RevocationList(map[string]time.Time{"not-hex": revokedAt}, 9, time.Hour)
+	assert.Error(t, err)
+}
@@ -3,15 +3,26 @@ package database
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/doniyusdinar/config-management/controller/internal/secrets"
 	"github.com/doniyusdinar/config-management/pkg/models"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+var (
+	// ErrNotFound is returned when a requested record does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrLastAdmin is returned when a change would remove the last admin account
+	ErrLastAdmin = errors.New("cannot remove the last admin account")
+)
+
 type DB struct {
-	conn *sql.DB
+	conn    *sql.DB
+	secrets *secrets.Cipher
 }
 
 // New creates a new database connection
@@ -39,6 +50,12 @@ func New(dbPath string) (*DB, error) {
 	return db, nil
 }
 
+// SetSecretsCipher enables storing configurations with secret values, which
+// are encrypted with the cipher before they are written
+func (db *DB) SetSecretsCipher(cipher *secrets.Cipher) {
+	db.secrets = cipher
+}
+
 // migrate creates the database schema
 func (db *DB) migrate() error {
 	schema := `
@@ -63,12 +80,83 @@ func (db *DB) migrate() error {
 		poll_interval_seconds INTEGER DEFAULT 30,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
+
+	CREATE TABLE IF NOT EXISTS users (
+		username TEXT PRIMARY KEY,
+		password_hash TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		key_hash TEXT UNIQUE NOT NULL,
+		scopes TEXT NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		created_by TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS enrollment_tokens (
+		id TEXT PRIMARY KEY,
+		token_hash TEXT UNIQUE NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		max_uses INTEGER NOT NULL,
+		uses INTEGER NOT NULL DEFAULT 0,
+		created_by TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS certificates (
+		serial TEXT PRIMARY KEY,
+		agent_id TEXT NOT NULL,
+		issued_at TIMESTAMP NOT NULL,
+		not_after TIMESTAMP NOT NULL,
+		revoked_at TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS config_outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		version INTEGER NOT NULL,
+		transport TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL,
+		last_error TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		delivered_at TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_config_outbox_pending ON config_outbox (status, next_attempt_at);
+
+	CREATE TABLE IF NOT EXISTS config_acks (
+		agent_id TEXT NOT NULL,
+		version INTEGER NOT NULL,
+		success BOOLEAN NOT NULL,
+		error TEXT,
+		latency_ms INTEGER NOT NULL,
+		acked_at TIMESTAMP NOT NULL,
+		received_at TIMESTAMP NOT NULL,
+		workers TEXT,
+		PRIMARY KEY (agent_id, version)
+	);
 	`
 
 	if _, err := db.conn.Exec(schema); err != nil {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
 
+	if err := db.addColumnIfMissing("configurations", "created_by", "TEXT"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("config_acks", "workers", "TEXT"); err != nil {
+		return err
+	}
+
 	var count int
 	err := db.conn.QueryRow("SELECT COUNT(*) FROM active_config").Scan(&count)
 	if err != nil {
@@ -90,26 +178,88 @@ func (db *DB) migrate() error {
 	return nil
 }
 
+// addColumnIfMissing adds a column to an existing table created by an older schema
+func (db *DB) addColumnIfMissing(table, column, definition string) error {
+	rows, err := db.conn.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", table, err)
+	}
+	rows.Close()
+
+	if _, err := db.conn.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
 // Close closes the database connection
 func (db *DB) Close() error {
 	return db.conn.Close()
 }
 
-// RegisterAgent registers a new agent
+// RegisterAgent registers a new agent, or refreshes the metadata of an agent
+// re-registering under the same ID
 func (db *DB) RegisterAgent(agent *models.Agent) error {
 	_, err := db.conn.Exec(`
 		INSERT INTO agents (id, registered_at, metadata)
 		VALUES (?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET metadata = excluded.metadata
 	`, agent.ID, agent.RegisteredAt, agent.Metadata)
 	return err
 }
 
-// UpdateAgentPoll updates the last poll time for an agent
+// GetAgent retrieves a registered agent by ID
+func (db *DB) GetAgent(agentID string) (*models.Agent, error) {
+	var agent models.Agent
+	var lastPoll sql.NullTime
+	var metadata sql.NullString
+
+	err := db.conn.QueryRow(`
+		SELECT id, registered_at, last_poll, metadata FROM agents WHERE id = ?
+	`, agentID).Scan(&agent.ID, &agent.RegisteredAt, &lastPoll, &metadata)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if lastPoll.Valid {
+		agent.LastPoll = lastPoll.Time
+	}
+	agent.Metadata = metadata.String
+	return &agent, nil
+}
+
+// UpdateAgentPoll updates the last poll time for an agent. It returns
+// ErrNotFound when the agent is not registered.
 func (db *DB) UpdateAgentPoll(agentID string) error {
-	_, err := db.conn.Exec(`
+	result, err := db.conn.Exec(`
 		UPDATE agents SET last_poll = ? WHERE id = ?
 	`, time.Now(), agentID)
-	return err
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
 // GetActiveConfig retrieves the current active configuration
@@ -125,9 +275,9 @@ func (db *DB) GetActiveConfig() (*models.ConfigResponse, error) {
 		return nil, err
 	}
 
-	var workerConfig models.WorkerConfig
-	if err := json.Unmarshal([]byte(configData), &workerConfig); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	workerConfig, err := db.decodeConfig(configData)
+	if err != nil {
+		return nil, err
 	}
 
 	return &models.ConfigResponse{
@@ -139,39 +289,274 @@ func (db *DB) GetActiveConfig() (*models.ConfigResponse, error) {
 
 // UpdateConfig updates the active configuration
 func (db *DB) UpdateConfig(config models.WorkerConfig, pollInterval int) (int64, error) {
-	configJSON, err := json.Marshal(config)
+	return db.UpdateConfigAs(config, pollInterval, "")
+}
+
+// UpdateConfigAs updates the active configuration and records who made the change.
+// An outbox entry is queued for each transport in the same transaction so the
+// new version is published even if the transports are unavailable right now.
+func (db *DB) UpdateConfigAs(config models.WorkerConfig, pollInterval int, author string, transports ...string) (int64, error) {
+	configJSON, err := db.encodeConfig(config)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.conn.Begin()
 	if err != nil {
-		return 0, fmt.Errorf("failed to marshal config: %w", err)
+		return 0, err
 	}
+	defer tx.Rollback()
 
 	var currentVersion int64
-	err = db.conn.QueryRow("SELECT version FROM active_config WHERE id = 1").Scan(&currentVersion)
+	err = tx.QueryRow("SELECT version FROM active_config WHERE id = 1").Scan(&currentVersion)
 	if err != nil {
 		return 0, err
 	}
 
 	newVersion := currentVersion + 1
+	now := time.Now()
 
-	_, err = db.conn.Exec(`
+	_, err = tx.Exec(`
 		UPDATE active_config 
 		SET version = ?, config_data = ?, poll_interval_seconds = ?, updated_at = ?
 		WHERE id = 1
-	`, newVersion, string(configJSON), pollInterval, time.Now())
+	`, newVersion, string(configJSON), pollInterval, now)
 	if err != nil {
 		return 0, err
 	}
 
-	_, err = db.conn.Exec(`
-		INSERT INTO configurations (version, config_data, created_at)
-		VALUES (?, ?, ?)
-	`, newVersion, string(configJSON), time.Now())
+	_, err = tx.Exec(`
+		INSERT INTO configurations (version, config_data, created_by, created_at)
+		VALUES (?, ?, ?, ?)
+	`, newVersion, string(configJSON), author, now)
 	if err != nil {
 		return 0, err
 	}
 
+	if err := enqueueOutbox(tx, newVersion, transports, now); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
 	return newVersion, nil
 }
 
+// EnqueueActiveConfig queues the active configuration version for publishing and returns it.
+// The version is read in the same transaction so a concurrent update is never superseded.
+func (db *DB) EnqueueActiveConfig(transports ...string) (int64, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var version int64
+	if err := tx.QueryRow("SELECT version FROM active_config WHERE id = 1").Scan(&version); err != nil {
+		return 0, err
+	}
+
+	if err := enqueueOutbox(tx, version, transports, time.Now()); err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
+
+// PruneOutbox deletes delivered and superseded outbox entries created before the given time
+func (db *DB) PruneOutbox(before time.Time) (int64, error) {
+	result, err := db.conn.Exec(`
+		DELETE FROM config_outbox WHERE status != ? AND created_at < ?
+	`, models.OutboxPending, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func enqueueOutbox(tx *sql.Tx, version int64, transports []string, now time.Time) error {
+	for _, transport := range transports {
+		// Only the newest version matters to agents
+		_, err := tx.Exec(`
+			UPDATE config_outbox SET status = ? WHERE transport = ? AND status = ?
+		`, models.OutboxSuperseded, transport, models.OutboxPending)
+		if err != nil {
+			return fmt.Errorf("failed to supersede outbox entries: %w", err)
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO config_outbox (version, transport, status, next_attempt_at, created_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, version, transport, models.OutboxPending, now, now)
+		if err != nil {
+			return fmt.Errorf("failed to queue outbox entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// DueOutboxEntries returns pending outbox entries whose next attempt is due, oldest first
+func (db *DB) DueOutboxEntries(now time.Time, limit int) ([]models.OutboxEntry, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, version, transport, status, attempts, next_attempt_at, last_error, created_at, delivered_at
+		FROM config_outbox WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY id LIMIT ?
+	`, models.OutboxPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.OutboxEntry
+	for rows.Next() {
+		var entry models.OutboxEntry
+		var lastError sql.NullString
+		var deliveredAt sql.NullTime
+		err := rows.Scan(&entry.ID, &entry.Version, &entry.Transport, &entry.Status, &entry.Attempts,
+			&entry.NextAttemptAt, &lastError, &entry.CreatedAt, &deliveredAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.LastError = lastError.String
+		if deliveredAt.Valid {
+			entry.DeliveredAt = &deliveredAt.Time
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// MarkOutboxDelivered records a successful publish
+func (db *DB) MarkOutboxDelivered(id int64) error {
+	_, err := db.conn.Exec(`
+		UPDATE config_outbox SET status = ?, attempts = attempts + 1, last_error = NULL, delivered_at = ?
+		WHERE id = ?
+	`, models.OutboxDelivered, time.Now(), id)
+	return err
+}
+
+// MarkOutboxFailed records a failed publish and when to retry it
+func (db *DB) MarkOutboxFailed(id int64, publishErr error, nextAttempt time.Time) error {
+	_, err := db.conn.Exec(`
+		UPDATE config_outbox SET attempts = attempts + 1, last_error = ?, next_attempt_at = ?
+		WHERE id = ?
+	`, publishErr.Error(), nextAttempt, id)
+	return err
+}
+
+// OutboxBacklog returns the undelivered entries per transport
+func (db *DB) OutboxBacklog() (map[string]models.OutboxBacklog, error) {
+	rows, err := db.conn.Query(`
+		SELECT transport, created_at, last_error FROM config_outbox
+		WHERE status = ? ORDER BY id
+	`, models.OutboxPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	backlog := make(map[string]models.OutboxBacklog)
+	for rows.Next() {
+		var transport string
+		var createdAt time.Time
+		var lastError sql.NullString
+		if err := rows.Scan(&transport, &createdAt, &lastError); err != nil {
+			return nil, err
+		}
+
+		entry := backlog[transport]
+		entry.Pending++
+		if entry.OldestPendingAt == nil {
+			entry.OldestPendingAt = &createdAt
+		}
+		if lastError.Valid {
+			entry.LastError = lastError.String
+		}
+		backlog[transport] = entry
+	}
+
+	return backlog, rows.Err()
+}
+
+// RecordAck stores an agent's acknowledgement of a configuration version,
+// replacing an earlier one for the same agent and version
+func (db *DB) RecordAck(ack *models.ConfigAck) error {
+	var workers sql.NullString
+	if len(ack.Workers) > 0 {
+		data, err := json.Marshal(ack.Workers)
+		if err != nil {
+			return fmt.Errorf("failed to marshal worker results: %w", err)
+		}
+		workers = sql.NullString{String: string(data), Valid: true}
+	}
+
+	_, err := db.conn.Exec(`
+		INSERT OR REPLACE INTO config_acks (agent_id, version, success, error, latency_ms, acked_at, received_at, workers)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, ack.AgentID, ack.Version, ack.Success, ack.Error, ack.LatencyMs, ack.Timestamp, ack.ReceivedAt, workers)
+	return err
+}
+
+// GetDeliveryStatus summarises the acknowledgements for a configuration version
+// against the agents that registered, polled or acknowledged since activeSince.
+// Agents that went away or registered again under a new ID are not waited for.
+func (db *DB) GetDeliveryStatus(version int64, activeSince time.Time) (*models.DeliveryStatus, error) {
+	rows, err := db.conn.Query(`
+		SELECT agent_id, version, success, error, latency_ms, acked_at, received_at, workers
+		FROM config_acks WHERE version = ? ORDER BY received_at
+	`, version)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	status := &models.DeliveryStatus{Version: version, Acks: []models.ConfigAck{}, Pending: []string{}}
+	acked := make(map[string]bool)
+	for rows.Next() {
+		var ack models.ConfigAck
+		var ackErr, workers sql.NullString
+		err := rows.Scan(&ack.AgentID, &ack.Version, &ack.Success, &ackErr, &ack.LatencyMs, &ack.Timestamp, &ack.ReceivedAt, &workers)
+		if err != nil {
+			return nil, err
+		}
+		ack.Error = ackErr.String
+		if workers.Valid {
+			if err := json.Unmarshal([]byte(workers.String), &ack.Workers); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal worker results: %w", err)
+			}
+		}
+
+		acked[ack.AgentID] = true
+		if ack.Success {
+			status.Succeeded++
+		} else {
+			status.Failed++
+		}
+		status.Acks = append(status.Acks, ack)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	status.Acked = len(status.Acks)
+
+	agents, err := db.GetAllAgents()
+	if err != nil {
+		return nil, err
+	}
+	status.ExpectedAgents = len(acked)
+	for _, agent := range agents {
+		if acked[agent.ID] || (agent.RegisteredAt.Before(activeSince) && agent.LastPoll.Before(activeSince)) {
+			continue
+		}
+		status.ExpectedAgents++
+		status.Pending = append(status.Pending, agent.ID)
+	}
+	status.Complete = len(status.Pending) == 0 && status.Failed == 0
+
+	return status, nil
+}
+
 // GetAllAgents retrieves all registered agents
 func (db *DB) GetAllAgents() ([]models.Agent, error) {
 	rows, err := db.conn.Query(`
@@ -198,3 +583,451 @@ func (db *DB) GetAllAgents() ([]models.Agent, error) {
 
 	return agents, nil
 }
+
+// GetConfigVersion retrieves a historical configuration version
+func (db *DB) GetConfigVersion(version int64) (*models.Config, error) {
+	row := db.conn.QueryRow(`
+		SELECT id, version, config_data, created_by, created_at FROM configurations WHERE version = ?
+	`, version)
+
+	config, err := db.scanConfig(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return config, err
+}
+
+// ListConfigVersions retrieves the most recent configuration versions, newest first
+func (db *DB) ListConfigVersions(limit int) ([]models.Config, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, version, config_data, created_by, created_at FROM configurations
+		ORDER BY version DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var configs []models.Config
+	for rows.Next() {
+		config, err := db.scanConfig(rows)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, *config)
+	}
+
+	return configs, rows.Err()
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (db *DB) scanConfig(row rowScanner) (*models.Config, error) {
+	var config models.Config
+	var configData string
+	var createdBy sql.NullString
+
+	if err := row.Scan(&config.ID, &config.Version, &configData, &createdBy, &config.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	data, err := db.decodeConfig(configData)
+	if err != nil {
+		return nil, err
+	}
+	config.Data = data
+	config.CreatedBy = createdBy.String
+	config.UpdatedAt = config.CreatedAt
+
+	return &config, nil
+}
+
+// encodeConfig marshals a configuration for storage, encrypting secret values
+func (db *DB) encodeConfig(config models.WorkerConfig) ([]byte, error) {
+	if config.HasSecrets() {
+		sealed := make(map[string]models.Secret, len(config.SecretHeaders))
+		for name, value := range config.SecretHeaders {
+			encrypted, err := db.secrets.Encrypt(value.Value())
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt secret %s: %w", name, err)
+			}
+			sealed[name] = models.Secret(encrypted)
+		}
+		config.SecretHeaders = sealed
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return configJSON, nil
+}
+
+// decodeConfig unmarshals a stored configuration, decrypting secret values.
+// Encrypted values in plain headers were stored before secret headers had
+// their own field; they are moved over.
+func (db *DB) decodeConfig(configData string) (models.WorkerConfig, error) {
+	var config models.WorkerConfig
+	if err := json.Unmarshal([]byte(configData), &config); err != nil {
+		return config, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+
+	for name, value := range config.Headers {
+		if !secrets.IsEncrypted(value) {
+			continue
+		}
+		if config.SecretHeaders == nil {
+			config.SecretHeaders = make(map[string]models.Secret)
+		}
+		config.SecretHeaders[name] = models.Secret(value)
+		delete(config.Headers, name)
+	}
+	if len(config.Headers) == 0 {
+		config.Headers = nil
+	}
+
+	for name, value := range config.SecretHeaders {
+		plaintext, err := db.secrets.Decrypt(value.Value())
+		if err != nil {
+			return config, fmt.Errorf("failed to decrypt secret %s: %w", name, err)
+		}
+		config.SecretHeaders[name] = models.Secret(plaintext)
+	}
+
+	return config, nil
+}
+
+// CreateUser creates a new admin account
+func (db *DB) CreateUser(user *models.User) error {
+	now := time.Now()
+	_, err := db.conn.Exec(`
+		INSERT INTO users (username, password_hash, role, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, user.Username, user.PasswordHash, string(user.Role), now, now)
+	if err != nil {
+		return err
+	}
+
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	return nil
+}
+
+// GetUser retrieves an admin account by username
+func (db *DB) GetUser(username string) (*models.User, error) {
+	var user models.User
+	var role string
+
+	err := db.conn.QueryRow(`
+		SELECT username, password_hash, role, created_at, updated_at FROM users WHERE username = ?
+	`, username).Scan(&user.Username, &user.PasswordHash, &role, &user.CreatedAt, &user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user.Role = models.Role(role)
+	return &user, nil
+}
+
+// ListUsers retrieves all admin accounts
+func (db *DB) ListUsers() ([]models.User, error) {
+	rows, err := db.conn.Query(`
+		SELECT username, role, created_at, updated_at FROM users ORDER BY username
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		var role string
+		if err := rows.Scan(&user.Username, &role, &user.CreatedAt, &user.UpdatedAt); err != nil {
+			return nil, err
+		}
+		user.Role = models.Role(role)
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// UpdateUser updates the role and password hash of an admin account. Demoting
+// the last admin fails with ErrLastAdmin; the check is part of the update, so
+// concurrent demotions cannot remove every admin.
+func (db *DB) UpdateUser(user *models.User) error {
+	now := time.Now()
+	result, err := db.conn.Exec(`
+		UPDATE users SET password_hash = ?, role = ?, updated_at = ?
+		WHERE username = ? AND (role != ? OR ? = ? OR (SELECT COUNT(*) FROM users WHERE role = ?) > 1)
+	`, user.PasswordHash, string(user.Role), now, user.Username,
+		string(models.RoleAdmin), string(user.Role), string(models.RoleAdmin), string(models.RoleAdmin))
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return db.userGuardError(user.Username)
+	}
+	user.UpdatedAt = now
+	return nil
+}
+
+// DeleteUser removes an admin account. Deleting the last admin fails with
+// ErrLastAdmin; the check is part of the delete, so concurrent deletions cannot
+// remove every admin.
+func (db *DB) DeleteUser(username string) error {
+	result, err := db.conn.Exec(`
+		DELETE FROM users
+		WHERE username = ? AND (role != ? OR (SELECT COUNT(*) FROM users WHERE role = ?) > 1)
+	`, username, string(models.RoleAdmin), string(models.RoleAdmin))
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return db.userGuardError(username)
+	}
+	return nil
+}
+
+// userGuardError explains why a guarded write to an account changed nothing
+func (db *DB) userGuardError(username string) error {
+	var count int
+	if err := db.conn.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", username).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrLastAdmin
+}
+
+// CountUsers returns the number of admin accounts, optionally filtered by role
+func (db *DB) CountUsers(role models.Role) (int, error) {
+	var count int
+	var err error
+	if role == "" {
+		err = db.conn.QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+	} else {
+		err = db.conn.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", string(role)).Scan(&count)
+	}
+	return count, err
+}
+
+// CreateAPIKey stores a new API key
+func (db *DB) CreateAPIKey(key *models.APIKey) error {
+	scopes := make([]string, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		scopes[i] = string(scope)
+	}
+
+	_, err := db.conn.Exec(`
+		INSERT INTO api_keys (id, name, key_hash, scopes, expires_at, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, key.ID, key.Name, key.KeyHash, strings.Join(scopes, ","), key.ExpiresAt, key.CreatedBy, key.CreatedAt)
+	return err
+}
+
+// GetAPIKeyByHash retrieves an API key by the hash of its token
+func (db *DB) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	row := db.conn.QueryRow(`
+		SELECT id, name, key_hash, scopes, expires_at, last_used_at, revoked_at, created_by, created_at
+		FROM api_keys WHERE key_hash = ?
+	`, keyHash)
+
+	key, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return key, err
+}
+
+// ListAPIKeys retrieves all API keys, including revoked and expired ones
+func (db *DB) ListAPIKeys() ([]models.APIKey, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, name, key_hash, scopes, expires_at, last_used_at, revoked_at, created_by, created_at
+		FROM api_keys ORDER BY created_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey marks an API key as revoked
+func (db *DB) RevokeAPIKey(id string) error {
+	result, err := db.conn.Exec(`
+		UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL
+	`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// TouchAPIKey records that an API key has just been used
+func (db *DB) TouchAPIKey(id string) error {
+	_, err := db.conn.Exec(`
+		UPDATE api_keys SET last_used_at = ? WHERE id = ?
+	`, time.Now(), id)
+	return err
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var lastUsed, revoked sql.NullTime
+	var createdBy sql.NullString
+
+	err := row.Scan(&key.ID, &key.Name, &key.KeyHash, &scopes, &key.ExpiresAt,
+		&lastUsed, &revoked, &createdBy, &key.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope != "" {
+			key.Scopes = append(key.Scopes, models.APIKeyScope(scope))
+		}
+	}
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		key.RevokedAt = &revoked.Time
+	}
+	key.CreatedBy = createdBy.String
+
+	return &key, nil
+}
+
+// CreateEnrollmentToken stores a new bootstrap token
+func (db *DB) CreateEnrollmentToken(token *models.EnrollmentToken) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO enrollment_tokens (id, token_hash, expires_at, max_uses, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, token.ID, token.TokenHash, token.ExpiresAt, token.MaxUses, token.CreatedBy, token.CreatedAt)
+	return err
+}
+
+// ConsumeEnrollmentToken records one use of a bootstrap token. It returns
+// ErrNotFound if the token is unknown, expired or has no uses left.
+func (db *DB) ConsumeEnrollmentToken(tokenHash string) error {
+	result, err := db.conn.Exec(`
+		UPDATE enrollment_tokens SET uses = uses + 1
+		WHERE token_hash = ? AND uses < max_uses AND expires_at > ?
+	`, tokenHash, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordCertificate stores a certificate issued by the internal CA
+func (db *DB) RecordCertificate(cert *models.IssuedCertificate) error {
+	_, err := db.conn.Exec(`
+		INSERT INTO certificates (serial, agent_id, issued_at, not_after)
+		VALUES (?, ?, ?, ?)
+	`, cert.Serial, cert.AgentID, cert.IssuedAt, cert.NotAfter)
+	return err
+}
+
+// ListCertificates retrieves all certificates issued by the internal CA, newest first
+func (db *DB) ListCertificates() ([]models.IssuedCertificate, error) {
+	rows, err := db.conn.Query(`
+		SELECT serial, agent_id, issued_at, not_after, revoked_at FROM certificates ORDER BY issued_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var certs []models.IssuedCertificate
+	for rows.Next() {
+		var cert models.IssuedCertificate
+		var revoked sql.NullTime
+		if err := rows.Scan(&cert.Serial, &cert.AgentID, &cert.IssuedAt, &cert.NotAfter, &revoked); err != nil {
+			return nil, err
+		}
+		if revoked.Valid {
+			cert.RevokedAt = &revoked.Time
+		}
+		certs = append(certs, cert)
+	}
+
+	return certs, rows.Err()
+}
+
+// RevokeCertificate marks an issued certificate as revoked
+func (db *DB) RevokeCertificate(serial string) error {
+	result, err := db.conn.Exec(`
+		UPDATE certificates SET revoked_at = ? WHERE serial = ? AND revoked_at IS NULL
+	`, time.Now(), serial)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// IsCertificateRevoked reports whether an issued certificate has been revoked
+func (db *DB) IsCertificateRevoked(serial string) (bool, error) {
+	var count int
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*) FROM certificates WHERE serial = ? AND revoked_at IS NOT NULL
+	`, serial).Scan(&count)
+	return count > 0, err
+}
+
+// RevokedCertificates returns the revocation time of each revoked certificate
+// that has not yet expired
+func (db *DB) RevokedCertificates() (map[string]time.Time, error) {
+	rows, err := db.conn.Query(`
+		SELECT serial, revoked_at FROM certificates WHERE revoked_at IS NOT NULL AND not_after > ?
+	`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var serial string
+		var revokedAt time.Time
+		if err := rows.Scan(&serial, &revokedAt); err != nil {
+			return nil, err
+		}
+		revoked[serial] = revokedAt
+	}
+
+	return revoked, rows.Err()
+}
@@ -1,10 +1,14 @@
 package database
 
 import (
+	"bytes"
+	"errors"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/doniyusdinar/config-management/controller/internal/secrets"
 	"github.com/doniyusdinar/config-management/pkg/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -157,3 +161,261 @@ func TestConfigVersionIncrement(t *testing.T) {
 		assert.Equal(t, int64(i), config.Version)
 	}
 }
+
+func TestUserCRUD(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user := &models.User{Username: "alice", PasswordHash: "hash", Role: models.RoleViewer}
+	require.NoError(t, db.CreateUser(user))
+
+	stored, err := db.GetUser("alice")
+	require.NoError(t, err)
+	assert.Equal(t, models.RoleViewer, stored.Role)
+	assert.Equal(t, "hash", stored.PasswordHash)
+
+	stored.Role = models.RoleOperator
+	require.NoError(t, db.UpdateUser(stored))
+
+	count, err := db.CountUsers(models.RoleOperator)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	require.NoError(t, db.DeleteUser("alice"))
+	_, err = db.GetUser("alice")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, db.DeleteUser("alice"))
+}
+
+func TestLastAdminCannotBeRemoved(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, name := range []string{"alice", "bob"} {
+		require.NoError(t, db.CreateUser(&models.User{Username: name, PasswordHash: "hash", Role: models.RoleAdmin}))
+	}
+
+	// Concurrent removals of the two admins leave exactly one behind
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = db.DeleteUser("alice")
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = db.UpdateUser(&models.User{Username: "bob", PasswordHash: "hash", Role: models.RoleViewer})
+	}()
+	wg.Wait()
+
+	assert.ElementsMatch(t, []error{nil, ErrLastAdmin}, errs)
+	count, err := db.CountUsers(models.RoleAdmin)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// The remaining admin can still change its password, but not its role
+	var admin string
+	if errs[0] == nil {
+		admin = "bob"
+	} else {
+		admin = "alice"
+	}
+	assert.NoError(t, db.UpdateUser(&models.User{Username: admin, PasswordHash: "new", Role: models.RoleAdmin}))
+	assert.Equal(t, ErrLastAdmin, db.UpdateUser(&models.User{Username: admin, PasswordHash: "new", Role: models.RoleOperator}))
+	assert.Equal(t, ErrLastAdmin, db.DeleteUser(admin))
+	assert.Equal(t, ErrNotFound, db.UpdateUser(&models.User{Username: "carol", Role: models.RoleViewer}))
+}
+
+func TestConfigVersionHistory(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, err := db.UpdateConfigAs(models.WorkerConfig{URL: "https://example.com"}, 30, "alice")
+	require.NoError(t, err)
+	_, err = db.UpdateConfig(models.WorkerConfig{URL: "https://example.org"}, 30)
+	require.NoError(t, err)
+
+	versions, err := db.ListConfigVersions(10)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, int64(3), versions[0].Version)
+	assert.Equal(t, "https://example.com", versions[1].Data.URL)
+	assert.Equal(t, "alice", versions[1].CreatedBy)
+
+	config, err := db.GetConfigVersion(2)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", config.Data.URL)
+
+	_, err = db.GetConfigVersion(99)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestSecretsEncryptedAtRest(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	config := models.WorkerConfig{
+		URL:           "https://api.example.com",
+		SecretHeaders: map[string]models.Secret{"Authorization": "Bearer s3cret"},
+	}
+
+	// Without a key-encryption key, secrets are refused rather than stored in plain text
+	_, err := db.UpdateConfig(config, 30)
+	assert.ErrorIs(t, err, secrets.ErrNoKey)
+
+	cipher, err := secrets.NewCipher(bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+	db.SetSecretsCipher(cipher)
+
+	version, err := db.UpdateConfig(config, 30)
+	require.NoError(t, err)
+
+	var activeData, historyData string
+	require.NoError(t, db.conn.QueryRow("SELECT config_data FROM active_config WHERE id = 1").Scan(&activeData))
+	require.NoError(t, db.conn.QueryRow("SELECT config_data FROM configurations WHERE version = ?", version).Scan(&historyData))
+	assert.NotContains(t, activeData, "s3cret")
+	assert.NotContains(t, historyData, "s3cret")
+
+	active, err := db.GetActiveConfig()
+	require.NoError(t, err)
+	assert.Equal(t, models.Secret("Bearer s3cret"), active.Data.SecretHeaders["Authorization"])
+
+	stored, err := db.GetConfigVersion(version)
+	require.NoError(t, err)
+	assert.Equal(t, models.Secret("Bearer s3cret"), stored.Data.SecretHeaders["Authorization"])
+
+	// A different key cannot open the stored values
+	other, err := secrets.NewCipher(bytes.Repeat([]byte{9}, 32))
+	require.NoError(t, err)
+	db.SetSecretsCipher(other)
+	_, err = db.GetActiveConfig()
+	assert.Error(t, err)
+}
+
+func TestPlainHeadersStoredWithoutKey(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Ordinary headers are not secrets and need no key-encryption key
+	config := models.WorkerConfig{URL: "https://api.example.com", Headers: map[string]string{"Accept": "application/json"}}
+	_, err := db.UpdateConfig(config, 30)
+	require.NoError(t, err)
+
+	active, err := db.GetActiveConfig()
+	require.NoError(t, err)
+	assert.Equal(t, config, active.Data)
+	assert.False(t, active.Data.HasSecrets())
+
+	// Secrets encrypted into the plain headers field by earlier versions are moved over
+	cipher, err := secrets.NewCipher(bytes.Repeat([]byte{7}, 32))
+	require.NoError(t, err)
+	db.SetSecretsCipher(cipher)
+	sealed, err := cipher.Encrypt("Bearer s3cret")
+	require.NoError(t, err)
+	legacy, err := db.decodeConfig(`{"url": "https://api.example.com", "headers": {"Accept": "application/json", "Authorization": "` + sealed + `"}}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"Accept": "application/json"}, legacy.Headers)
+	assert.Equal(t, models.Secret("Bearer s3cret"), legacy.SecretHeaders["Authorization"])
+}
+
+func TestConfigOutbox(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	v1, err := db.UpdateConfigAs(models.WorkerConfig{URL: "https://example.com"}, 30, "alice", "redis", "nats")
+	require.NoError(t, err)
+
+	now := time.Now()
+	entries, err := db.DueOutboxEntries(now, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, v1, entries[0].Version)
+	assert.Equal(t, "redis", entries[0].Transport)
+	assert.Equal(t, models.OutboxPending, entries[0].Status)
+
+	require.NoError(t, db.MarkOutboxDelivered(entries[0].ID))
+	require.NoError(t, db.MarkOutboxFailed(entries[1].ID, errors.New("connection refused"), now.Add(time.Minute)))
+
+	// Not due until the retry time
+	entries, err = db.DueOutboxEntries(now, 10)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	backlog, err := db.OutboxBacklog()
+	require.NoError(t, err)
+	assert.Equal(t, 1, backlog["nats"].Pending)
+	assert.Equal(t, "connection refused", backlog["nats"].LastError)
+	assert.NotContains(t, backlog, "redis")
+
+	// A newer version supersedes the undelivered one
+	v2, err := db.UpdateConfigAs(models.WorkerConfig{URL: "https://example.org"}, 30, "alice", "nats")
+	require.NoError(t, err)
+
+	entries, err = db.DueOutboxEntries(now.Add(time.Second), 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, v2, entries[0].Version)
+	assert.Equal(t, 0, entries[0].Attempts)
+
+	active, err := db.EnqueueActiveConfig("redis")
+	require.NoError(t, err)
+	assert.Equal(t, v2, active)
+	backlog, err = db.OutboxBacklog()
+	require.NoError(t, err)
+	assert.Equal(t, 1, backlog["nats"].Pending)
+	assert.Equal(t, "", backlog["nats"].LastError)
+	assert.Equal(t, 1, backlog["redis"].Pending)
+
+	// Only settled entries are pruned
+	pruned, err := db.PruneOutbox(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), pruned)
+}
+
+func TestDeliveryStatus(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for _, id := range []string{"agent-1", "agent-2", "agent-3"} {
+		require.NoError(t, db.RegisterAgent(&models.Agent{ID: id, RegisteredAt: time.Now()}))
+	}
+
+	now := time.Now()
+	require.NoError(t, db.RecordAck(&models.ConfigAck{AgentID: "agent-1", Version: 2, Success: true, LatencyMs: 40, Timestamp: now, ReceivedAt: now}))
+	require.NoError(t, db.RecordAck(&models.ConfigAck{AgentID: "agent-2", Version: 2, Error: "worker unavailable", Timestamp: now, ReceivedAt: now}))
+
+	status, err := db.GetDeliveryStatus(2, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3, status.ExpectedAgents)
+	assert.Equal(t, 2, status.Acked)
+	assert.Equal(t, 1, status.Succeeded)
+	assert.Equal(t, 1, status.Failed)
+	assert.Equal(t, []string{"agent-3"}, status.Pending)
+	assert.False(t, status.Complete)
+
+	// A later successful retry replaces the failure
+	require.NoError(t, db.RecordAck(&models.ConfigAck{AgentID: "agent-2", Version: 2, Success: true, Timestamp: now, ReceivedAt: now}))
+	require.NoError(t, db.RecordAck(&models.ConfigAck{AgentID: "agent-3", Version: 2, Success: true, Timestamp: now, ReceivedAt: now}))
+
+	status, err = db.GetDeliveryStatus(2, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3, status.Succeeded)
+	assert.Empty(t, status.Pending)
+	assert.True(t, status.Complete)
+
+	// An agent that went silent, e.g. replaced by a new registration, is not waited for
+	require.NoError(t, db.RegisterAgent(&models.Agent{ID: "agent-old", RegisteredAt: now.Add(-2 * time.Hour)}))
+	status, err = db.GetDeliveryStatus(2, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 3, status.ExpectedAgents)
+	assert.True(t, status.Complete)
+
+	// Once it polls again it is expected
+	require.NoError(t, db.UpdateAgentPoll("agent-old"))
+	status, err = db.GetDeliveryStatus(2, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 4, status.ExpectedAgents)
+	assert.Equal(t, []string{"agent-old"}, status.Pending)
+	assert.False(t, status.Complete)
+}
@@ -0,0 +1,319 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/doniyusdinar/config-management/controller/internal/database"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+)
+
+// Transport names recorded in the outbox
+const (
+	TransportRedis = "redis"
+	TransportNats  = "nats"
+	TransportKafka = "kafka"
+)
+
+const batchSize = 100
+
+const (
+	// outboxRetention is how long delivered entries are kept for inspection
+	outboxRetention = 24 * time.Hour
+	// pruneInterval is how often expired entries are deleted
+	pruneInterval = time.Hour
+)
+
+// Publisher delivers a configuration envelope over one transport
+type Publisher interface {
+	Publish(envelope *models.ConfigEnvelope) error
+}
+
+// TargetPublisher is implemented by publishers that can address a single
+// environment, group or agent
+type TargetPublisher interface {
+	PublishTarget(environment, group, agentID string, envelope *models.ConfigEnvelope) error
+}
+
+// EnvelopeFunc builds the envelope published for a configuration version
+type EnvelopeFunc func(version int64) (*models.ConfigEnvelope, error)
+
+// Dispatcher fans queued outbox entries out to every registered transport,
+// retrying each transport with exponential backoff until it succeeds or a newer
+// version supersedes the entry. A failing transport does not delay the others.
+type Dispatcher struct {
+	db         *database.DB
+	envelope   EnvelopeFunc
+	interval   time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	notify     chan struct{}
+
+	mu         sync.RWMutex
+	publishers map[string]Publisher
+	status     map[string]*models.TransportStatus
+}
+
+// NewDispatcher creates a dispatcher that checks for due entries every interval
+func NewDispatcher(db *database.DB, envelope EnvelopeFunc, interval, minBackoff, maxBackoff time.Duration) *Dispatcher {
+	return &Dispatcher{
+		db:         db,
+		envelope:   envelope,
+		interval:   interval,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+		notify:     make(chan struct{}, 1),
+		publishers: make(map[string]Publisher),
+		status:     make(map[string]*models.TransportStatus),
+	}
+}
+
+// Register adds the publisher for a transport
+func (d *Dispatcher) Register(transport string, publisher Publisher) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.publishers[transport] = publisher
+	if _, ok := d.status[transport]; !ok {
+		d.status[transport] = &models.TransportStatus{}
+	}
+}
+
+// Transports returns the registered transport names, sorted
+func (d *Dispatcher) Transports() []string {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	transports := make([]string, 0, len(d.publishers))
+	for transport := range d.publishers {
+		transports = append(transports, transport)
+	}
+	sort.Strings(transports)
+	return transports
+}
+
+// Status returns the connection state and delivery counters of each
+// registered transport
+func (d *Dispatcher) Status() map[string]models.TransportStatus {
+	if d == nil {
+		return nil
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	status := make(map[string]models.TransportStatus, len(d.publishers))
+	for transport, publisher := range d.publishers {
+		s := *d.status[transport]
+		if conn, ok := publisher.(Connectivity); ok {
+			connected := conn.IsConnected()
+			s.Connected = &connected
+		}
+		status[transport] = s
+	}
+	return status
+}
+
+// record updates the delivery counters of a transport after a publish attempt
+func (d *Dispatcher) record(transport string, now time.Time, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	s, ok := d.status[transport]
+	if !ok {
+		// Entries queued for a transport that is no longer registered
+		return
+	}
+	if err == nil {
+		s.Published++
+		s.LastPublishedAt = &now
+		return
+	}
+	s.Failed++
+	s.LastError = err.Error()
+	s.LastErrorAt = &now
+}
+
+// Notify wakes the dispatcher after new entries were queued (non-blocking)
+func (d *Dispatcher) Notify() {
+	if d == nil {
+		return
+	}
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Start dispatches due entries, and prunes delivered ones past their
+// retention, until the context is cancelled
+func (d *Dispatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+
+	logger.Log.Infof("Outbox dispatcher started for transports %v", d.Transports())
+
+	for {
+		if err := d.Dispatch(time.Now()); err != nil {
+			logger.Log.Errorf("Outbox dispatch failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			logger.Log.Info("Outbox dispatcher stopped")
+			return
+		case <-ticker.C:
+		case <-d.notify:
+		case now := <-prune.C:
+			if _, err := d.prune(now); err != nil {
+				logger.Log.Warnf("Failed to prune outbox: %v", err)
+			}
+		}
+	}
+}
+
+// prune deletes delivered and superseded entries older than the retention
+func (d *Dispatcher) prune(now time.Time) (int64, error) {
+	return d.db.PruneOutbox(now.Add(-outboxRetention))
+}
+
+// Dispatch attempts delivery of every entry that is due at now. Each transport
+// publishes its entries in order on its own goroutine, so a slow or failing
+// transport does not delay the others.
+func (d *Dispatcher) Dispatch(now time.Time) error {
+	entries, err := d.db.DueOutboxEntries(now, batchSize)
+	if err != nil {
+		return fmt.Errorf("failed to load outbox entries: %w", err)
+	}
+
+	// Build each version's envelope once, before the transports share it
+	envelopes := make(map[int64]*models.ConfigEnvelope)
+	envelopeErrs := make(map[int64]error)
+	byTransport := make(map[string][]models.OutboxEntry)
+	for _, entry := range entries {
+		if _, ok := envelopes[entry.Version]; !ok && envelopeErrs[entry.Version] == nil {
+			envelope, err := d.envelope(entry.Version)
+			if err != nil {
+				envelopeErrs[entry.Version] = fmt.Errorf("failed to build envelope: %w", err)
+			} else {
+				envelopes[entry.Version] = envelope
+			}
+		}
+		byTransport[entry.Transport] = append(byTransport[entry.Transport], entry)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(byTransport))
+	for transport, queued := range byTransport {
+		wg.Add(1)
+		go func(transport string, queued []models.OutboxEntry) {
+			defer wg.Done()
+			for _, entry := range queued {
+				publishErr := envelopeErrs[entry.Version]
+				if publishErr == nil {
+					publishErr = d.deliver(entry, envelopes[entry.Version])
+				}
+				if err := d.settle(entry, now, publishErr); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(transport, queued)
+	}
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// settle records the outcome of publishing an entry and schedules a retry on failure
+func (d *Dispatcher) settle(entry models.OutboxEntry, now time.Time, publishErr error) error {
+	d.record(entry.Transport, now, publishErr)
+	if publishErr == nil {
+		if err := d.db.MarkOutboxDelivered(entry.ID); err != nil {
+			return fmt.Errorf("failed to mark outbox entry %d delivered: %w", entry.ID, err)
+		}
+		logger.Log.Infof("Published config version %d to %s", entry.Version, entry.Transport)
+		return nil
+	}
+
+	retry := d.backoff(entry.Attempts + 1)
+	logger.Log.Warnf("Failed to publish config version %d to %s (attempt %d, retrying in %s): %v",
+		entry.Version, entry.Transport, entry.Attempts+1, retry, publishErr)
+	if err := d.db.MarkOutboxFailed(entry.ID, publishErr, now.Add(retry)); err != nil {
+		return fmt.Errorf("failed to mark outbox entry %d failed: %w", entry.ID, err)
+	}
+	return nil
+}
+
+func (d *Dispatcher) publisher(transport string) Publisher {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.publishers[transport]
+}
+
+func (d *Dispatcher) deliver(entry models.OutboxEntry, envelope *models.ConfigEnvelope) error {
+	publisher := d.publisher(entry.Transport)
+	if publisher == nil {
+		return fmt.Errorf("transport %s is not configured", entry.Transport)
+	}
+	return publisher.Publish(envelope)
+}
+
+// PublishTarget publishes an envelope to one environment, group or agent over
+// every registered transport that supports targeting, and returns the outcome
+// per transport. Targeted publishes are not queued in the outbox, but their
+// outcomes are counted in the transport status.
+func (d *Dispatcher) PublishTarget(environment, group, agentID string, envelope *models.ConfigEnvelope) map[string]error {
+	targets := make(map[string]TargetPublisher)
+	d.mu.RLock()
+	for transport, publisher := range d.publishers {
+		if target, ok := publisher.(TargetPublisher); ok {
+			targets[transport] = target
+		}
+	}
+	d.mu.RUnlock()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make(map[string]error, len(targets))
+	)
+	for transport, target := range targets {
+		wg.Add(1)
+		go func(transport string, target TargetPublisher) {
+			defer wg.Done()
+			err := target.PublishTarget(environment, group, agentID, envelope)
+			d.record(transport, time.Now(), err)
+
+			mu.Lock()
+			results[transport] = err
+			mu.Unlock()
+		}(transport, target)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// backoff returns the delay before the given attempt is retried
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.minBackoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,273 @@
+package outbox
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/controller/internal/database"
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyPublisher fails until it is told to recover
+type flakyPublisher struct {
+	mu        sync.Mutex
+	failing   bool
+	published []int64
+}
+
+func (p *flakyPublisher) Publish(envelope *models.ConfigEnvelope) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failing {
+		return errors.New("connection refused")
+	}
+	p.published = append(p.published, envelope.Version)
+	return nil
+}
+
+func newTestDispatcher(t *testing.T) (*Dispatcher, *database.DB) {
+	db, err := database.New(filepath.Join(t.TempDir(), "controller.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	envelope := func(version int64) (*models.ConfigEnvelope, error) {
+		return models.NewConfigEnvelope(version, models.WorkerConfig{URL: "https://ip.me"}, "test")
+	}
+	return NewDispatcher(db, envelope, time.Second, time.Second, 4*time.Second), db
+}
+
+func TestDispatchRetriesWithBackoff(t *testing.T) {
+	d, db := newTestDispatcher(t)
+	redis := &flakyPublisher{failing: true}
+	nats := &flakyPublisher{}
+	d.Register(TransportRedis, redis)
+	d.Register(TransportNats, nats)
+
+	version, err := db.UpdateConfigAs(models.WorkerConfig{URL: "https://ip.me"}, 30, "alice", d.Transports()...)
+	require.NoError(t, err)
+
+	now := time.Now()
+	require.NoError(t, d.Dispatch(now))
+	assert.Equal(t, []int64{version}, nats.published)
+
+	backlog, err := db.OutboxBacklog()
+	require.NoError(t, err)
+	assert.Equal(t, 1, backlog[TransportRedis].Pending)
+	assert.Equal(t, "connection refused", backlog[TransportRedis].LastError)
+	assert.NotContains(t, backlog, TransportNats)
+
+	// Retried after 1s, then 2s, then capped at 4s
+	require.NoError(t, d.Dispatch(now.Add(500*time.Millisecond)))
+	entries, err := db.DueOutboxEntries(now.Add(time.Second), 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].Attempts)
+
+	assert.Equal(t, 2*time.Second, d.backoff(2))
+	assert.Equal(t, 4*time.Second, d.backoff(10))
+
+	redis.failing = false
+	require.NoError(t, d.Dispatch(now.Add(time.Second)))
+	assert.Equal(t, []int64{version}, redis.published)
+
+	backlog, err = db.OutboxBacklog()
+	require.NoError(t, err)
+	assert.Empty(t, backlog)
+}
+
+func TestDispatchUnconfiguredTransport(t *testing.T) {
+	d, db := newTestDispatcher(t)
+
+	_, err := db.UpdateConfigAs(models.WorkerConfig{URL: "https://ip.me"}, 30, "alice", TransportNats)
+	require.NoError(t, err)
+	require.NoError(t, d.Dispatch(time.Now()))
+
+	backlog, err := db.OutboxBacklog()
+	require.NoError(t, err)
+	assert.Equal(t, 1, backlog[TransportNats].Pending)
+	assert.Contains(t, backlog[TransportNats].LastError, "not configured")
+}
+
+func TestDispatchStatusPerTransport(t *testing.T) {
+	d, db := newTestDispatcher(t)
+	redis := &switchablePublisher{flakyPublisher: flakyPublisher{failing: true}}
+	nats := &flakyPublisher{}
+	kafka := &switchablePublisher{connected: true}
+	d.Register(TransportRedis, redis)
+	d.Register(TransportNats, nats)
+	d.Register(TransportKafka, kafka)
+
+	_, err := db.UpdateConfigAs(models.WorkerConfig{URL: "https://ip.me"}, 30, "alice", d.Transports()...)
+	require.NoError(t, err)
+	require.NoError(t, d.Dispatch(time.Now()))
+
+	status := d.Status()
+	require.Len(t, status, 3)
+
+	assert.Equal(t, int64(0), status[TransportRedis].Published)
+	assert.Equal(t, int64(1), status[TransportRedis].Failed)
+	assert.Equal(t, "connection refused", status[TransportRedis].LastError)
+	require.NotNil(t, status[TransportRedis].Connected)
+	assert.False(t, *status[TransportRedis].Connected)
+
+	// A failing transport does not hold back the others
+	assert.Equal(t, int64(1), status[TransportNats].Published)
+	assert.NotNil(t, status[TransportNats].LastPublishedAt)
+	assert.Nil(t, status[TransportNats].Connected)
+	assert.Equal(t, int64(1), status[TransportKafka].Published)
+	assert.True(t, *status[TransportKafka].Connected)
+}
+
+func TestPruneKeepsEntriesForRetention(t *testing.T) {
+	d, db := newTestDispatcher(t)
+	d.Register(TransportNats, &flakyPublisher{})
+
+	_, err := db.UpdateConfigAs(models.WorkerConfig{URL: "https://ip.me"}, 30, "alice", TransportNats)
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, d.Dispatch(now))
+
+	pruned, err := d.prune(now)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), pruned)
+
+	pruned, err = d.prune(now.Add(outboxRetention + time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pruned)
+}
+
+// targetPublisher records the targets it publishes to
+type targetPublisher struct {
+	flakyPublisher
+	targets []string
+}
+
+func (p *targetPublisher) PublishTarget(environment, group, agentID string, envelope *models.ConfigEnvelope) error {
+	if err := p.Publish(envelope); err != nil {
+		return err
+	}
+	p.targets = append(p.targets, environment+"."+group+"."+agentID)
+	return nil
+}
+
+func TestPublishTargetRecordsStatus(t *testing.T) {
+	d, _ := newTestDispatcher(t)
+	redis := &flakyPublisher{}
+	nats := &targetPublisher{}
+	kafka := &targetPublisher{flakyPublisher: flakyPublisher{failing: true}}
+	d.Register(TransportRedis, redis)
+	d.Register(TransportNats, nats)
+	d.Register(TransportKafka, kafka)
+
+	envelope, err := models.NewConfigEnvelope(3, models.WorkerConfig{URL: "https://ip.me"}, "test")
+	require.NoError(t, err)
+	results := d.PublishTarget("prod", "eu", "", envelope)
+
+	// Transports without targeting are skipped
+	require.Len(t, results, 2)
+	assert.NoError(t, results[TransportNats])
+	assert.EqualError(t, results[TransportKafka], "connection refused")
+	assert.Empty(t, redis.published)
+	assert.Equal(t, []string{"prod.eu."}, nats.targets)
+
+	status := d.Status()
+	assert.Equal(t, int64(1), status[TransportNats].Published)
+	assert.Equal(t, int64(1), status[TransportKafka].Failed)
+	assert.Equal(t, int64(0), status[TransportRedis].Published+status[TransportRedis].Failed)
+}
+
+// blockingPublisher waits for release before publishing
+type blockingPublisher struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *blockingPublisher) Publish(envelope *models.ConfigEnvelope) error {
+	close(p.started)
+	<-p.release
+	return nil
+}
+
+func TestDispatchPublishesTransportsConcurrently(t *testing.T) {
+	d, db := newTestDispatcher(t)
+	redis := &blockingPublisher{started: make(chan struct{}), release: make(chan struct{})}
+	nats := &flakyPublisher{}
+	d.Register(TransportRedis, redis)
+	d.Register(TransportNats, nats)
+
+	version, err := db.UpdateConfigAs(models.WorkerConfig{URL: "https://ip.me"}, 30, "alice", d.Transports()...)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() { done <- d.Dispatch(time.Now()) }()
+
+	// A hanging transport does not hold back the others
+	<-redis.started
+	assert.Eventually(t, func() bool {
+		nats.mu.Lock()
+		defer nats.mu.Unlock()
+		return len(nats.published) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	close(redis.release)
+	require.NoError(t, <-done)
+	assert.Equal(t, []int64{version}, nats.published)
+
+	backlog, err := db.OutboxBacklog()
+	require.NoError(t, err)
+	assert.Empty(t, backlog)
+}
+
+// switchablePublisher reports a connection state that the test controls
+type switchablePublisher struct {
+	flakyPublisher
+	connected bool
+}
+
+func (p *switchablePublisher) IsConnected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.connected
+}
+
+func TestRebroadcastOnReconnect(t *testing.T) {
+	d, db := newTestDispatcher(t)
+	redis := &switchablePublisher{connected: true}
+	nats := &switchablePublisher{}
+	d.Register(TransportRedis, redis)
+	d.Register(TransportNats, nats)
+
+	r := NewRebroadcaster(db, d, 0, time.Second)
+	assert.Empty(t, r.checkConnectivity())
+
+	r.rebroadcast("startup", d.Transports()...)
+	require.NoError(t, d.Dispatch(time.Now()))
+	assert.Equal(t, []int64{1}, redis.published)
+	assert.Equal(t, []int64{1}, nats.published)
+
+	nats.mu.Lock()
+	nats.connected = true
+	nats.mu.Unlock()
+	assert.Equal(t, []string{TransportNats}, r.checkConnectivity())
+	assert.Empty(t, r.checkConnectivity())
+}
+
+func TestRebroadcastDoesNotSupersedeNewerVersion(t *testing.T) {
+	d, db := newTestDispatcher(t)
+	d.Register(TransportNats, &flakyPublisher{failing: true})
+
+	r := NewRebroadcaster(db, d, 0, time.Second)
+	version, err := db.UpdateConfigAs(models.WorkerConfig{URL: "https://ip.me"}, 30, "alice", TransportNats)
+	require.NoError(t, err)
+	r.rebroadcast("periodic", TransportNats)
+
+	entries, err := db.DueOutboxEntries(time.Now(), 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, version, entries[0].Version)
+}
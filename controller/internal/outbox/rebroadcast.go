@@ -0,0 +1,102 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/doniyusdinar/config-management/controller/internal/database"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+)
+
+// Connectivity is implemented by publishers that can report their connection state
+type Connectivity interface {
+	IsConnected() bool
+}
+
+// Rebroadcaster periodically republishes the active configuration so push-mode
+// agents that missed a message converge without waiting for the next change.
+// It also republishes on startup and when a transport reconnects.
+type Rebroadcaster struct {
+	db            *database.DB
+	dispatcher    *Dispatcher
+	interval      time.Duration
+	checkInterval time.Duration
+	connected     map[string]bool
+}
+
+// NewRebroadcaster creates a rebroadcaster. An interval of zero disables the
+// periodic rebroadcast; connectivity is checked every checkInterval.
+func NewRebroadcaster(db *database.DB, dispatcher *Dispatcher, interval, checkInterval time.Duration) *Rebroadcaster {
+	return &Rebroadcaster{
+		db:            db,
+		dispatcher:    dispatcher,
+		interval:      interval,
+		checkInterval: checkInterval,
+		connected:     make(map[string]bool),
+	}
+}
+
+// Start rebroadcasts until the context is cancelled
+func (r *Rebroadcaster) Start(ctx context.Context) {
+	r.checkConnectivity()
+	r.rebroadcast("startup", r.dispatcher.Transports()...)
+
+	var periodic <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		periodic = ticker.C
+	}
+
+	check := time.NewTicker(r.checkInterval)
+	defer check.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-periodic:
+			r.rebroadcast("periodic", r.dispatcher.Transports()...)
+		case <-check.C:
+			if reconnected := r.checkConnectivity(); len(reconnected) > 0 {
+				r.rebroadcast("reconnect", reconnected...)
+			}
+		}
+	}
+}
+
+// checkConnectivity records the connection state of each transport and
+// returns the ones that came back since the last check
+func (r *Rebroadcaster) checkConnectivity() []string {
+	var reconnected []string
+
+	for _, transport := range r.dispatcher.Transports() {
+		conn, ok := r.dispatcher.publisher(transport).(Connectivity)
+		if !ok {
+			continue
+		}
+
+		connected := conn.IsConnected()
+		if was, seen := r.connected[transport]; seen && !was && connected {
+			reconnected = append(reconnected, transport)
+		}
+		r.connected[transport] = connected
+	}
+
+	return reconnected
+}
+
+func (r *Rebroadcaster) rebroadcast(reason string, transports ...string) {
+	if len(transports) == 0 {
+		return
+	}
+
+	version, err := r.db.EnqueueActiveConfig(transports...)
+	if err != nil {
+		logger.Log.Errorf("Failed to queue %s rebroadcast: %v", reason, err)
+		return
+	}
+
+	logger.Log.Debugf("Queued %s rebroadcast of config version %d to %v", reason, version, transports)
+	r.dispatcher.Notify()
+}
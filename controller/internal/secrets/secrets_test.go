@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCipher(t *testing.T) *Cipher {
+	key := make([]byte, dataKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	c, err := NewCipher(key)
+	require.NoError(t, err)
+	return c
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	c := newTestCipher(t)
+
+	for _, plaintext := range []string{"Bearer s3cret", "", strings.Repeat("x", 4096)} {
+		sealed, err := c.Encrypt(plaintext)
+		require.NoError(t, err)
+		assert.True(t, IsEncrypted(sealed))
+		assert.NotContains(t, sealed, "s3cret")
+
+		opened, err := c.Decrypt(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, plaintext, opened)
+	}
+
+	// Each value gets a fresh data key and nonce
+	first, err := c.Encrypt("Bearer s3cret")
+	require.NoError(t, err)
+	second, err := c.Encrypt("Bearer s3cret")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+}
+
+func TestDecryptWithWrongKey(t *testing.T) {
+	sealed, err := newTestCipher(t).Encrypt("Bearer s3cret")
+	require.NoError(t, err)
+
+	_, err = newTestCipher(t).Decrypt(sealed)
+	assert.ErrorContains(t, err, "failed to unwrap data key")
+
+	var none *Cipher
+	_, err = none.Decrypt(sealed)
+	assert.ErrorIs(t, err, ErrNoKey)
+	_, err = none.Encrypt("Bearer s3cret")
+	assert.ErrorIs(t, err, ErrNoKey)
+}
+
+func TestDecryptTamperedCiphertext(t *testing.T) {
+	c := newTestCipher(t)
+	sealed, err := c.Encrypt("Bearer s3cret")
+	require.NoError(t, err)
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, encryptedPrefix))
+	require.NoError(t, err)
+	encode := func(data []byte) string {
+		return encryptedPrefix + base64.StdEncoding.EncodeToString(data)
+	}
+
+	// A flipped bit in the wrapped data key or in the value is detected
+	wrappedKey := append([]byte(nil), data...)
+	wrappedKey[c.kek.NonceSize()] ^= 0x01
+	_, err = c.Decrypt(encode(wrappedKey))
+	assert.ErrorContains(t, err, "failed to unwrap data key")
+
+	value := append([]byte(nil), data...)
+	value[len(value)-1] ^= 0x01
+	_, err = c.Decrypt(encode(value))
+	assert.ErrorContains(t, err, "failed to decrypt value")
+
+	// Truncated and malformed values are rejected
+	_, err = c.Decrypt(encode(data[:10]))
+	assert.ErrorContains(t, err, "malformed")
+	_, err = c.Decrypt(encryptedPrefix + "not base64!")
+	assert.ErrorContains(t, err, "malformed")
+	_, err = c.Decrypt("Bearer s3cret")
+	assert.Error(t, err)
+}
+
+func TestNewCipherKeySize(t *testing.T) {
+	_, err := NewCipher(make([]byte, 16))
+	assert.Error(t, err)
+
+	key, err := ParseKey(" " + base64.StdEncoding.EncodeToString(make([]byte, dataKeySize)) + "\n")
+	require.NoError(t, err)
+	_, err = NewCipher(key)
+	assert.NoError(t, err)
+
+	_, err = ParseKey("not base64!")
+	assert.Error(t, err)
+}
@@ -1,47 +1,122 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"strings"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // ValidateBasicAuth validates HTTP Basic Authentication credentials
 func ValidateBasicAuth(authHeader, expectedUsername, expectedPassword string) bool {
-	if authHeader == "" {
+	username, password, ok := ParseBasicAuth(authHeader)
+	if !ok {
 		return false
 	}
 
+	// Use constant-time comparison to prevent timing attacks
+	usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(expectedUsername)) == 1
+	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(expectedPassword)) == 1
+
+	return usernameMatch && passwordMatch
+}
+
+// CreateBasicAuthHeader creates a Basic Auth header value
+func CreateBasicAuthHeader(username, password string) string {
+	credentials := username + ":" + password
+	encoded := base64.StdEncoding.EncodeToString([]byte(credentials))
+	return "Basic " + encoded
+}
+
+// ParseBasicAuth extracts the username and password from a Basic Auth header value
+func ParseBasicAuth(authHeader string) (string, string, bool) {
+	if authHeader == "" {
+		return "", "", false
+	}
+
 	// Remove "Basic " prefix
 	if !strings.HasPrefix(authHeader, "Basic ") {
-		return false
+		return "", "", false
 	}
 
 	encoded := strings.TrimPrefix(authHeader, "Basic ")
 	decoded, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
-		return false
+		return "", "", false
 	}
 
-	credentials := string(decoded)
-	parts := strings.SplitN(credentials, ":", 2)
+	parts := strings.SplitN(string(decoded), ":", 2)
 	if len(parts) != 2 {
-		return false
+		return "", "", false
 	}
 
-	username := parts[0]
-	password := parts[1]
+	return parts[0], parts[1], true
+}
 
-	// Use constant-time comparison to prevent timing attacks
-	usernameMatch := subtle.ConstantTimeCompare([]byte(username), []byte(expectedUsername)) == 1
-	passwordMatch := subtle.ConstantTimeCompare([]byte(password), []byte(expectedPassword)) == 1
+// HashPassword hashes a password with bcrypt for storage
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
 
-	return usernameMatch && passwordMatch
+// CheckPassword reports whether password matches a hash produced by HashPassword
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
 }
 
-// CreateBasicAuthHeader creates a Basic Auth header value
-func CreateBasicAuthHeader(username, password string) string {
-	credentials := username + ":" + password
-	encoded := base64.StdEncoding.EncodeToString([]byte(credentials))
-	return "Basic " + encoded
+// Prefixes of tokens issued by the controller
+const (
+	APIKeyPrefix          = "cmk_"
+	EnrollmentTokenPrefix = "cme_"
+)
+
+// ParseBearerToken extracts the token from a Bearer Authorization header value
+func ParseBearerToken(authHeader string) (string, bool) {
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return "", false
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+	if token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// CreateBearerAuthHeader creates a Bearer Auth header value
+func CreateBearerAuthHeader(token string) string {
+	return "Bearer " + token
+}
+
+// GenerateAPIKey returns a new random API key
+func GenerateAPIKey() (string, error) {
+	return GenerateToken(APIKeyPrefix)
+}
+
+// GenerateToken returns a new random token with the given prefix
+func GenerateToken(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashAPIKey returns the hash under which an API key is stored
+func HashAPIKey(key string) string {
+	return HashToken(key)
+}
+
+// HashToken returns the hash under which a generated token is stored.
+// Tokens carry 256 bits of entropy, so a plain SHA-256 is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,463 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/models"
+)
+
+// DefaultTopic is the compacted topic configuration versions are produced to
+const DefaultTopic = "config-updates"
+
+// AllTargets addresses every value at one level of a record key
+const AllTargets = "all"
+
+// Config holds Kafka connection configuration
+type Config struct {
+	Brokers  []string
+	Topic    string
+	ClientID string
+	Enabled  bool
+	// Targets a consuming agent accepts, see MatchesTarget
+	Environment string
+	Groups      []string
+	AgentID     string
+}
+
+// TargetKey returns the record key for an environment, group or single agent,
+// <environment>.<group>.<agentID>. Empty parts address every value at that level.
+// The topic is compacted, so the latest record per key is retained.
+func TargetKey(environment, group, agentID string) string {
+	parts := []string{environment, group, agentID}
+	for i, part := range parts {
+		if part == "" {
+			parts[i] = AllTargets
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// TargetGroup returns the group a record key addresses, or "" for every group
+func TargetGroup(key string) string {
+	parts := strings.Split(key, ".")
+	if len(parts) != 3 || parts[1] == AllTargets {
+		return ""
+	}
+	return parts[1]
+}
+
+// MatchesTarget reports whether a record key addresses an agent
+func MatchesTarget(key, environment string, groups []string, agentID string) bool {
+	parts := strings.Split(key, ".")
+	if len(parts) != 3 {
+		return false
+	}
+
+	if parts[0] != AllTargets && parts[0] != environment {
+		return false
+	}
+	if parts[2] != AllTargets && parts[2] != agentID {
+		return false
+	}
+	if parts[1] == AllTargets {
+		return true
+	}
+	for _, group := range groups {
+		if parts[1] == group {
+			return true
+		}
+	}
+	return false
+}
+
+func newSaramaConfig(config Config) *sarama.Config {
+	c := sarama.NewConfig()
+	if config.ClientID != "" {
+		c.ClientID = config.ClientID
+	}
+	c.Producer.Return.Successes = true
+	c.Producer.RequiredAcks = sarama.WaitForAll
+	c.Consumer.Return.Errors = true
+	c.Consumer.Offsets.Initial = sarama.OffsetOldest
+	c.Consumer.Offsets.AutoCommit.Enable = false
+	return c
+}
+
+// Producer produces configuration envelopes to a compacted topic
+type Producer struct {
+	client   sarama.Client
+	producer sarama.SyncProducer
+	topic    string
+}
+
+// NewProducer connects a producer to the configured brokers
+func NewProducer(config Config) (*Producer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	client, err := sarama.NewClient(config.Brokers, newSaramaConfig(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka: %w", err)
+	}
+
+	producer, err := sarama.NewSyncProducerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create Kafka producer: %w", err)
+	}
+
+	logger.Log.Infof("Connected to Kafka brokers %v", config.Brokers)
+
+	return &Producer{client: client, producer: producer, topic: topicOrDefault(config.Topic)}, nil
+}
+
+// EnsureTopic creates the topic with log compaction if it does not exist yet
+func (p *Producer) EnsureTopic(partitions int32, replicationFactor int16) error {
+	admin, err := sarama.NewClusterAdminFromClient(p.client)
+	if err != nil {
+		return err
+	}
+	// Closing the admin would close the shared client
+
+	compact := "compact"
+	err = admin.CreateTopic(p.topic, &sarama.TopicDetail{
+		NumPartitions:     partitions,
+		ReplicationFactor: replicationFactor,
+		ConfigEntries:     map[string]*string{"cleanup.policy": &compact},
+	}, false)
+
+	var topicErr *sarama.TopicError
+	if errors.As(err, &topicErr) && topicErr.Err == sarama.ErrTopicAlreadyExists {
+		return nil
+	}
+	return err
+}
+
+// IsConnected reports whether any broker is reachable
+func (p *Producer) IsConnected() bool {
+	if p == nil {
+		return false
+	}
+	for _, broker := range p.client.Brokers() {
+		if connected, _ := broker.Connected(); connected {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishConfig produces an envelope under the given target key
+func (p *Producer) PublishConfig(key string, envelope *models.ConfigEnvelope) error {
+	if p == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	partition, offset, err := p.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: p.topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.ByteEncoder(data),
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Log.Infof("Produced config version %d to Kafka %s/%d at offset %d (key %s)", envelope.Version, p.topic, partition, offset, key)
+	return nil
+}
+
+// Close closes the producer and its connections
+func (p *Producer) Close() error {
+	if p == nil {
+		return nil
+	}
+	if err := p.producer.Close(); err != nil {
+		return err
+	}
+	return p.client.Close()
+}
+
+// Record is a configuration envelope read from the topic
+type Record struct {
+	Key       string
+	Envelope  *models.ConfigEnvelope
+	Partition int32
+	Offset    int64
+}
+
+// Consumer reads configuration envelopes and commits offsets for a consumer group
+type Consumer struct {
+	client   sarama.Client
+	consumer sarama.Consumer
+	offsets  sarama.OffsetManager
+	topic    string
+}
+
+// NewConsumer connects a consumer that commits offsets for group
+func NewConsumer(config Config, group string) (*Consumer, error) {
+	client, err := sarama.NewClient(config.Brokers, newSaramaConfig(config))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka: %w", err)
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create Kafka consumer: %w", err)
+	}
+
+	offsets, err := sarama.NewOffsetManagerFromClient(group, client)
+	if err != nil {
+		consumer.Close()
+		client.Close()
+		return nil, fmt.Errorf("failed to create Kafka offset manager: %w", err)
+	}
+
+	return &Consumer{client: client, consumer: consumer, offsets: offsets, topic: topicOrDefault(config.Topic)}, nil
+}
+
+// Latest reads every partition up to its current end and returns the highest
+// version among the records accepted by match, or nil if there is none
+func (c *Consumer) Latest(ctx context.Context, match func(key string) bool) (*Record, error) {
+	partitions, err := c.client.Partitions(c.topic)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *Record
+	for _, partition := range partitions {
+		oldest, err := c.client.GetOffset(c.topic, partition, sarama.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		end, err := c.client.GetOffset(c.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		if end <= oldest {
+			continue
+		}
+
+		pc, err := c.consumer.ConsumePartition(c.topic, partition, oldest)
+		if err != nil {
+			return nil, err
+		}
+
+		err = func() error {
+			defer pc.Close()
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case consumerErr := <-pc.Errors():
+					return consumerErr
+				case msg := <-pc.Messages():
+					record, err := decode(msg)
+					if err != nil {
+						logger.Log.Warnf("Skipping Kafka record %s/%d@%d: %v", c.topic, partition, msg.Offset, err)
+					} else if match(record.Key) && (latest == nil || record.Envelope.Version > latest.Envelope.Version) {
+						latest = record
+					}
+					if msg.Offset >= end-1 {
+						return nil
+					}
+				}
+			}
+		}()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return latest, nil
+}
+
+// Follow consumes every partition from the group's committed offset, or the
+// oldest retained record, until the context is cancelled. The offset of a
+// record is committed only when handle returns nil for it. A failed record is
+// retried with exponential backoff while later records are still delivered;
+// commits resume once it succeeds, and a restart resumes from the first
+// record that has not.
+func (c *Consumer) Follow(ctx context.Context, handle func(record *Record) error) error {
+	partitions, err := c.client.Partitions(c.topic)
+	if err != nil {
+		return err
+	}
+
+	records := make(chan *sarama.ConsumerMessage)
+	managers := make(map[int32]sarama.PartitionOffsetManager)
+	for _, partition := range partitions {
+		pom, err := c.offsets.ManagePartition(c.topic, partition)
+		if err != nil {
+			return err
+		}
+		managers[partition] = pom
+
+		next, _ := pom.NextOffset()
+		pc, err := c.consumer.ConsumePartition(c.topic, partition, next)
+		if err != nil {
+			return err
+		}
+		logger.Log.Infof("Following Kafka %s/%d from offset %d", c.topic, partition, next)
+
+		go func(pc sarama.PartitionConsumer) {
+			defer pc.Close()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case consumerErr := <-pc.Errors():
+					if consumerErr != nil {
+						logger.Log.Errorf("Kafka consumer error: %v", consumerErr)
+					}
+				case msg, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					select {
+					case records <- msg:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}(pc)
+	}
+
+	failed := make(map[int32][]*retryRecord) // per partition, oldest first
+	handled := make(map[int32]int64)         // offset after the newest record handled
+	retry := time.NewTimer(time.Hour)
+	retry.Stop()
+	defer retry.Stop()
+
+	// commit marks every record before the oldest failed one as consumed
+	commit := func(partition int32) {
+		offset := handled[partition]
+		if pending := failed[partition]; len(pending) > 0 {
+			offset = pending[0].msg.Offset
+		}
+		managers[partition].MarkOffset(offset, "")
+		c.offsets.Commit()
+	}
+
+	// schedule arms the retry timer for the failed record due first
+	schedule := func() {
+		var due time.Time
+		for _, pending := range failed {
+			for _, r := range pending {
+				if due.IsZero() || r.due.Before(due) {
+					due = r.due
+				}
+			}
+		}
+		retry.Stop()
+		if !due.IsZero() {
+			retry.Reset(time.Until(due))
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-records:
+			handled[msg.Partition] = msg.Offset + 1
+			record, err := decode(msg)
+			if err != nil {
+				// A malformed record can never be applied; commit past it
+				logger.Log.Warnf("Skipping Kafka record %s/%d@%d: %v", c.topic, msg.Partition, msg.Offset, err)
+			} else if err := handle(record); err != nil {
+				r := &retryRecord{msg: msg, record: record, attempts: 1}
+				r.due = time.Now().Add(retryBackoff(r.attempts))
+				failed[msg.Partition] = append(failed[msg.Partition], r)
+				schedule()
+				continue
+			}
+			commit(msg.Partition)
+		case <-retry.C:
+			now := time.Now()
+			for partition, pending := range failed {
+				var remaining []*retryRecord
+				for _, r := range pending {
+					if r.due.After(now) {
+						remaining = append(remaining, r)
+						continue
+					}
+					if err := handle(r.record); err != nil {
+						r.attempts++
+						r.due = now.Add(retryBackoff(r.attempts))
+						logger.Log.Warnf("Kafka record %s/%d@%d still failing (attempt %d): %v", c.topic, partition, r.msg.Offset, r.attempts, err)
+						remaining = append(remaining, r)
+						continue
+					}
+					logger.Log.Infof("Kafka record %s/%d@%d succeeded after %d attempt(s)", c.topic, partition, r.msg.Offset, r.attempts+1)
+				}
+				if len(remaining) == 0 {
+					delete(failed, partition)
+				} else {
+					failed[partition] = remaining
+				}
+				commit(partition)
+			}
+			schedule()
+		}
+	}
+}
+
+// retryRecord is a record handle failed on, retried in process
+type retryRecord struct {
+	msg      *sarama.ConsumerMessage
+	record   *Record
+	attempts int
+	due      time.Time
+}
+
+// retryBackoff returns the delay before a failed record is handled again,
+// doubling from one second up to a minute
+func retryBackoff(attempts int) time.Duration {
+	delay := time.Second
+	for i := 1; i < attempts && delay < time.Minute; i++ {
+		delay *= 2
+	}
+	if delay > time.Minute {
+		delay = time.Minute
+	}
+	return delay
+}
+
+// Close closes the consumer and its connections
+func (c *Consumer) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.offsets.Close()
+	c.consumer.Close()
+	return c.client.Close()
+}
+
+func decode(msg *sarama.ConsumerMessage) (*Record, error) {
+	envelope, err := models.DecodeConfigEnvelope(msg.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{Key: string(msg.Key), Envelope: envelope, Partition: msg.Partition, Offset: msg.Offset}, nil
+}
+
+func topicOrDefault(topic string) string {
+	if topic == "" {
+		return DefaultTopic
+	}
+	return topic
+}
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// ConfigAck reports the outcome of forwarding a configuration to the agent's workers
+type ConfigAck struct {
+	AgentID string `json:"agent_id"`
+	Version int64  `json:"version"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	// LatencyMs is the time from the controller publishing the version to the worker accepting it
+	LatencyMs  int64     `json:"latency_ms"`
+	Timestamp  time.Time `json:"timestamp"`
+	ReceivedAt time.Time `json:"received_at,omitempty"`
+	// Workers holds the outcome per worker; Success is true only if all succeeded
+	Workers []WorkerResult `json:"workers,omitempty"`
+}
+
+// WorkerResult is the outcome of forwarding a configuration to one worker
+type WorkerResult struct {
+	Worker   string `json:"worker"`
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+	Attempts int    `json:"attempts"`
+}
+
+// WorkerStatus is the last known state of one worker managed by an agent
+type WorkerStatus struct {
+	Name          string     `json:"name"`
+	URL           string     `json:"url"`
+	Groups        []string   `json:"groups,omitempty"`
+	Version       int64      `json:"version"` // last version the worker accepted
+	LastError     string     `json:"last_error,omitempty"`
+	LastForwardAt *time.Time `json:"last_forward_at,omitempty"`
+	LastSuccessAt *time.Time `json:"last_success_at,omitempty"`
+}
+
+// DeliveryStatus summarises the acknowledgements received for a configuration version
+type DeliveryStatus struct {
+	Version        int64       `json:"version"`
+	ExpectedAgents int         `json:"expected_agents"`
+	Acked          int         `json:"acked"`
+	Succeeded      int         `json:"succeeded"`
+	Failed         int         `json:"failed"`
+	Pending        []string    `json:"pending"` // recently active agents that have not acknowledged
+	Complete       bool        `json:"complete"`
+	Acks           []ConfigAck `json:"acks"`
+}
+
+// PendingDelivery is a configuration version an agent keeps retrying to a
+// worker that has not accepted it. Only the newest pending version is kept.
+type PendingDelivery struct {
+	Worker        string    `json:"worker"`
+	Version       int64     `json:"version"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error"`
+	Since         time.Time `json:"since"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
@@ -10,6 +10,14 @@ type Agent struct {
 	Metadata     string    `json:"metadata,omitempty"`
 }
 
+// AgentIDHeader carries the agent ID on requests from agents without a client
+// certificate, so the controller can tell agents it does not know
+const AgentIDHeader = "X-Agent-ID"
+
+// UnknownAgentError is the error the controller replies with, with status 404,
+// to requests from an agent it has no registration for. The agent registers again.
+const UnknownAgentError = "Unknown agent"
+
 // RegisterRequest represents the agent registration request
 type RegisterRequest struct {
 	Hostname string `json:"hostname,omitempty"`
@@ -21,4 +29,18 @@ type RegisterResponse struct {
 	AgentID          string `json:"agent_id"`
 	PollURL          string `json:"poll_url"`
 	PollIntervalSecs int    `json:"poll_interval_seconds"`
+
+	// Distribution transports the controller publishes on, best first
+	Transports []TransportOffer `json:"transports,omitempty"`
+}
+
+// TransportOffer advertises one distribution strategy an agent may use.
+// Credentials are not advertised; agents use their own.
+type TransportOffer struct {
+	Strategy  string   `json:"strategy"` // POLLER, REDIS, NATS or KAFKA
+	Priority  int      `json:"priority"` // higher is preferred
+	Endpoints []string `json:"endpoints,omitempty"`
+	Subject   string   `json:"subject,omitempty"` // NATS broadcast subject
+	Channel   string   `json:"channel,omitempty"` // Redis channel
+	Topic     string   `json:"topic,omitempty"`   // Kafka topic
 }
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// APIKeyScope represents an operation an API key is allowed to perform
+type APIKeyScope string
+
+const (
+	ScopeReadConfig  APIKeyScope = "read-config"
+	ScopeWriteConfig APIKeyScope = "write-config"
+	ScopeReadAgents  APIKeyScope = "read-agents"
+)
+
+// IsValid reports whether the scope is one of the known scopes
+func (s APIKeyScope) IsValid() bool {
+	switch s {
+	case ScopeReadConfig, ScopeWriteConfig, ScopeReadAgents:
+		return true
+	}
+	return false
+}
+
+// APIKey represents a bearer token issued for automation
+type APIKey struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	KeyHash    string        `json:"-"`
+	Scopes     []APIKeyScope `json:"scopes"`
+	ExpiresAt  time.Time     `json:"expires_at"`
+	LastUsedAt *time.Time    `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time    `json:"revoked_at,omitempty"`
+	CreatedBy  string        `json:"created_by"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// HasScope reports whether the key grants the given scope
+func (k *APIKey) HasScope(scope APIKeyScope) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive reports whether the key is neither revoked nor expired at the given time
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && now.Before(k.ExpiresAt)
+}
+
+// CreateAPIKeyRequest represents a request to issue an API key
+type CreateAPIKeyRequest struct {
+	Name          string        `json:"name"`
+	Scopes        []APIKeyScope `json:"scopes"`
+	ExpiresInDays int           `json:"expires_in_days,omitempty"`
+}
+
+// CreateAPIKeyResponse contains a newly issued API key; the plaintext key is only returned once
+type CreateAPIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// EnrollRequest represents an agent's request for a client certificate
+type EnrollRequest struct {
+	Token string `json:"token,omitempty"`
+	CSR   string `json:"csr"`
+}
+
+// EnrollResponse contains a certificate issued by the controller's internal CA
+type EnrollResponse struct {
+	Certificate   string    `json:"certificate"`
+	CACertificate string    `json:"ca_certificate"`
+	Serial        string    `json:"serial"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// IssuedCertificate represents a certificate issued to an agent
+type IssuedCertificate struct {
+	Serial    string     `json:"serial"`
+	AgentID   string     `json:"agent_id"`
+	IssuedAt  time.Time  `json:"issued_at"`
+	NotAfter  time.Time  `json:"not_after"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// EnrollmentToken represents a bootstrap token that allows agents to enroll
+type EnrollmentToken struct {
+	ID        string    `json:"id"`
+	TokenHash string    `json:"-"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MaxUses   int       `json:"max_uses"`
+	Uses      int       `json:"uses"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateEnrollmentTokenRequest represents a request to issue a bootstrap token
+type CreateEnrollmentTokenRequest struct {
+	ExpiresInHours int `json:"expires_in_hours,omitempty"`
+	MaxUses        int `json:"max_uses,omitempty"`
+}
+
+// CreateEnrollmentTokenResponse contains a new bootstrap token; the plaintext token is only returned once
+type CreateEnrollmentTokenResponse struct {
+	EnrollmentToken
+	Token string `json:"token"`
+}
@@ -4,7 +4,53 @@ import "time"
 
 // WorkerConfig represents the configuration that workers execute
 type WorkerConfig struct {
-	URL string `json:"url" validate:"required,url"`
+	URL           string            `json:"url" validate:"required,url"`
+	Headers       map[string]string `json:"headers,omitempty"`        // sent with each request, e.g. Accept
+	SecretHeaders map[string]Secret `json:"secret_headers,omitempty"` // like Headers, but encrypted at rest and redacted, e.g. Authorization
+	// SecretsRedacted is set on copies whose secret values were replaced by RedactedValue
+	SecretsRedacted bool `json:"secrets_redacted,omitempty"`
+}
+
+// HasSecrets reports whether the configuration carries any secret values
+func (c WorkerConfig) HasSecrets() bool {
+	return len(c.SecretHeaders) > 0
+}
+
+// IsRedacted reports whether secret values have been replaced by RedactedValue
+func (c WorkerConfig) IsRedacted() bool {
+	return c.SecretsRedacted
+}
+
+// Redacted returns a copy of the configuration with secret values replaced
+func (c WorkerConfig) Redacted() WorkerConfig {
+	if !c.HasSecrets() {
+		return c
+	}
+
+	redacted := c
+	redacted.SecretHeaders = make(map[string]Secret, len(c.SecretHeaders))
+	for name := range c.SecretHeaders {
+		redacted.SecretHeaders[name] = RedactedValue
+	}
+	redacted.SecretsRedacted = true
+	return redacted
+}
+
+// RequestHeaders returns the plain and secret headers to send with each
+// request. A secret header replaces a plain one of the same name.
+func (c WorkerConfig) RequestHeaders() map[string]string {
+	if len(c.Headers) == 0 && len(c.SecretHeaders) == 0 {
+		return nil
+	}
+
+	headers := make(map[string]string, len(c.Headers)+len(c.SecretHeaders))
+	for name, value := range c.Headers {
+		headers[name] = value
+	}
+	for name, value := range c.SecretHeaders {
+		headers[name] = value.Value()
+	}
+	return headers
 }
 
 // Config represents a configuration version stored in the database
@@ -12,6 +58,7 @@ type Config struct {
 	ID        int64        `json:"id"`
 	Version   int64        `json:"version"`
 	Data      WorkerConfig `json:"data"`
+	CreatedBy string       `json:"created_by,omitempty"`
 	CreatedAt time.Time    `json:"created_at"`
 	UpdatedAt time.Time    `json:"updated_at"`
 }
@@ -21,4 +68,23 @@ type ConfigResponse struct {
 	Version          int64        `json:"version"`
 	Data             WorkerConfig `json:"data"`
 	PollIntervalSecs int          `json:"poll_interval_seconds,omitempty"`
+	Signature        string       `json:"signature,omitempty"`
+
+	// Metadata of a configuration received in a push envelope, kept because
+	// the envelope signature covers it
+	Origin    string     `json:"origin,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	Resync    bool       `json:"resync,omitempty"`
+}
+
+// ConfigVersionHeader carries the configuration version an agent forwards to a worker
+const ConfigVersionHeader = "X-Config-Version"
+
+// WorkerConfigStatus reports the configuration a worker currently applies, so
+// agents can detect workers that lost or missed it
+type WorkerConfigStatus struct {
+	HasConfig   bool       `json:"has_config"`
+	Version     int64      `json:"version"`      // 0 when forwarded without a version
+	ContentHash string     `json:"content_hash"` // see ContentHash
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
 }
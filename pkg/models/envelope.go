@@ -0,0 +1,79 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ConfigSchemaVersion is the envelope schema produced by this build
+const ConfigSchemaVersion = 1
+
+// ErrUnsupportedSchema is returned for envelopes with an unknown schema version
+var ErrUnsupportedSchema = errors.New("unsupported config envelope schema version")
+
+// ConfigEnvelope is the message used to distribute a configuration version
+// over every push channel (Redis, NATS)
+type ConfigEnvelope struct {
+	SchemaVersion int          `json:"schema_version"`
+	Version       int64        `json:"version"`
+	Config        WorkerConfig `json:"config"`
+	ContentHash   string       `json:"content_hash"` // SHA-256 of the JSON-encoded config
+	Timestamp     time.Time    `json:"timestamp"`
+	Origin        string       `json:"origin"`           // ID of the controller that published it
+	Resync        bool         `json:"resync,omitempty"` // re-published on request, forwarded even to workers that run it
+	Signature     string       `json:"signature,omitempty"`
+}
+
+// NewConfigEnvelope wraps a configuration version for publishing
+func NewConfigEnvelope(version int64, config WorkerConfig, origin string) (*ConfigEnvelope, error) {
+	hash, err := ContentHash(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConfigEnvelope{
+		SchemaVersion: ConfigSchemaVersion,
+		Version:       version,
+		Config:        config,
+		ContentHash:   hash,
+		Timestamp:     time.Now(),
+		Origin:        origin,
+	}, nil
+}
+
+// DecodeConfigEnvelope parses an envelope and rejects unknown schema
+// versions and configs that do not match their content hash
+func DecodeConfigEnvelope(data []byte) (*ConfigEnvelope, error) {
+	var envelope ConfigEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config envelope: %w", err)
+	}
+
+	if envelope.SchemaVersion != ConfigSchemaVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedSchema, envelope.SchemaVersion)
+	}
+
+	hash, err := ContentHash(envelope.Config)
+	if err != nil {
+		return nil, err
+	}
+	if hash != envelope.ContentHash {
+		return nil, fmt.Errorf("config envelope version %d content hash mismatch", envelope.Version)
+	}
+
+	return &envelope, nil
+}
+
+// ContentHash returns the hex SHA-256 of the JSON-encoded configuration
+func ContentHash(config WorkerConfig) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal config: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,14 @@
+package models
+
+// NatsRequest is an agent request sent to the controller over NATS request/reply
+type NatsRequest struct {
+	// Authorization carries the same Basic credentials agents send over HTTP
+	Authorization string           `json:"authorization"`
+	AgentID       string           `json:"agent_id,omitempty"`
+	Register      *RegisterRequest `json:"register,omitempty"`
+}
+
+// NatsError is the reply to a NATS request the controller could not serve
+type NatsError struct {
+	Error string `json:"error"`
+}
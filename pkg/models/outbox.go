@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// OutboxStatus is the delivery state of an outbox entry
+type OutboxStatus string
+
+const (
+	OutboxPending    OutboxStatus = "pending"
+	OutboxDelivered  OutboxStatus = "delivered"
+	OutboxSuperseded OutboxStatus = "superseded" // a newer version was queued before delivery
+)
+
+// OutboxEntry is a configuration version waiting to be published on one transport
+type OutboxEntry struct {
+	ID            int64        `json:"id"`
+	Version       int64        `json:"version"`
+	Transport     string       `json:"transport"`
+	Status        OutboxStatus `json:"status"`
+	Attempts      int          `json:"attempts"`
+	NextAttemptAt time.Time    `json:"next_attempt_at"`
+	LastError     string       `json:"last_error,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	DeliveredAt   *time.Time   `json:"delivered_at,omitempty"`
+}
+
+// OutboxBacklog summarises undelivered entries for one transport
+type OutboxBacklog struct {
+	Pending         int        `json:"pending"`
+	OldestPendingAt *time.Time `json:"oldest_pending_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+// TransportStatus reports the health and delivery counters of one distribution transport
+type TransportStatus struct {
+	Connected       *bool      `json:"connected,omitempty"` // nil when the transport cannot report it
+	Published       int64      `json:"published"`
+	Failed          int64      `json:"failed"`
+	LastPublishedAt *time.Time `json:"last_published_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty"`
+}
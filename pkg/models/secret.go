@@ -0,0 +1,27 @@
+package models
+
+// RedactedValue replaces secret values in admin reads, logs and push messages
+const RedactedValue = "********"
+
+// Secret is a configuration value that must not be exposed outside of
+// delivery to agents and workers. It marshals to JSON as plain text, so
+// callers redact it explicitly; fmt verbs print it redacted.
+type Secret string
+
+// String implements fmt.Stringer so secrets are redacted in logs
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return RedactedValue
+}
+
+// GoString implements fmt.GoStringer so %#v is redacted too
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// Value returns the plain text of the secret
+func (s Secret) Value() string {
+	return string(s)
+}
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// Role represents the access level of an admin account
+type Role string
+
+const (
+	// RoleViewer can read configuration history and agents
+	RoleViewer Role = "viewer"
+	// RoleOperator can additionally roll back and resync configuration
+	RoleOperator Role = "operator"
+	// RoleAdmin has full access, including config changes and user management
+	RoleAdmin Role = "admin"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// IsValid reports whether the role is one of the known roles
+func (r Role) IsValid() bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// Allows reports whether the role grants at least the access of required
+func (r Role) Allows(required Role) bool {
+	return r.IsValid() && roleRank[r] >= roleRank[required]
+}
+
+// User represents an admin account on the controller
+type User struct {
+	Username     string    `json:"username"`
+	Role         Role      `json:"role"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// CreateUserRequest represents a request to create an admin account
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     Role   `json:"role"`
+}
+
+// UpdateUserRequest represents a request to change an admin account's role or password
+type UpdateUserRequest struct {
+	Password string `json:"password,omitempty"`
+	Role     Role   `json:"role,omitempty"`
+}
@@ -0,0 +1,284 @@
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/logger"
+)
+
+// Config holds certificate and CA bundle locations for mutual TLS
+type Config struct {
+	CertFile       string
+	KeyFile        string
+	CAFile         string
+	ServerName     string             // client side: overrides the name verified in the server certificate
+	ClientAuth     tls.ClientAuthType // server side: how client certificates are verified
+	ReloadInterval time.Duration      // how often files are checked for rotation
+}
+
+// Enabled reports whether a certificate pair or CA bundle is configured
+func (c Config) Enabled() bool {
+	return (c.CertFile != "" && c.KeyFile != "") || c.CAFile != ""
+}
+
+// ParseClientAuth maps a config value to a client certificate policy
+func ParseClientAuth(value string) (tls.ClientAuthType, error) {
+	switch value {
+	case "", "require":
+		return tls.RequireAndVerifyClientCert, nil
+	case "verify-if-given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "none":
+		return tls.NoClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown client auth mode: %s", value)
+	}
+}
+
+// Reloader keeps the current certificate and CA pool in memory and reloads
+// them when the files on disk are rotated
+type Reloader struct {
+	config Config
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// NewReloader loads the configured certificate pair and CA bundle
+func NewReloader(config Config) (*Reloader, error) {
+	if !config.Enabled() {
+		return nil, errors.New("TLS certificate files or CA bundle are required")
+	}
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("TLS certificate and key files must be set together")
+	}
+	if config.ClientAuth != tls.NoClientCert && config.CAFile == "" {
+		// The system roots would accept any publicly issued certificate as a client
+		return nil, errors.New("verifying client certificates requires a client CA bundle")
+	}
+	if config.ReloadInterval == 0 {
+		config.ReloadInterval = 30 * time.Second
+	}
+
+	r := &Reloader{config: config}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Start watches the certificate files until the context is cancelled
+func (r *Reloader) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.config.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				logger.Log.Errorf("Failed to reload TLS certificates, keeping previous: %v", err)
+				continue
+			}
+			logger.Log.Infof("Reloaded TLS certificate %s", r.config.CertFile)
+		}
+	}
+}
+
+// Reload forces the certificate files to be read again
+func (r *Reloader) Reload() error {
+	return r.reload()
+}
+
+func (r *Reloader) files() []string {
+	var files []string
+	if r.config.CertFile != "" {
+		files = append(files, r.config.CertFile, r.config.KeyFile)
+	}
+	if r.config.CAFile != "" {
+		files = append(files, r.config.CAFile)
+	}
+	return files
+}
+
+func (r *Reloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) reload() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", file, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	var cert *tls.Certificate
+	if r.config.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate pair: %w", err)
+		}
+		cert = &pair
+	}
+
+	var pool *x509.CertPool
+	if r.config.CAFile != "" {
+		pem, err := os.ReadFile(r.config.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA bundle %s", r.config.CAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = cert
+	r.pool = pool
+	r.modTimes = modTimes
+	r.mu.Unlock()
+	return nil
+}
+
+// Certificate returns the current certificate, or nil when none is configured
+func (r *Reloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// CAPool returns the current CA pool, or nil to use the system roots
+func (r *Reloader) CAPool() *x509.CertPool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.pool
+}
+
+// ServerTLSConfig returns a listener configuration that serves the current
+// certificate and verifies client certificates against the current CA pool
+// only, never the system roots
+func (r *Reloader) ServerTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert := r.Certificate(); cert != nil {
+				return cert, nil
+			}
+			return nil, errors.New("no server certificate configured")
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert := r.Certificate()
+			if cert == nil {
+				return nil, errors.New("no server certificate configured")
+			}
+			// Never fall back to the system roots for client certificates
+			clientCAs := r.CAPool()
+			if clientCAs == nil {
+				clientCAs = x509.NewCertPool()
+			}
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    clientCAs,
+				ClientAuth:   r.config.ClientAuth,
+			}, nil
+		},
+	}
+}
+
+// ClientTLSConfig returns a client configuration that presents the current
+// certificate and verifies servers against the current CA pool. Verification
+// is done in VerifyConnection so that a rotated CA bundle takes effect
+// without rebuilding the HTTP client.
+func (r *Reloader) ClientTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := r.Certificate(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+		InsecureSkipVerify: true, // replaced by VerifyConnection below
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return r.verifyServer(state)
+		},
+	}
+}
+
+func (r *Reloader) verifyServer(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+
+	serverName := r.config.ServerName
+	if serverName == "" {
+		serverName = state.ServerName
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         r.CAPool(),
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := state.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// PeerCertificate returns the verified peer certificate of a connection, or nil
+func PeerCertificate(state *tls.ConnectionState) *x509.Certificate {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// Identity returns the identity carried by a verified peer certificate: the
+// Common Name, or the first DNS SAN when no Common Name is set
+func Identity(state *tls.ConnectionState) string {
+	leaf := PeerCertificate(state)
+	if leaf == nil {
+		return ""
+	}
+
+	if leaf.Subject.CommonName != "" {
+		return leaf.Subject.CommonName
+	}
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames[0]
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// leaf returns a certificate signed by the CA
+func (ca *testCA) leaf(t *testing.T, commonName string, dnsNames []string, serial int64) (*x509.Certificate, []byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, der, key
+}
+
+// issue writes a leaf certificate and key signed by the CA and returns their paths
+func (ca *testCA) issue(t *testing.T, dir, name string, serial int64) (string, string) {
+	_, der, key := ca.leaf(t, name, []string{"localhost"}, serial)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certFile, keyFile
+}
+
+func writeCA(t *testing.T, dir string, cas ...*testCA) string {
+	var bundle []byte
+	for _, ca := range cas {
+		bundle = append(bundle, ca.pem...)
+	}
+	caFile := filepath.Join(dir, "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, bundle, 0600))
+	return caFile
+}
+
+func serial(t *testing.T, cert *tls.Certificate) int64 {
+	require.NotNil(t, cert)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.SerialNumber.Int64()
+}
+
+// touch moves the modification time of files forward so rotation is detected
+// even within the file system's timestamp resolution
+func touch(t *testing.T, files ...string) {
+	later := time.Now().Add(time.Minute)
+	for _, file := range files {
+		require.NoError(t, os.Chtimes(file, later, later))
+	}
+}
+
+func TestReloadAfterRotation(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, "test-ca")
+	certFile, keyFile := ca.issue(t, dir, "agent-1", 2)
+
+	r, err := NewReloader(Config{CertFile: certFile, KeyFile: keyFile, CAFile: writeCA(t, dir, ca)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), serial(t, r.Certificate()))
+	assert.False(t, r.changed())
+
+	// A rotated pair is picked up on the next reload
+	ca.issue(t, dir, "agent-1", 3)
+	touch(t, certFile, keyFile)
+	assert.True(t, r.changed())
+	require.NoError(t, r.Reload())
+	assert.Equal(t, int64(3), serial(t, r.Certificate()))
+	assert.False(t, r.changed())
+
+	// A broken rotation keeps the previous certificate
+	require.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0600))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, int64(3), serial(t, r.Certificate()))
+
+	// A rotated CA bundle replaces the pool
+	next := newTestCA(t, "next-ca")
+	ca.issue(t, dir, "agent-1", 4)
+	caFile := writeCA(t, dir, next)
+	touch(t, certFile, keyFile, caFile)
+	require.NoError(t, r.Reload())
+	_, err = ca.cert.Verify(x509.VerifyOptions{Roots: r.CAPool()})
+	assert.Error(t, err)
+	_, err = next.cert.Verify(x509.VerifyOptions{Roots: r.CAPool()})
+	assert.NoError(t, err)
+}
+
+func TestClientCertificateFromUnknownCARejected(t *testing.T) {
+	dir := t.TempDir()
+	trusted := newTestCA(t, "trusted-ca")
+	unknown := newTestCA(t, "unknown-ca")
+	caFile := writeCA(t, dir, trusted)
+
+	serverCert, serverKey := trusted.issue(t, dir, "controller", 2)
+	server, err := NewReloader(Config{
+		CertFile:   serverCert,
+		KeyFile:    serverKey,
+		CAFile:     caFile,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	})
+	require.NoError(t, err)
+
+	var identities []string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identities = append(identities, Identity(r.TLS))
+	}))
+	ts.TLS = server.ServerTLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(issuer *testCA, name string) error {
+		certFile, keyFile := issuer.issue(t, dir, name, 3)
+		client, err := NewReloader(Config{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ServerName: "localhost"})
+		require.NoError(t, err)
+
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: client.ClientTLSConfig()}}
+		resp, err := httpClient.Get(ts.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	require.NoError(t, get(trusted, "agent-1"))
+	assert.Error(t, get(unknown, "agent-2"))
+	assert.Equal(t, []string{"agent-1"}, identities)
+
+	// Verifying clients without a CA bundle would fall back to the system roots
+	_, err = NewReloader(Config{CertFile: serverCert, KeyFile: serverKey, ClientAuth: tls.RequireAndVerifyClientCert})
+	assert.Error(t, err)
+	_, err = NewReloader(Config{CertFile: serverCert, KeyFile: serverKey, ClientAuth: tls.VerifyClientCertIfGiven})
+	assert.Error(t, err)
+}
+
+func TestIdentity(t *testing.T) {
+	ca := newTestCA(t, "test-ca")
+	state := func(commonName string, dnsNames ...string) *tls.ConnectionState {
+		cert, _, _ := ca.leaf(t, commonName, dnsNames, 2)
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert, ca.cert}}}
+	}
+
+	assert.Equal(t, "agent-1", Identity(state("agent-1", "agent-1.example.com")))
+	assert.Equal(t, "agent-2.example.com", Identity(state("", "agent-2.example.com", "other.example.com")))
+	assert.Equal(t, "", Identity(state("")))
+
+	// Only verified chains carry an identity
+	cert, _, _ := ca.leaf(t, "agent-1", nil, 2)
+	assert.Equal(t, "", Identity(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}))
+	assert.Equal(t, "", Identity(nil))
+}
+
+func TestParseClientAuth(t *testing.T) {
+	for value, want := range map[string]tls.ClientAuthType{
+		"":                tls.RequireAndVerifyClientCert,
+		"require":         tls.RequireAndVerifyClientCert,
+		"verify-if-given": tls.VerifyClientCertIfGiven,
+		"none":            tls.NoClientCert,
+	} {
+		got, err := ParseClientAuth(value)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, value)
+	}
+
+	_, err := ParseClientAuth("optional")
+	assert.Error(t, err)
+}
@@ -3,12 +3,83 @@ package nats
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/nats-io/nats.go"
 	"github.com/doniyusdinar/config-management/pkg/logger"
 )
 
+// Request/reply subjects served by the controller
+const (
+	// ConfigRequestSubject is answered with the active configuration envelope
+	ConfigRequestSubject = "config.get"
+	// RegisterRequestSubject registers an agent and is answered with a RegisterResponse
+	RegisterRequestSubject = "agent.register"
+)
+
+// AckSubject carries agents' acknowledgements of pushed configurations
+const AckSubject = "config.ack"
+
+// DefaultSubject is the broadcast subject used when none is configured
+const DefaultSubject = "config.worker.update"
+
+// Targeted configuration subjects form the hierarchy
+// config.<environment>.<group>.<agentID>, with AllTargets for unaddressed levels
+const (
+	TargetSubjectPrefix = "config"
+	AllTargets          = "all"
+)
+
+// TargetSubject returns the subject addressing an environment, group or single
+// agent. Empty parts address every value at that level.
+func TargetSubject(environment, group, agentID string) string {
+	parts := []string{TargetSubjectPrefix, environment, group, agentID}
+	for i, part := range parts {
+		if part == "" {
+			parts[i] = AllTargets
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// TargetGroup returns the group a target subject addresses, or "" for the
+// broadcast subject and subjects addressing every group
+func TargetGroup(subject string) string {
+	parts := strings.Split(subject, ".")
+	if len(parts) != 4 || parts[0] != TargetSubjectPrefix || parts[2] == AllTargets {
+		return ""
+	}
+	return parts[2]
+}
+
+// TargetSubjects returns the subjects an agent subscribes to: every target,
+// its environment, each of its groups and its own ID in any environment and group
+func TargetSubjects(environment string, groups []string, agentID string) []string {
+	subjects := []string{TargetSubject("", "", "")}
+	if environment != "" {
+		subjects = append(subjects, TargetSubject(environment, "", ""))
+	}
+	for _, group := range groups {
+		subjects = append(subjects, TargetSubject("", group, ""))
+		if environment != "" {
+			subjects = append(subjects, TargetSubject(environment, group, ""))
+		}
+	}
+	if agentID != "" {
+		subjects = append(subjects, strings.Join([]string{TargetSubjectPrefix, "*", "*", agentID}, "."))
+	}
+	return subjects
+}
+
+// ValidateToken checks that a value can be used as one level of a subject
+func ValidateToken(token string) error {
+	if token == "" || token == AllTargets || strings.ContainsAny(token, ".*> \t\r\n") {
+		return fmt.Errorf("invalid subject token %q", token)
+	}
+	return nil
+}
+
 // Config holds NATS configuration
 type Config struct {
 	URLs            []string      `json:"urls"`
@@ -22,6 +93,10 @@ type Config struct {
 	Subject         string        `json:"subject"`
 	QueueGroup      string        `json:"queue_group"`
 	Enabled         bool          `json:"enabled"`
+	// Targeted subjects an agent subscribes to, see TargetSubjects
+	Environment     string        `json:"environment"`
+	Groups          []string      `json:"groups"`
+	AgentID         string        `json:"agent_id"`
 }
 
 // Client wraps NATS connection with additional functionality
@@ -100,6 +175,11 @@ func (c *Client) Connect() error {
 	return nil
 }
 
+// Config returns the configuration the client was created with
+func (c *Client) Config() Config {
+	return c.config
+}
+
 // Subscribe creates a subscription to a subject
 func (c *Client) Subscribe(subject string, handler nats.MsgHandler) (*nats.Subscription, error) {
 	if c.conn == nil {
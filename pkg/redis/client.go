@@ -13,13 +13,15 @@ import (
 const (
 	ConfigChannelPrefix = "config:"
 	GlobalConfigChannel = "config:global"
+	AckChannel          = "config:acks"
 )
 
 // Client wraps Redis client with pub/sub functionality
 type Client struct {
-	rdb    *redis.Client
-	ctx    context.Context
-	cancel context.CancelFunc
+	rdb     *redis.Client
+	channel string
+	ctx     context.Context
+	cancel  context.CancelFunc
 }
 
 // Config holds Redis connection configuration
@@ -27,6 +29,7 @@ type Config struct {
 	Address  string
 	Password string
 	DB       int
+	Channel  string // config channel, GlobalConfigChannel when empty
 	Enabled  bool
 }
 
@@ -52,10 +55,16 @@ func NewClient(config Config) (*Client, error) {
 
 	logger.Log.Info("Connected to Redis successfully")
 
+	channel := config.Channel
+	if channel == "" {
+		channel = GlobalConfigChannel
+	}
+
 	return &Client{
-		rdb:    rdb,
-		ctx:    ctx,
-		cancel: cancel,
+		rdb:     rdb,
+		channel: channel,
+		ctx:     ctx,
+		cancel:  cancel,
 	}, nil
 }
 
@@ -68,6 +77,14 @@ func (c *Client) Close() error {
 	return c.rdb.Close()
 }
 
+// Channel returns the channel configuration changes are published on
+func (c *Client) Channel() string {
+	if c == nil {
+		return ""
+	}
+	return c.channel
+}
+
 // IsConnected checks if Redis is connected
 func (c *Client) IsConnected() bool {
 	if c == nil {
@@ -77,41 +94,35 @@ func (c *Client) IsConnected() bool {
 }
 
 // PublishConfig publishes configuration change to Redis
-func (c *Client) PublishConfig(config models.WorkerConfig, version string) error {
+func (c *Client) PublishConfig(envelope *models.ConfigEnvelope) error {
 	if c == nil {
 		return nil
 	}
 
-	message := ConfigMessage{
-		Config:    config,
-		Version:   version,
-		Timestamp: time.Now(),
-	}
-
-	data, err := json.Marshal(message)
+	data, err := json.Marshal(envelope)
 	if err != nil {
 		return err
 	}
 
-	// Publish to global config channel
-	err = c.rdb.Publish(c.ctx, GlobalConfigChannel, data).Err()
+	// Publish to the config channel
+	err = c.rdb.Publish(c.ctx, c.channel, data).Err()
 	if err != nil {
 		logger.Log.Errorf("Failed to publish config to Redis: %v", err)
 		return err
 	}
 
-	logger.Log.Infof("Published config change to Redis channel: %s", GlobalConfigChannel)
+	logger.Log.Infof("Published config change to Redis channel: %s", c.channel)
 	return nil
 }
 
 // SubscribeToConfig subscribes to configuration changes
-func (c *Client) SubscribeToConfig() (<-chan ConfigMessage, error) {
+func (c *Client) SubscribeToConfig() (<-chan models.ConfigEnvelope, error) {
 	if c == nil {
 		return nil, nil
 	}
 
-	pubsub := c.rdb.Subscribe(c.ctx, GlobalConfigChannel)
-	ch := make(chan ConfigMessage, 10)
+	pubsub := c.rdb.Subscribe(c.ctx, c.channel)
+	ch := make(chan models.ConfigEnvelope, 10)
 
 	go func() {
 		defer close(ch)
@@ -132,16 +143,16 @@ func (c *Client) SubscribeToConfig() (<-chan ConfigMessage, error) {
 					continue
 				}
 
-				var configMsg ConfigMessage
-				if err := json.Unmarshal([]byte(msg.Payload), &configMsg); err != nil {
-					logger.Log.Errorf("Failed to unmarshal config message: %v", err)
+				envelope, err := models.DecodeConfigEnvelope([]byte(msg.Payload))
+				if err != nil {
+					logger.Log.Errorf("Rejected config message from Redis: %v", err)
 					continue
 				}
 
-				logger.Log.Infof("Received config change from Redis: version %s", configMsg.Version)
+				logger.Log.Infof("Received config change from Redis: version %d", envelope.Version)
 
 				select {
-				case ch <- configMsg:
+				case ch <- *envelope:
 				case <-c.ctx.Done():
 					return
 				default:
@@ -155,15 +166,8 @@ func (c *Client) SubscribeToConfig() (<-chan ConfigMessage, error) {
 	return ch, nil
 }
 
-// ConfigMessage represents a configuration message in Redis
-type ConfigMessage struct {
-	Config    models.WorkerConfig `json:"config"`
-	Version   string              `json:"version"`
-	Timestamp time.Time           `json:"timestamp"`
-}
-
 // GetConfigFromRedis retrieves the latest config from Redis (for backup)
-func (c *Client) GetConfigFromRedis() (*ConfigMessage, error) {
+func (c *Client) GetConfigFromRedis() (*models.ConfigEnvelope, error) {
 	if c == nil {
 		return nil, nil
 	}
@@ -176,32 +180,75 @@ func (c *Client) GetConfigFromRedis() (*ConfigMessage, error) {
 		return nil, err
 	}
 
-	var configMsg ConfigMessage
-	err = json.Unmarshal([]byte(data), &configMsg)
-	if err != nil {
-		return nil, err
-	}
-
-	return &configMsg, nil
+	return models.DecodeConfigEnvelope([]byte(data))
 }
 
 // StoreConfigInRedis stores the latest config in Redis for backup
-func (c *Client) StoreConfigInRedis(config models.WorkerConfig, version string) error {
+func (c *Client) StoreConfigInRedis(envelope *models.ConfigEnvelope) error {
 	if c == nil {
 		return nil
 	}
 
-	message := ConfigMessage{
-		Config:    config,
-		Version:   version,
-		Timestamp: time.Now(),
-	}
-
-	data, err := json.Marshal(message)
+	data, err := json.Marshal(envelope)
 	if err != nil {
 		return err
 	}
 
 	// Store with expiration (24 hours)
 	return c.rdb.Set(c.ctx, "latest_config", data, 24*time.Hour).Err()
-}
\ No newline at end of file
+}
+
+// PublishAck reports the outcome of applying a pushed config to the controller
+func (c *Client) PublishAck(ack models.ConfigAck) error {
+	if c == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(ack)
+	if err != nil {
+		return err
+	}
+
+	return c.rdb.Publish(c.ctx, AckChannel, data).Err()
+}
+
+// SubscribeToAcks subscribes to config acknowledgements published by agents
+func (c *Client) SubscribeToAcks() (<-chan models.ConfigAck, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	pubsub := c.rdb.Subscribe(c.ctx, AckChannel)
+	ch := make(chan models.ConfigAck, 100)
+
+	go func() {
+		defer close(ch)
+		defer pubsub.Close()
+
+		for {
+			msg, err := pubsub.ReceiveMessage(c.ctx)
+			if err != nil {
+				if c.ctx.Err() != nil {
+					return
+				}
+				logger.Log.Errorf("Error receiving Redis ack: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+
+			var ack models.ConfigAck
+			if err := json.Unmarshal([]byte(msg.Payload), &ack); err != nil {
+				logger.Log.Errorf("Rejected ack message from Redis: %v", err)
+				continue
+			}
+
+			select {
+			case ch <- ack:
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,245 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/models"
+)
+
+var (
+	// ErrUnsigned is returned when a configuration carries no signature
+	ErrUnsigned = errors.New("configuration is not signed")
+	// ErrInvalidSignature is returned when a signature does not match the configuration
+	ErrInvalidSignature = errors.New("configuration signature is invalid")
+)
+
+// Payload is the content of a configuration version covered by a signature.
+// Origin, Timestamp and Resync are set for pushed envelopes, so they cannot be
+// altered or replayed with different metadata; they are empty for polled
+// configurations.
+type Payload struct {
+	Version   int64
+	Config    models.WorkerConfig
+	Origin    string
+	Timestamp time.Time
+	Resync    bool
+}
+
+// EnvelopePayload returns the signed content of a pushed envelope
+func EnvelopePayload(envelope *models.ConfigEnvelope) Payload {
+	return Payload{
+		Version:   envelope.Version,
+		Config:    envelope.Config,
+		Origin:    envelope.Origin,
+		Timestamp: envelope.Timestamp,
+		Resync:    envelope.Resync,
+	}
+}
+
+// ResponsePayload returns the signed content of a polled or cached configuration
+func ResponsePayload(response *models.ConfigResponse) Payload {
+	payload := Payload{
+		Version: response.Version,
+		Config:  response.Data,
+		Origin:  response.Origin,
+		Resync:  response.Resync,
+	}
+	if response.Timestamp != nil {
+		payload.Timestamp = *response.Timestamp
+	}
+	return payload
+}
+
+// canonical is the encoded form of a payload. Field order is fixed by the
+// struct and the timestamp is normalized to UTC, so the JSON encoding is
+// deterministic; empty metadata is omitted.
+type canonical struct {
+	Version   int64               `json:"version"`
+	Config    models.WorkerConfig `json:"config"`
+	Origin    string              `json:"origin,omitempty"`
+	Timestamp string              `json:"timestamp,omitempty"`
+	Resync    bool                `json:"resync,omitempty"`
+}
+
+// Canonical returns the bytes that are signed for a payload
+func Canonical(p Payload) ([]byte, error) {
+	c := canonical{Version: p.Version, Config: p.Config, Origin: p.Origin, Resync: p.Resync}
+	if !p.Timestamp.IsZero() {
+		c.Timestamp = p.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	return json.Marshal(c)
+}
+
+// Signer signs configuration versions with an Ed25519 private key
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// NewSigner creates a signer from a private key
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key}
+}
+
+// LoadOrCreateSigner loads the signing key from keyFile, generating a new key
+// when it does not exist yet. The public key is written to publicKeyFile if
+// missing so it can be distributed to agents.
+func LoadOrCreateSigner(keyFile, publicKeyFile string) (*Signer, error) {
+	if _, err := os.Stat(keyFile); os.IsNotExist(err) {
+		if err := createKey(keyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	signer, err := LoadSigner(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if publicKeyFile != "" {
+		if _, err := os.Stat(publicKeyFile); os.IsNotExist(err) {
+			publicPEM, err := EncodePublicKey(signer.PublicKey())
+			if err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(publicKeyFile, publicPEM, 0644); err != nil {
+				return nil, fmt.Errorf("failed to write signing public key: %w", err)
+			}
+		}
+	}
+
+	return signer, nil
+}
+
+// LoadSigner reads a PEM-encoded PKCS#8 Ed25519 private key
+func LoadSigner(keyFile string) (*Signer, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an Ed25519 key")
+	}
+
+	return NewSigner(key), nil
+}
+
+func createKey(keyFile string) error {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return fmt.Errorf("failed to marshal signing key: %w", err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600); err != nil {
+		return fmt.Errorf("failed to write signing key: %w", err)
+	}
+	return nil
+}
+
+// PublicKey returns the public half of the signing key
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// Sign returns the base64-encoded signature of a configuration version, or an
+// empty string when signing is not configured
+func (s *Signer) Sign(p Payload) (string, error) {
+	if s == nil {
+		return "", nil
+	}
+
+	data, err := Canonical(p)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode config for signing: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, data)), nil
+}
+
+// Verifier checks configuration signatures against a pinned public key
+type Verifier struct {
+	key ed25519.PublicKey
+}
+
+// NewVerifier creates a verifier for a public key
+func NewVerifier(key ed25519.PublicKey) *Verifier {
+	return &Verifier{key: key}
+}
+
+// LoadVerifier reads a PEM-encoded Ed25519 public key
+func LoadVerifier(publicKeyFile string) (*Verifier, error) {
+	data, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing public key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing public key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing public key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("signing public key is not an Ed25519 key")
+	}
+
+	return NewVerifier(key), nil
+}
+
+// Verify checks the signature of a configuration version. A nil verifier
+// accepts everything, which is used when no public key is pinned.
+func (v *Verifier) Verify(p Payload, signature string) error {
+	if v == nil {
+		return nil
+	}
+	if signature == "" {
+		return ErrUnsigned
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	data, err := Canonical(p)
+	if err != nil {
+		return fmt.Errorf("failed to encode config for verification: %w", err)
+	}
+	if !ed25519.Verify(v.key, data, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// EncodePublicKey returns the PEM encoding of an Ed25519 public key
+func EncodePublicKey(key ed25519.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal signing public key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
@@ -0,0 +1,104 @@
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/doniyusdinar/config-management/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigner(t *testing.T) (*Signer, *Verifier) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return NewSigner(private), NewVerifier(public)
+}
+
+func signedEnvelope(t *testing.T, signer *Signer) *models.ConfigEnvelope {
+	envelope, err := models.NewConfigEnvelope(3, models.WorkerConfig{URL: "https://ip.me"}, "controller-1")
+	require.NoError(t, err)
+	envelope.Signature, err = signer.Sign(EnvelopePayload(envelope))
+	require.NoError(t, err)
+	return envelope
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+
+	envelope := signedEnvelope(t, signer)
+	assert.NoError(t, verifier.Verify(EnvelopePayload(envelope), envelope.Signature))
+
+	response := models.ConfigResponse{Version: 3, Data: models.WorkerConfig{URL: "https://ip.me"}}
+	sig, err := signer.Sign(ResponsePayload(&response))
+	require.NoError(t, err)
+	assert.NoError(t, verifier.Verify(ResponsePayload(&response), sig))
+
+	// The timestamp is signed in UTC, so a different zone still verifies
+	envelope.Timestamp = envelope.Timestamp.In(time.FixedZone("UTC+7", 7*60*60))
+	assert.NoError(t, verifier.Verify(EnvelopePayload(envelope), envelope.Signature))
+}
+
+func TestVerifyRejectsTamperedPayload(t *testing.T) {
+	signer, verifier := newTestSigner(t)
+
+	tamper := map[string]func(e *models.ConfigEnvelope){
+		"config":    func(e *models.ConfigEnvelope) { e.Config.URL = "https://evil.example.com" },
+		"version":   func(e *models.ConfigEnvelope) { e.Version++ },
+		"origin":    func(e *models.ConfigEnvelope) { e.Origin = "controller-2" },
+		"timestamp": func(e *models.ConfigEnvelope) { e.Timestamp = e.Timestamp.Add(time.Hour) },
+		"resync":    func(e *models.ConfigEnvelope) { e.Resync = true },
+	}
+	for field, modify := range tamper {
+		t.Run(field, func(t *testing.T) {
+			envelope := signedEnvelope(t, signer)
+			modify(envelope)
+			assert.ErrorIs(t, verifier.Verify(EnvelopePayload(envelope), envelope.Signature), ErrInvalidSignature)
+		})
+	}
+
+	envelope := signedEnvelope(t, signer)
+	assert.ErrorIs(t, verifier.Verify(EnvelopePayload(envelope), "not base64!"), ErrInvalidSignature)
+	assert.ErrorIs(t, verifier.Verify(EnvelopePayload(envelope), ""), ErrUnsigned)
+
+	// A signature from another key is rejected
+	other, _ := newTestSigner(t)
+	assert.ErrorIs(t, verifier.Verify(EnvelopePayload(envelope), signedEnvelope(t, other).Signature), ErrInvalidSignature)
+}
+
+func TestNilSignerAndVerifier(t *testing.T) {
+	var signer *Signer
+	var verifier *Verifier
+
+	envelope, err := models.NewConfigEnvelope(1, models.WorkerConfig{URL: "https://ip.me"}, "controller-1")
+	require.NoError(t, err)
+
+	sig, err := signer.Sign(EnvelopePayload(envelope))
+	require.NoError(t, err)
+	assert.Empty(t, sig)
+
+	// Without a pinned key every configuration is accepted, signed or not
+	assert.NoError(t, verifier.Verify(EnvelopePayload(envelope), ""))
+	assert.NoError(t, verifier.Verify(EnvelopePayload(envelope), "garbage"))
+}
+
+func TestLoadOrCreateSigner(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, publicKeyFile := filepath.Join(dir, "signing.key"), filepath.Join(dir, "signing.pub")
+
+	signer, err := LoadOrCreateSigner(keyFile, publicKeyFile)
+	require.NoError(t, err)
+	verifier, err := LoadVerifier(publicKeyFile)
+	require.NoError(t, err)
+
+	envelope := signedEnvelope(t, signer)
+	assert.NoError(t, verifier.Verify(EnvelopePayload(envelope), envelope.Signature))
+
+	// The existing key is reused
+	reloaded, err := LoadOrCreateSigner(keyFile, publicKeyFile)
+	require.NoError(t, err)
+	assert.Equal(t, signer.PublicKey(), reloaded.PublicKey())
+}
@@ -2,17 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/mtls"
 	"github.com/doniyusdinar/config-management/worker/internal/api"
 	"github.com/doniyusdinar/config-management/worker/internal/config"
 	"github.com/doniyusdinar/config-management/worker/internal/proxy"
+	"github.com/gin-gonic/gin"
 
 	_ "github.com/doniyusdinar/config-management/worker/docs"
 )
@@ -43,17 +48,61 @@ func main() {
 	proxyClient := proxy.NewProxy()
 
 	handler := api.NewHandler(configMgr, proxyClient)
-	router := api.SetupRouter(handler)
+
+	// With TLS enabled, config pushes must come from an agent with a verified
+	// client certificate; /hit and /health stay reachable without one.
+	reloadCtx, stopReload := context.WithCancel(context.Background())
+	defer stopReload()
+
+	tlsConfig := mtls.Config{
+		CertFile:       getEnv("TLS_CERT_FILE", ""),
+		KeyFile:        getEnv("TLS_KEY_FILE", ""),
+		CAFile:         getEnv("TLS_CLIENT_CA_FILE", ""),
+		ClientAuth:     tls.VerifyClientCertIfGiven,
+		ReloadInterval: time.Duration(getEnvInt("TLS_RELOAD_INTERVAL", 30)) * time.Second,
+	}
+
+	var reloader *mtls.Reloader
+	var router *gin.Engine
+	if tlsConfig.CertFile != "" {
+		if tlsConfig.CAFile == "" {
+			logger.Log.Fatalf("TLS_CLIENT_CA_FILE is required with TLS_CERT_FILE to verify agent certificates")
+		}
+		var err error
+		reloader, err = mtls.NewReloader(tlsConfig)
+		if err != nil {
+			logger.Log.Fatalf("Failed to load TLS certificates: %v", err)
+		}
+		go reloader.Start(reloadCtx)
+
+		var allowed []string
+		if value := getEnv("TLS_ALLOWED_AGENTS", ""); value != "" {
+			allowed = strings.Split(value, ",")
+		}
+		router = api.SetupRouter(handler, api.ClientCertAuthMiddleware(allowed))
+		logger.Log.Info("TLS enabled for worker listener, config pushes require a client certificate")
+	} else {
+		router = api.SetupRouter(handler)
+	}
 
 	srv := &http.Server{
 		Addr:    fmt.Sprintf(":%s", port),
 		Handler: router,
 	}
+	if reloader != nil {
+		srv.TLSConfig = reloader.ServerTLSConfig()
+	}
 
 	go func() {
 		logger.Log.Infof("Worker listening on port %s", port)
 		logger.Log.Infof("Swagger docs available at http://localhost:%s/swagger/index.html", port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logger.Log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -80,3 +129,12 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if intVal, err := strconv.Atoi(value); err == nil {
+			return intVal
+		}
+	}
+	return defaultValue
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/doniyusdinar/config-management/pkg/logger"
 	"github.com/doniyusdinar/config-management/pkg/models"
@@ -29,10 +30,21 @@ func NewHandler(configMgr *config.Manager, proxy *proxy.Proxy) *Handler {
 // @Accept json
 // @Produce json
 // @Param config body models.WorkerConfig true "Worker configuration"
+// @Param X-Config-Version header int false "Configuration version"
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
 // @Router /config [post]
 func (h *Handler) UpdateConfig(c *gin.Context) {
+	var version int64
+	if header := c.GetHeader(models.ConfigVersionHeader); header != "" {
+		parsed, err := strconv.ParseInt(header, 10, 64)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid config version"})
+			return
+		}
+		version = parsed
+	}
+
 	var config models.WorkerConfig
 	if err := c.ShouldBindJSON(&config); err != nil {
 		logger.Log.Errorf("Invalid config: %v", err)
@@ -40,12 +52,23 @@ func (h *Handler) UpdateConfig(c *gin.Context) {
 		return
 	}
 
-	h.configMgr.UpdateConfig(config)
-	logger.Log.Infof("New configuration received: %+v", config)
+	h.configMgr.UpdateConfigVersion(config, version)
+	logger.Log.Infof("New configuration received (version %d): %+v", version, config)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Configuration updated"})
 }
 
+// ConfigStatus godoc
+// @Summary Get applied configuration version
+// @Description Report the version and content hash of the configuration the worker applies, so agents can repair drift
+// @Tags config
+// @Produce json
+// @Success 200 {object} models.WorkerConfigStatus
+// @Router /config/status [get]
+func (h *Handler) ConfigStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.configMgr.Status())
+}
+
 // Hit godoc
 // @Summary Execute configured task
 // @Description Execute HTTP GET request to configured URL and return response
@@ -66,7 +89,7 @@ func (h *Handler) Hit(c *gin.Context) {
 
 	logger.Log.Infof("Executing request to: %s", config.URL)
 
-	body, statusCode, err := h.proxy.ExecuteRequest(config.URL)
+	body, statusCode, err := h.proxy.ExecuteRequest(config.URL, config.RequestHeaders())
 	if err != nil {
 		logger.Log.Errorf("Request failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
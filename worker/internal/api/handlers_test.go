@@ -2,11 +2,16 @@ package api
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
+	"github.com/doniyusdinar/config-management/pkg/logger"
 	"github.com/doniyusdinar/config-management/pkg/models"
 	"github.com/doniyusdinar/config-management/worker/internal/config"
 	"github.com/doniyusdinar/config-management/worker/internal/proxy"
@@ -67,6 +72,33 @@ func TestUpdateConfig(t *testing.T) {
 	assert.Equal(t, "https://example.com", storedConfig.URL)
 }
 
+func TestUpdateConfigRedactsSecretsInLogs(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.POST("/config", handler.UpdateConfig)
+
+	var logs bytes.Buffer
+	logger.Log.SetOutput(&logs)
+	defer logger.Log.SetOutput(os.Stdout)
+
+	workerConfig := models.WorkerConfig{
+		URL:           "https://example.com",
+		SecretHeaders: map[string]models.Secret{"Authorization": "Bearer s3cret"},
+	}
+	body, _ := json.Marshal(workerConfig)
+
+	req := httptest.NewRequest(http.MethodPost, "/config", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, logs.String(), "New configuration received")
+	assert.NotContains(t, logs.String(), "s3cret")
+
+	storedConfig, _ := handler.configMgr.GetConfig()
+	assert.Equal(t, models.Secret("Bearer s3cret"), storedConfig.SecretHeaders["Authorization"])
+}
+
 func TestUpdateConfigInvalidJSON(t *testing.T) {
 	handler, router := setupTestHandler()
 	router.POST("/config", handler.UpdateConfig)
@@ -132,3 +164,69 @@ func TestHitWithInvalidURL(t *testing.T) {
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 }
+
+func TestUpdateConfigRequiresClientCert(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.POST("/config", ClientCertAuthMiddleware([]string{"agent-1"}), handler.UpdateConfig)
+
+	send := func(state *tls.ConnectionState) int {
+		body, _ := json.Marshal(models.WorkerConfig{URL: "https://example.com"})
+		req := httptest.NewRequest(http.MethodPost, "/config", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.TLS = state
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	verified := func(name string) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: name}}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, send(nil))
+	assert.Equal(t, http.StatusForbidden, send(verified("agent-9")))
+	assert.Equal(t, http.StatusOK, send(verified("agent-1")))
+	assert.True(t, handler.configMgr.HasConfig())
+}
+
+func TestConfigStatusReportsVersion(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.POST("/config", handler.UpdateConfig)
+	router.GET("/config/status", handler.ConfigStatus)
+
+	status := func() models.WorkerConfigStatus {
+		req := httptest.NewRequest(http.MethodGet, "/config/status", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response models.WorkerConfigStatus
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+	assert.False(t, status().HasConfig)
+
+	workerConfig := models.WorkerConfig{URL: "https://example.com"}
+	body, _ := json.Marshal(workerConfig)
+	req := httptest.NewRequest(http.MethodPost, "/config", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(models.ConfigVersionHeader, "7")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	hash, _ := models.ContentHash(workerConfig)
+	response := status()
+	assert.True(t, response.HasConfig)
+	assert.Equal(t, int64(7), response.Version)
+	assert.Equal(t, hash, response.ContentHash)
+	assert.NotNil(t, response.AppliedAt)
+
+	req = httptest.NewRequest(http.MethodPost, "/config", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(models.ConfigVersionHeader, "seven")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, int64(7), status().Version)
+}
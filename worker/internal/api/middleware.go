@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/doniyusdinar/config-management/pkg/logger"
+	"github.com/doniyusdinar/config-management/pkg/mtls"
+	"github.com/gin-gonic/gin"
+)
+
+// ClientCertAuthMiddleware requires a verified client certificate. When allowed
+// is non-empty, the certificate identity must also be one of the listed agents.
+func ClientCertAuthMiddleware(allowed []string) gin.HandlerFunc {
+	allowedSet := make(map[string]bool, len(allowed))
+	for _, identity := range allowed {
+		allowedSet[identity] = true
+	}
+
+	return func(c *gin.Context) {
+		identity := mtls.Identity(c.Request.TLS)
+		if identity == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Client certificate required"})
+			c.Abort()
+			return
+		}
+
+		if len(allowedSet) > 0 && !allowedSet[identity] {
+			logger.Log.Warnf("Rejected config push from unknown agent: %s", identity)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+
+		c.Set("agent_id", identity)
+		c.Next()
+	}
+}
@@ -6,13 +6,15 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
-func SetupRouter(handler *Handler) *gin.Engine {
+// SetupRouter registers the worker routes; configAuth guards the config endpoints
+func SetupRouter(handler *Handler, configAuth ...gin.HandlerFunc) *gin.Engine {
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
 	router.GET("/health", handler.HealthCheck)
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	router.POST("/config", handler.UpdateConfig)
+	router.POST("/config", append(configAuth, handler.UpdateConfig)...)
+	router.GET("/config/status", append(configAuth, handler.ConfigStatus)...)
 	router.GET("/hit", handler.Hit)
 
 	return router
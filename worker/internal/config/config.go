@@ -2,14 +2,19 @@ package config
 
 import (
 	"sync"
+	"time"
 
+	"github.com/doniyusdinar/config-management/pkg/logger"
 	"github.com/doniyusdinar/config-management/pkg/models"
 )
 
 type Manager struct {
-	mu        sync.RWMutex
-	config    models.WorkerConfig
-	hasConfig bool
+	mu          sync.RWMutex
+	config      models.WorkerConfig
+	hasConfig   bool
+	version     int64
+	contentHash string
+	appliedAt   time.Time
 }
 
 func NewManager() *Manager {
@@ -25,10 +30,38 @@ func (m *Manager) GetConfig() (models.WorkerConfig, bool) {
 
 // UpdateConfig updates the configuration
 func (m *Manager) UpdateConfig(config models.WorkerConfig) {
+	m.UpdateConfigVersion(config, 0)
+}
+
+// UpdateConfigVersion updates the configuration and records its version
+func (m *Manager) UpdateConfigVersion(config models.WorkerConfig, version int64) {
+	hash, err := models.ContentHash(config)
+	if err != nil {
+		logger.Log.Warnf("Failed to hash configuration: %v", err)
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.config = config
 	m.hasConfig = true
+	m.version = version
+	m.contentHash = hash
+	m.appliedAt = time.Now()
+}
+
+// Status returns the version and content hash of the current configuration
+func (m *Manager) Status() models.WorkerConfigStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := models.WorkerConfigStatus{HasConfig: m.hasConfig}
+	if m.hasConfig {
+		appliedAt := m.appliedAt
+		status.Version = m.version
+		status.ContentHash = m.contentHash
+		status.AppliedAt = &appliedAt
+	}
+	return status
 }
 
 // HasConfig returns whether configuration has been set
@@ -69,3 +69,22 @@ func TestConcurrentAccess(t *testing.T) {
 	assert.True(t, hasConfig)
 	assert.Equal(t, "https://example.com", retrieved.URL)
 }
+
+func TestStatusTracksVersion(t *testing.T) {
+	mgr := NewManager()
+	assert.Equal(t, models.WorkerConfigStatus{}, mgr.Status())
+
+	config := models.WorkerConfig{URL: "https://example.com"}
+	mgr.UpdateConfigVersion(config, 3)
+
+	hash, err := models.ContentHash(config)
+	assert.NoError(t, err)
+	status := mgr.Status()
+	assert.True(t, status.HasConfig)
+	assert.Equal(t, int64(3), status.Version)
+	assert.Equal(t, hash, status.ContentHash)
+
+	// Configs pushed without a version report version 0
+	mgr.UpdateConfig(config)
+	assert.Equal(t, int64(0), mgr.Status().Version)
+}
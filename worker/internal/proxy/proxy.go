@@ -19,9 +19,17 @@ func NewProxy() *Proxy {
 	}
 }
 
-// ExecuteRequest performs an HTTP GET request to the target URL
-func (p *Proxy) ExecuteRequest(targetURL string) ([]byte, int, error) {
-	resp, err := p.client.Get(targetURL)
+// ExecuteRequest performs an HTTP GET request to the target URL with the configured headers
+func (p *Proxy) ExecuteRequest(targetURL string, headers map[string]string) ([]byte, int, error) {
+	req, err := http.NewRequest(http.MethodGet, targetURL, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to execute request: %w", err)
 	}
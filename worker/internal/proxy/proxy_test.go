@@ -19,7 +19,7 @@ func TestExecuteRequestSuccess(t *testing.T) {
 	defer server.Close()
 
 	proxy := NewProxy()
-	body, status, err := proxy.ExecuteRequest(server.URL)
+	body, status, err := proxy.ExecuteRequest(server.URL, nil)
 
 	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, status)
@@ -46,7 +46,7 @@ func TestExecuteRequestDifferentStatusCodes(t *testing.T) {
 			defer server.Close()
 
 			proxy := NewProxy()
-			_, status, err := proxy.ExecuteRequest(server.URL)
+			_, status, err := proxy.ExecuteRequest(server.URL, nil)
 
 			require.NoError(t, err)
 			assert.Equal(t, tt.expectedStatus, status)
@@ -56,7 +56,7 @@ func TestExecuteRequestDifferentStatusCodes(t *testing.T) {
 
 func TestExecuteRequestInvalidURL(t *testing.T) {
 	proxy := NewProxy()
-	_, _, err := proxy.ExecuteRequest("http://invalid-url-that-does-not-exist:9999")
+	_, _, err := proxy.ExecuteRequest("http://invalid-url-that-does-not-exist:9999", nil)
 
 	assert.Error(t, err)
 }
@@ -75,7 +75,7 @@ func TestExecuteRequestLargeResponse(t *testing.T) {
 	defer server.Close()
 
 	proxy := NewProxy()
-	body, status, err := proxy.ExecuteRequest(server.URL)
+	body, status, err := proxy.ExecuteRequest(server.URL, nil)
 
 	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, status)
@@ -91,7 +91,21 @@ func TestExecuteRequestWithHeaders(t *testing.T) {
 	defer server.Close()
 
 	proxy := NewProxy()
-	_, status, err := proxy.ExecuteRequest(server.URL)
+	_, status, err := proxy.ExecuteRequest(server.URL, nil)
+
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestExecuteRequestSendsConfiguredHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer s3cret", r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	proxy := NewProxy()
+	_, status, err := proxy.ExecuteRequest(server.URL, map[string]string{"Authorization": "Bearer s3cret"})
 
 	require.NoError(t, err)
 	assert.Equal(t, http.StatusOK, status)